@@ -0,0 +1,102 @@
+package httpsrv
+
+import (
+	"net"
+	"sync"
+)
+
+/*
+AcceptControl returns param which makes the server's listener pausable and the controller to
+pause and resume accepting new connections at runtime, ie to freeze the intake during a risky
+maintenance operation. Pausing doesn't shut down the server nor affect the existing connections,
+requests on them are still served.
+
+While paused the listening socket stays open so the connections of the clients are queued in
+the listen backlog (at most single connection already accepted by the listener is held) and
+served once accepting is resumed. Shutting down the server while paused works as usual.
+*/
+func AcceptControl() (ServerParam, *AcceptController) {
+	c := &AcceptController{}
+	return serverParam{func(cfg *serverConf) { cfg.acceptCtl = c }}, c
+}
+
+/*
+AcceptController pauses and resumes accepting new connections by the server, see [AcceptControl].
+*/
+type AcceptController struct {
+	m      sync.Mutex
+	resume chan struct{} // closed when accepting is resumed, nil when not paused
+}
+
+// PauseAccept stops accepting new connections until [AcceptController.ResumeAccept] is called.
+func (c *AcceptController) PauseAccept() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+}
+
+// ResumeAccept resumes accepting new connections paused by [AcceptController.PauseAccept].
+func (c *AcceptController) ResumeAccept() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// paused returns chan which is closed when accepting is resumed, nil when not paused.
+func (c *AcceptController) paused() <-chan struct{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.resume
+}
+
+func (c *AcceptController) listener(l net.Listener) net.Listener {
+	return &pausableListener{Listener: l, ctl: c, closed: make(chan struct{})}
+}
+
+type pausableListener struct {
+	net.Listener
+	ctl       *AcceptController
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pausableListener) Accept() (net.Conn, error) {
+	// wait before accepting so that the connections stay in the backlog
+	if err := l.wait(); err != nil {
+		return nil, err
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	// paused while we were blocked in Accept
+	if err := l.wait(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// wait blocks while accepting is paused, returns error when the listener is closed.
+func (l *pausableListener) wait() error {
+	resume := l.ctl.paused()
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-l.closed:
+		return net.ErrClosed
+	}
+}
+
+func (l *pausableListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
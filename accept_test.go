@@ -0,0 +1,79 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_AcceptControl(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	url := "http://" + ln.Addr().String()
+
+	param, ctl := AcceptControl()
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln), param, ShutdownTimeout(time.Second))
+	}()
+
+	// get sends request using the client and returns chan which receives the result
+	get := func(c *http.Client) chan error {
+		done := make(chan error, 1)
+		go func() {
+			rsp, err := c.Get(url)
+			if err == nil {
+				rsp.Body.Close()
+			}
+			done <- err
+		}()
+		return done
+	}
+
+	keepAlive := &http.Client{Timeout: 2 * time.Second}
+	if err := <-get(keepAlive); err != nil {
+		t.Fatalf("GET request failed: %v", err)
+	}
+
+	ctl.PauseAccept()
+	// new connection is not served while paused
+	newConn := get(&http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}})
+	select {
+	case err := <-newConn:
+		t.Fatalf("request on new connection completed while accepting is paused: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	// existing connection is still served
+	if err := <-get(keepAlive); err != nil {
+		t.Errorf("GET request on existing connection failed: %v", err)
+	}
+
+	ctl.ResumeAccept()
+	select {
+	case err := <-newConn:
+		if err != nil {
+			t.Errorf("GET request on new connection failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request on new connection wasn't served after resume")
+	}
+
+	// server can be stopped while paused
+	ctl.PauseAccept()
+	cancel()
+	select {
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Error("Run didn't return within timeout")
+	}
+}
@@ -0,0 +1,121 @@
+package httpsrv
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+AdaptiveShutdown makes the graceful shutdown timeout (see [ShutdownTimeout]) to be computed
+from the in-flight requests when the shutdown starts, instead of using fixed value. For every
+request being served the remaining time is estimated - when the request context has deadline
+it is the time left until the deadline, otherwise it is the moving average of the service time
+minus the age of the request. The timeout is the longest of these estimates bounded by min and
+max, when there are no requests in flight the min is used.
+
+This avoids waiting for the full max when the requests are short while giving the long ones
+a chance to complete. Keep in mind that requests arriving during the [ShutdownDelay] are not
+accounted for as the timeout is evaluated when the shutdown starts (like [ShutdownTimeoutFunc]).
+
+When AdaptiveShutdown is used together with ShutdownTimeout, ShutdownTimeoutFunc or [ScaledShutdown]
+the one given last wins.
+*/
+func AdaptiveShutdown(min, max time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		a := &adaptiveShutdown{min: min, max: max, inFlight: make(map[*http.Request]time.Time)}
+		cfg.adaptive = a
+		cfg.shutdownTOFunc = a.timeout
+	}}
+}
+
+type adaptiveShutdown struct {
+	min, max time.Duration
+	avgTime  movingAverage
+
+	m        sync.Mutex
+	inFlight map[*http.Request]time.Time // start time of the request
+}
+
+func (a *adaptiveShutdown) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.m.Lock()
+		a.inFlight[r] = start
+		a.m.Unlock()
+		defer func() {
+			a.m.Lock()
+			delete(a.inFlight, r)
+			a.m.Unlock()
+			a.avgTime.add(time.Since(start))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeout returns the estimated time the in-flight requests need to complete, bounded by min and max.
+func (a *adaptiveShutdown) timeout() time.Duration {
+	now, avg := time.Now(), a.avgTime.value()
+	var to time.Duration
+	a.m.Lock()
+	for r, start := range a.inFlight {
+		remaining := avg - now.Sub(start)
+		if d, ok := r.Context().Deadline(); ok {
+			remaining = d.Sub(now)
+		}
+		to = max(to, remaining)
+	}
+	a.m.Unlock()
+	return min(max(to, a.min), a.max)
+}
+
+/*
+ScaledShutdown makes the graceful shutdown timeout (see [ShutdownTimeout]) to be proportional to
+the number of connections being served when the shutdown starts: the timeout is perConn times the
+number of connections, bounded by min and max. This way lightly loaded instance drains fast while
+the heavily loaded one gets proportionally more time. Connections are counted from the moment they
+are accepted until they become idle (or are closed or hijacked) as idle connections are closed
+right away by the shutdown.
+
+The timeout is evaluated when the shutdown starts, before the [ShutdownDelay], so the connections
+of the requests arriving during the delay are not accounted for - the min is the budget for them
+(and the budget reported by [OnReady], as there are no connections at the start). When min is
+zero and there are no connections the server is closed immediately, cutting off the requests
+which arrived during the delay.
+
+When ScaledShutdown is used together with ShutdownTimeout, ShutdownTimeoutFunc or [AdaptiveShutdown]
+the one given last wins.
+*/
+func ScaledShutdown(perConn, min, max time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		ss := &scaledShutdown{perConn: perConn, min: min, max: max, conns: make(map[net.Conn]struct{})}
+		cfg.connState = append(cfg.connState, ss.track)
+		cfg.shutdownTOFunc = ss.timeout
+	}}
+}
+
+type scaledShutdown struct {
+	perConn, min, max time.Duration
+
+	m     sync.Mutex
+	conns map[net.Conn]struct{} // connections which are not idle
+}
+
+func (ss *scaledShutdown) track(c net.Conn, state http.ConnState) {
+	ss.m.Lock()
+	defer ss.m.Unlock()
+	if state == http.StateNew || state == http.StateActive {
+		ss.conns[c] = struct{}{}
+	} else {
+		delete(ss.conns, c)
+	}
+}
+
+func (ss *scaledShutdown) timeout() time.Duration {
+	ss.m.Lock()
+	n := len(ss.conns)
+	ss.m.Unlock()
+	return min(max(time.Duration(n)*ss.perConn, ss.min), ss.max)
+}
@@ -0,0 +1,122 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_AdaptiveShutdown(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{}
+	AdaptiveShutdown(100*time.Millisecond, 2*time.Second).apply(cfg)
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/short" {
+			time.Sleep(20 * time.Millisecond)
+			return
+		}
+		entered <- struct{}{}
+		<-release
+	}))
+
+	// starts request which blocks until release is closed
+	var wg sync.WaitGroup
+	startRequest := func(deadline time.Duration) {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if deadline > 0 {
+			ctx, cancel = context.WithTimeout(ctx, deadline)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/long", nil).WithContext(ctx))
+		}()
+		<-entered
+	}
+
+	if to := cfg.shutdownTimeout(); to != 100*time.Millisecond {
+		t.Errorf("expected min timeout when there are no requests in flight, got %s", to)
+	}
+
+	// establish the average service time
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/short", nil))
+	}
+	// request without deadline is expected to complete within the average service time
+	startRequest(0)
+	if to := cfg.shutdownTimeout(); to != 100*time.Millisecond {
+		t.Errorf("expected min timeout when requests are short, got %s", to)
+	}
+
+	// long request with deadline
+	startRequest(time.Second)
+	if to := cfg.shutdownTimeout(); to < 900*time.Millisecond || to > time.Second {
+		t.Errorf("expected timeout to be time left until the request deadline, got %s", to)
+	}
+
+	// request with deadline beyond max
+	startRequest(time.Minute)
+	if to := cfg.shutdownTimeout(); to != 2*time.Second {
+		t.Errorf("expected max timeout, got %s", to)
+	}
+
+	close(release)
+	wg.Wait()
+	if to := cfg.shutdownTimeout(); to != 100*time.Millisecond {
+		t.Errorf("expected min timeout when requests have completed, got %s", to)
+	}
+}
+
+func Test_ScaledShutdown(t *testing.T) {
+	t.Parallel()
+
+	// returns config with n connections in given state
+	withConns := func(n int, state http.ConnState) *serverConf {
+		cfg := &serverConf{}
+		ScaledShutdown(100*time.Millisecond, 150*time.Millisecond, time.Second).apply(cfg)
+		for i := 0; i < n; i++ {
+			c, _ := net.Pipe()
+			for _, f := range cfg.connState {
+				f(c, http.StateNew)
+				if state != http.StateNew {
+					f(c, state)
+				}
+			}
+		}
+		return cfg
+	}
+
+	testCases := []struct {
+		name   string
+		conns  int
+		state  http.ConnState
+		expect time.Duration
+	}{
+		{name: "no connections", conns: 0, state: http.StateActive, expect: 150 * time.Millisecond},
+		{name: "single connection", conns: 1, state: http.StateActive, expect: 150 * time.Millisecond},
+		{name: "few connections", conns: 3, state: http.StateActive, expect: 300 * time.Millisecond},
+		{name: "just accepted connections", conns: 2, state: http.StateNew, expect: 200 * time.Millisecond},
+		{name: "many connections", conns: 50, state: http.StateActive, expect: time.Second},
+		{name: "idle connections", conns: 5, state: http.StateIdle, expect: 150 * time.Millisecond},
+		{name: "closed connections", conns: 5, state: http.StateClosed, expect: 150 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		if to := withConns(tc.conns, tc.state).shutdownTimeout(); to != tc.expect {
+			t.Errorf("%s: expected shutdown timeout %s, got %s", tc.name, tc.expect, to)
+		}
+	}
+
+	// with the min the graceful shutdown is reported at the start
+	cfg := withConns(0, http.StateActive)
+	cfg.srv = &http.Server{}
+	if info := cfg.readyInfo(nil); info.ShutdownMode != ShutdownGraceful || info.ShutdownTimeout != 150*time.Millisecond {
+		t.Errorf("expected graceful shutdown with the min timeout, got %s %s", info.ShutdownMode, info.ShutdownTimeout)
+	}
+}
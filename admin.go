@@ -0,0 +1,70 @@
+package httpsrv
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrShutdownRequested is the reason server was shut down when the shutdown was
+// requested using the handler returned by [ShutdownHandler].
+var ErrShutdownRequested = errors.New("shutdown requested via admin endpoint")
+
+/*
+ShutdownHandler returns param which allows to trigger graceful shutdown of the server and the
+handler triggering it, [Run] returns [ErrShutdownRequested]. This lets operators drain the
+instance in a controlled way without sending signals (ie in restricted environments). The
+handler is meant to be mounted on the admin server (separate [Run] on internal port):
+
+	param, shutdown := httpsrv.ShutdownHandler(os.Getenv("ADMIN_TOKEN"))
+	adminMux.Handle("/admin/shutdown", shutdown)
+
+The handler accepts only POST requests with "Authorization: Bearer <token>" header, on success
+it responds with status 202 (Accepted) and JSON object with the estimated drain time (the
+[ShutdownDelay] plus the [ShutdownTimeout]) in seconds, ie {"drain_seconds":25}. When the server
+is not running (or it is already shutting down) the response status is 503 (Service Unavailable).
+Empty token disables the handler, all requests get response with status 403 (Forbidden).
+*/
+func ShutdownHandler(token string) (ServerParam, http.Handler) {
+	h := &shutdownHandler{token: token}
+	return serverParam{func(cfg *serverConf) {
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) { h.cfg.Store(cfg) })
+	}}, h
+}
+
+type shutdownHandler struct {
+	token string
+	cfg   atomic.Pointer[serverConf] // config of the started server
+}
+
+func (h *shutdownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	cfg := h.cfg.Load()
+	if cfg == nil || cfg.runCtx.Err() != nil {
+		http.Error(w, "server is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	drain := cfg.shutdownDelay + max(cfg.shutdownTimeout(), 0)
+	if p := cfg.policy.Load(); p != nil {
+		drain = p.Delay + p.Timeout
+	}
+	cfg.stopRun(ErrShutdownRequested)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Drain float64 `json:"drain_seconds"`
+	}{Drain: drain.Seconds()})
+}
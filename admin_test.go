@@ -0,0 +1,88 @@
+package httpsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownHandler(t *testing.T) {
+	t.Parallel()
+
+	param, handler := ShutdownHandler("s3cret")
+
+	request := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/shutdown", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request("POST", "s3cret"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 when server is not running, got %d", rec.Code)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	infoC := make(chan ReadyInfo, 1)
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(context.Background(), &http.Server{Handler: http.NotFoundHandler()},
+			Listener(ln),
+			ShutdownDelay(100*time.Millisecond),
+			ShutdownTimeout(2*time.Second),
+			OnReady(func(ri ReadyInfo) { infoC <- ri }),
+			param,
+		)
+	}()
+	<-infoC
+
+	if rec := request("GET", "s3cret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for GET request, got %d", rec.Code)
+	}
+	if rec := request("POST", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 without token, got %d", rec.Code)
+	}
+	if rec := request("POST", "guess"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 with wrong token, got %d", rec.Code)
+	}
+	select {
+	case err := <-srvErr:
+		t.Fatalf("server stopped by unauthorized request: %v", err)
+	default:
+	}
+
+	rec := request("POST", "s3cret")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rec.Code)
+	}
+	var body struct {
+		Drain float64 `json:"drain_seconds"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Errorf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	if body.Drain != 2.1 {
+		t.Errorf("expected estimated drain time 2.1s, got %gs", body.Drain)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, ErrShutdownRequested)
+	}
+	if rec := request("POST", "s3cret"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 after server has stopped, got %d", rec.Code)
+	}
+}
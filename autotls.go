@@ -0,0 +1,154 @@
+package httpsrv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+AutoDetectTLS makes the server to serve both TLS and plaintext HTTP on the same port, for
+environments which can't use separate ports. The first byte of each connection is peeked to
+decide whether the client starts TLS handshake, such connections are served using TLS while
+the other connections are served as plaintext. The certificate must be configured using the
+[TLS] param or the server's TLSConfig, otherwise [Run] fails.
+
+The detection happens in the background so slow clients do not block accepting connections,
+the client has up to 10 seconds (or the server's ReadHeaderTimeout / ReadTimeout when set) to
+send the first byte.
+*/
+func AutoDetectTLS() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.autoTLS = true }}
+}
+
+// errAutoTLSCert is returned when AutoDetectTLS is used without certificate.
+var errAutoTLSCert = errors.New("AutoDetectTLS requires certificate, use TLS param or assign server's TLSConfig")
+
+// autoTLSListener wraps l into listener detecting TLS connections.
+func (cfg *serverConf) autoTLSListener(l net.Listener) (net.Listener, error) {
+	if !cfg.useTLS() {
+		return nil, errAutoTLSCert
+	}
+	// like ServeTLS the certificate files take precedence over the server's TLSConfig
+	tc := cfg.srv.TLSConfig.Clone()
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	if cfg.certFile != "" || cfg.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	// like ServeTLS offer HTTP/2 unless it has been disabled using TLSNextProto
+	if len(tc.NextProtos) == 0 {
+		if _, ok := cfg.srv.TLSNextProto["h2"]; ok || cfg.srv.TLSNextProto == nil {
+			tc.NextProtos = append(tc.NextProtos, "h2")
+		}
+	}
+	if !slices.Contains(tc.NextProtos, "http/1.1") {
+		tc.NextProtos = append(tc.NextProtos, "http/1.1")
+	}
+
+	timeout := 10 * time.Second
+	if to := cfg.srv.ReadHeaderTimeout; to > 0 {
+		timeout = to
+	} else if to := cfg.srv.ReadTimeout; to > 0 {
+		timeout = to
+	}
+
+	dl := &detectTLSListener{Listener: l, tls: tc, timeout: timeout, conns: make(chan net.Conn), done: make(chan struct{})}
+	go dl.acceptLoop()
+	return dl, nil
+}
+
+/*
+detectTLSListener accepts connections from the wrapped listener in the background and
+detects whether they are TLS connections.
+*/
+type detectTLSListener struct {
+	net.Listener
+	tls     *tls.Config
+	timeout time.Duration // how long to wait for the first byte
+
+	conns     chan net.Conn // detected connections
+	done      chan struct{} // closed when the listener is closed
+	closeOnce sync.Once
+	errMu     sync.Mutex
+	err       error // error returned by the wrapped listener's Accept
+}
+
+func (l *detectTLSListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.errMu.Lock()
+			l.err = err
+			l.errMu.Unlock()
+			l.close()
+			return
+		}
+		go l.detect(c)
+	}
+}
+
+func (l *detectTLSListener) detect(c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(l.timeout))
+	pc := &peekedConn{Conn: c, r: bufio.NewReaderSize(c, 1024)}
+	b, err := pc.r.Peek(1)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		c.Close()
+		return
+	}
+
+	var conn net.Conn = pc
+	// TLS record of type handshake
+	if b[0] == 0x16 {
+		conn = tls.Server(pc, l.tls)
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *detectTLSListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		l.errMu.Lock()
+		defer l.errMu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *detectTLSListener) close() { l.closeOnce.Do(func() { close(l.done) }) }
+
+func (l *detectTLSListener) Close() error {
+	l.close()
+	return l.Listener.Close()
+}
+
+// peekedConn is connection whose reads go through the buffered reader used to peek it.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
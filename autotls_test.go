@@ -0,0 +1,80 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_AutoDetectTLS(t *testing.T) {
+	t.Parallel()
+
+	t.Run("TLS and plaintext on the same port", func(t *testing.T) {
+		t.Parallel()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		certFile, keyFile := writeTestCertificate(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.TLS != nil {
+						io.WriteString(w, "secure "+r.Proto)
+					} else {
+						io.WriteString(w, "plain "+r.Proto)
+					}
+				})},
+				Listener(ln), TLS(certFile, keyFile), AutoDetectTLS(),
+			)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-srvErr
+		})
+
+		// idle client which never sends anything must not block other clients
+		idle, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dialing: %v", err)
+		}
+		defer idle.Close()
+
+		c := &http.Client{
+			Timeout:   time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true},
+		}
+		get := func(url, expect string) {
+			t.Helper()
+			rsp, err := c.Get(url)
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", url, err)
+			}
+			defer rsp.Body.Close()
+			b, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if string(b) != expect {
+				t.Errorf("expected %q, got %q", expect, b)
+			}
+		}
+		get("http://"+ln.Addr().String(), "plain HTTP/1.1")
+		get("https://"+ln.Addr().String(), "secure HTTP/2.0")
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		t.Parallel()
+		err := Run(context.Background(),
+			&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			AutoDetectTLS(),
+		)
+		expectError(t, err, errAutoTLSCert)
+	})
+}
@@ -0,0 +1,34 @@
+package httpsrv
+
+import (
+	"context"
+	"net/http"
+)
+
+/*
+WaitForBarrier makes [Run] to wait until the channel is closed before binding the listener,
+ie until the database migrations have completed. This way the port isn't opened (and the load
+balancer's health checks do not see the instance) prematurely, without the need to sequence
+the startup in the caller. When the Run's context is cancelled while waiting Run returns
+without binding.
+
+When the [Listener] param is used the listener is already bound, the barrier then delays
+serving (accepting connections).
+*/
+func WaitForBarrier(ch <-chan struct{}) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.barrier = ch }}
+}
+
+// waitForBarrier blocks until the barrier is released, http.ErrServerClosed is returned
+// when ctx is cancelled before that.
+func (cfg *serverConf) waitForBarrier(ctx context.Context) error {
+	select {
+	case <-cfg.barrier:
+		return nil
+	case <-ctx.Done():
+		if cfg.l != nil {
+			cfg.l.Close()
+		}
+		return http.ErrServerClosed
+	}
+}
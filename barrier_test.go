@@ -0,0 +1,68 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_WaitForBarrier(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bind after release", func(t *testing.T) {
+		t.Parallel()
+		barrier := make(chan struct{})
+		ready := make(chan net.Addr, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+				WaitForBarrier(barrier),
+				OnReady(func(ri ReadyInfo) { ready <- ri.Addr }),
+			)
+		}()
+
+		select {
+		case addr := <-ready:
+			t.Fatalf("listener was bound on %s before the barrier was released", addr)
+		case <-time.After(200 * time.Millisecond):
+		}
+		close(barrier)
+
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("listener wasn't bound after the barrier was released")
+		case addr := <-ready:
+			rsp, err := http.Get("http://" + addr.String())
+			if err != nil {
+				t.Fatalf("GET request failed: %v", err)
+			}
+			rsp.Body.Close()
+		}
+
+		cancel()
+		expectError(t, <-srvErr, context.Canceled)
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		t.Parallel()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err = Run(ctx, &http.Server{Handler: http.NotFoundHandler()}, Listener(ln), WaitForBarrier(make(chan struct{})))
+		var re *RunError
+		if !errors.As(err, &re) || re.Serve != nil || !errors.Is(re.Context, context.DeadlineExceeded) {
+			t.Errorf("expected only context error, got %v", err)
+		}
+		// the listener is owned by Run so it must have been closed
+		if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected listener to be closed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package httpsrv
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+LogBodies makes the server to log the request and response bodies (together with the method,
+path and status) of every request using the logger, which is invaluable when debugging API
+integrations. It is meant for debug mode only as it slows the server down and may leak sensitive
+data into the logs.
+
+At most maxBytes of each body is kept in memory, longer bodies are truncated (the total size
+is still logged). When maxBytes is zero no body is logged, only the method, path, status and
+the content type and size of the bodies, which makes it usable as request log in production. Only textual bodies (text/*, JSON, XML, form and JavaScript content types,
+content type is sniffed when not declared) are logged, for binary bodies only the content type
+and size is logged. Streamed responses (the handler flushes) are not logged either. When logger
+is nil [slog.Default] is used.
+
+The request body is logged as far as the handler has read it.
+*/
+func LogBodies(maxBytes int, logger *slog.Logger) ServerParam {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return serverParam{func(cfg *serverConf) { cfg.bodyLog = &bodyLogger{max: max(maxBytes, 0), log: logger} }}
+}
+
+type bodyLogger struct {
+	max int
+	log *slog.Logger
+}
+
+func (bl *bodyLogger) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody *bodyCapture
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = &bodyCapture{max: bl.max}
+			r.Body = &teeBody{ReadCloser: r.Body, c: reqBody}
+		}
+		bw := &bodyLogWriter{ResponseWriter: w, body: bodyCapture{max: bl.max}}
+		defer func() {
+			if bw.status == 0 {
+				bw.status = http.StatusOK
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", bw.status),
+			}
+			if reqBody != nil {
+				attrs = append(attrs, reqBody.attr("request", r.Header.Get("Content-Type"), false))
+			}
+			if !bw.hijacked {
+				attrs = append(attrs, bw.body.attr("response", bw.Header().Get("Content-Type"), bw.streaming))
+			}
+			bl.log.LogAttrs(r.Context(), slog.LevelInfo, "httpsrv: request bodies", attrs...)
+		}()
+
+		next.ServeHTTP(bw, r)
+	})
+}
+
+// bodyCapture keeps the first max bytes written to it and counts the total size.
+type bodyCapture struct {
+	max  int
+	buf  []byte
+	size int64
+}
+
+func (c *bodyCapture) write(b []byte) {
+	c.size += int64(len(b))
+	if n := min(c.max-len(c.buf), len(b)); n > 0 {
+		c.buf = append(c.buf, b[:n]...)
+	}
+}
+
+// attr returns the captured body as log attribute group, contentType is the declared content type.
+func (c *bodyCapture) attr(name, contentType string, streaming bool) slog.Attr {
+	if contentType == "" && len(c.buf) != 0 {
+		contentType = http.DetectContentType(c.buf)
+	}
+	attrs := []any{slog.String("content_type", contentType), slog.Int64("size", c.size)}
+	switch {
+	case c.max == 0:
+		// bodies are not kept, request log only
+	case streaming:
+		attrs = append(attrs, slog.String("skipped", "streaming"))
+	case c.size != 0 && !isTextual(contentType):
+		attrs = append(attrs, slog.String("skipped", "binary"))
+	case c.size != 0:
+		attrs = append(attrs, slog.String("body", string(c.buf)), slog.Bool("truncated", c.size > int64(len(c.buf))))
+	}
+	return slog.Group(name, attrs...)
+}
+
+// isTextual reports whether content of the media type is human readable.
+func isTextual(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"), strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/x-www-form-urlencoded", "application/javascript":
+		return true
+	}
+	return false
+}
+
+type teeBody struct {
+	io.ReadCloser
+	c *bodyCapture
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.c.write(p[:n])
+	return n, err
+}
+
+/*
+bodyLogWriter captures the response body and status for the body logger.
+*/
+type bodyLogWriter struct {
+	http.ResponseWriter
+	body      bodyCapture
+	status    int
+	streaming bool // handler has flushed the response
+	hijacked  bool
+}
+
+func (w *bodyLogWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.streaming {
+		w.body.size += int64(len(b))
+	} else {
+		w.body.write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyLogWriter) Flush() {
+	w.streaming = true
+	w.body.buf = nil
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *bodyLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *bodyLogWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
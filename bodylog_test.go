@@ -0,0 +1,116 @@
+package httpsrv
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_LogBodies(t *testing.T) {
+	t.Parallel()
+
+	type body struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		Body        string `json:"body"`
+		Truncated   bool   `json:"truncated"`
+		Skipped     string `json:"skipped"`
+	}
+	type record struct {
+		Method   string `json:"method"`
+		Path     string `json:"path"`
+		Status   int    `json:"status"`
+		Request  *body  `json:"request"`
+		Response *body  `json:"response"`
+	}
+
+	// serves request with the handler and returns the logged record
+	serve := func(t *testing.T, h http.HandlerFunc, req *http.Request) record {
+		t.Helper()
+		logs := &bytes.Buffer{}
+		cfg := &serverConf{}
+		LogBodies(8, slog.New(slog.NewJSONHandler(logs, nil))).apply(cfg)
+		cfg.wrapHandler(h).ServeHTTP(httptest.NewRecorder(), req)
+
+		var rec record
+		if err := json.Unmarshal(logs.Bytes(), &rec); err != nil {
+			t.Fatalf("decoding log record %q: %v", logs, err)
+		}
+		return rec
+	}
+
+	t.Run("bodies are truncated", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest("POST", "/echo", strings.NewReader(`{"name":"value"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			io.Copy(w, r.Body)
+		}, req)
+
+		if rec.Method != "POST" || rec.Path != "/echo" || rec.Status != http.StatusCreated {
+			t.Errorf("unexpected request info: %+v", rec)
+		}
+		expect := body{ContentType: "application/json", Size: 16, Body: `{"name":`, Truncated: true}
+		if rec.Request == nil || *rec.Request != expect {
+			t.Errorf("unexpected request body: %+v", rec.Request)
+		}
+		// content type of the response is sniffed
+		expect.ContentType = "text/plain; charset=utf-8"
+		if rec.Response == nil || *rec.Response != expect {
+			t.Errorf("unexpected response body: %+v", rec.Response)
+		}
+	})
+
+	t.Run("short bodies are not truncated", func(t *testing.T) {
+		t.Parallel()
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "ok")
+		}, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Request != nil {
+			t.Errorf("expected request without body not to be logged, got %+v", rec.Request)
+		}
+		if expect := (body{ContentType: "text/plain", Size: 2, Body: "ok"}); rec.Response == nil || *rec.Response != expect {
+			t.Errorf("unexpected response body: %+v", rec.Response)
+		}
+	})
+
+	t.Run("binary bodies are skipped", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest("PUT", "/image", strings.NewReader("not really an image"))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, "not really a png")
+		}, req)
+
+		if expect := (body{ContentType: "application/octet-stream", Size: 19, Skipped: "binary"}); rec.Request == nil || *rec.Request != expect {
+			t.Errorf("unexpected request body: %+v", rec.Request)
+		}
+		if expect := (body{ContentType: "image/png", Size: 16, Skipped: "binary"}); rec.Response == nil || *rec.Response != expect {
+			t.Errorf("unexpected response body: %+v", rec.Response)
+		}
+	})
+
+	t.Run("streamed response is skipped", func(t *testing.T) {
+		t.Parallel()
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "one")
+			http.NewResponseController(w).Flush()
+			io.WriteString(w, "two")
+		}, httptest.NewRequest("GET", "/stream", nil))
+
+		if expect := (body{ContentType: "text/plain", Size: 6, Skipped: "streaming"}); rec.Response == nil || *rec.Response != expect {
+			t.Errorf("unexpected response body: %+v", rec.Response)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package httpsrv
+
+import (
+	"context"
+	"time"
+)
+
+/*
+clock is the source of time for the shutdown logic (delay, deregistration and guard waits, the
+graceful shutdown timeout). It allows tests to control the time instead of relying on real sleeps.
+*/
+type clock interface {
+	Now() time.Time
+	// After returns channel which receives the current time once d has elapsed
+	// and func to stop the timer.
+	After(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// withClock sets the clock used by the server, meant to be used by tests.
+func withClock(c clock) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.clock = c }}
+}
+
+func (cfg *serverConf) clk() clock {
+	if cfg.clock == nil {
+		return realClock{}
+	}
+	return cfg.clock
+}
+
+// sleep pauses the current goroutine for the duration d.
+func (cfg *serverConf) sleep(d time.Duration) {
+	c, _ := cfg.clk().After(d)
+	<-c
+}
+
+/*
+withTimeout is like [context.WithTimeout] but the deadline is measured using the server's clock.
+*/
+func (cfg *serverConf) withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if cfg.clock == nil {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	expired, stop := cfg.clock.After(d)
+	go func() {
+		defer stop()
+		select {
+		case <-expired:
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+	return &clockCtx{Context: ctx, deadline: cfg.clock.Now().Add(d)}, func() { cancel(context.Canceled) }
+}
+
+// clockCtx is context whose deadline is measured by clock, see serverConf.withTimeout.
+type clockCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *clockCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+
+// Err returns the cause so that expired context reports [context.DeadlineExceeded].
+func (c *clockCtx) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	return context.Cause(c.Context)
+}
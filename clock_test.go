@@ -0,0 +1,236 @@
+package httpsrv
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+fakeClock is a clock which only moves when Advance is called.
+*/
+type fakeClock struct {
+	m      sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), timers: make(map[*fakeTimer]struct{})}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) (<-chan time.Time, func() bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t.c, func() bool { return false }
+	}
+	c.timers[t] = struct{}{}
+	return t.c, func() bool {
+		c.m.Lock()
+		defer c.m.Unlock()
+		_, ok := c.timers[t]
+		delete(c.timers, t)
+		return ok
+	}
+}
+
+// Advance moves the clock forward by d and fires the timers which expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.at.After(c.now) {
+			t.c <- c.now
+			delete(c.timers, t)
+		}
+	}
+}
+
+// waitTimers blocks until there is at least n pending timers.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; ; i++ {
+		c.m.Lock()
+		cnt := len(c.timers)
+		c.m.Unlock()
+		if cnt >= n {
+			return
+		}
+		if i == 200 {
+			t.Fatalf("expected %d pending timers, got %d", n, cnt)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func Test_fakeClock(t *testing.T) {
+	t.Parallel()
+
+	// starts server using fake clock, the handler blocks until the request is cancelled
+	startServer := func(t *testing.T, params ...ServerParam) (*fakeClock, context.CancelFunc, chan error, *strings.Builder) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		clk := newFakeClock()
+		logs := &strings.Builder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.NotFoundHandler(), ErrorLog: log.New(logs, "", 0)},
+				append(params, Listener(ln), withClock(clk))...,
+			)
+		}()
+		// make sure the server is up before returning
+		if rsp, err := http.Get("http://" + ln.Addr().String()); err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		} else {
+			rsp.Body.Close()
+		}
+		return clk, cancel, srvErr, logs
+	}
+
+	expectBlocked := func(t *testing.T, srvErr chan error) {
+		t.Helper()
+		select {
+		case err := <-srvErr:
+			t.Fatalf("Run returned before the clock was advanced: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	expectReturn := func(t *testing.T, srvErr chan error) error {
+		t.Helper()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("Run didn't return within timeout")
+			return nil
+		case err := <-srvErr:
+			return err
+		}
+	}
+
+	t.Run("shutdown delay", func(t *testing.T) {
+		t.Parallel()
+		clk, cancel, srvErr, _ := startServer(t, ShutdownDelay(time.Hour), ShutdownTimeout(time.Second))
+		cancel()
+		clk.waitTimers(t, 1)
+		expectBlocked(t, srvErr)
+		clk.Advance(time.Hour - time.Second)
+		expectBlocked(t, srvErr)
+		clk.Advance(time.Second)
+		expectError(t, expectReturn(t, srvErr), context.Canceled)
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		t.Parallel()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		clk := newFakeClock()
+		inHandler, release := make(chan struct{}), make(chan struct{})
+		defer close(release)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(inHandler)
+					<-release
+				})},
+				Listener(ln),
+				ShutdownTimeout(time.Minute),
+				withClock(clk),
+			)
+		}()
+		go func() {
+			if rsp, err := http.Get("http://" + ln.Addr().String()); err == nil {
+				rsp.Body.Close()
+			}
+		}()
+		<-inHandler
+
+		cancel()
+		clk.waitTimers(t, 1)
+		expectBlocked(t, srvErr)
+		clk.Advance(time.Minute)
+		expectError(t, expectReturn(t, srvErr), context.DeadlineExceeded)
+	})
+
+	t.Run("deregistration timeout", func(t *testing.T) {
+		t.Parallel()
+		clk, cancel, srvErr, logs := startServer(t,
+			WaitForDeregistration(func() bool { return false }, time.Minute),
+			ShutdownTimeout(time.Second),
+		)
+		cancel()
+		// the timeout and the probe tick
+		clk.waitTimers(t, 2)
+		for i := 0; i < 10; i++ {
+			clk.Advance(100 * time.Millisecond)
+			clk.waitTimers(t, 2)
+		}
+		expectBlocked(t, srvErr)
+		clk.Advance(time.Minute)
+		expectError(t, expectReturn(t, srvErr), context.Canceled)
+		if s := logs.String(); !strings.Contains(s, "instance wasn't deregistered within 1m0s") {
+			t.Errorf("expected deregistration timeout to be logged, got %q", s)
+		}
+	})
+}
+
+func Test_clockCtx(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock()
+	cfg := &serverConf{clock: clk}
+	ctx, cancel := cfg.withTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if d, ok := ctx.Deadline(); !ok || !d.Equal(clk.Now().Add(time.Second)) {
+		t.Errorf("unexpected deadline %s, %t", d, ok)
+	}
+	clk.waitTimers(t, 1)
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("context expired before the clock was advanced: %v", err)
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't cancelled")
+	}
+	expectError(t, ctx.Err(), context.DeadlineExceeded)
+
+	ctx, cancel = cfg.withTimeout(context.Background(), time.Second)
+	cancel()
+	expectError(t, ctx.Err(), context.Canceled)
+}
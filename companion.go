@@ -0,0 +1,95 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+/*
+Companion is implemented by servers which run alongside the main server serving the same handler,
+ie the HTTP/3 server of the github.com/ainvaltin/httpsrv/http3 module. See [WithCompanion].
+*/
+type Companion interface {
+	// Serve is called once the main server's listener has been bound, right before the main
+	// server starts to accept connections. The handler is the main server's handler (wrapped by
+	// the params) and tc is the TLS config the main server uses (including the certificate
+	// loaded from the files given with the TLS param) or nil when the main server doesn't serve
+	// TLS. Serve should block until the companion server exits, the error it returns (other than
+	// http.ErrServerClosed) stops the main server. The ctx is cancelled when Run is stopped.
+	Serve(ctx context.Context, handler http.Handler, tc *tls.Config) error
+	// Drain is called alongside stopping the main server (see Drainable), it should stop the
+	// companion server and return once Serve has returned or ctx is cancelled.
+	Drain(ctx context.Context) error
+	// Wrap wraps the handler of the main server, ie to advertise the companion server to the
+	// clients. It is installed as the outermost wrapper, can return next as is.
+	Wrap(next http.Handler) http.Handler
+}
+
+/*
+WithCompanion runs the companion server alongside the main server, the companion is started when
+the main server is about to start serving and stopped alongside stopping the main server. When
+the companion fails the main server is stopped too. The name is reported as the name of the
+companion's handler wrapper (see [ReadyInfo]).
+
+This is the extension point for the servers which would bring in third-party dependencies, so
+that they can live in separate modules.
+*/
+func WithCompanion(name string, c Companion) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cs := &companionServer{c: c, cfg: cfg, ready: make(chan ReadyInfo, 1)}
+		cfg.onReady = append(cfg.onReady, func(info ReadyInfo) { cs.ready <- info })
+		cfg.watchers = append(cfg.watchers, cs.run)
+		cfg.drainables = append(cfg.drainables, c)
+		cfg.wrappers = append(cfg.wrappers, handlerWrapper{name: name, wrap: c.Wrap})
+	}}
+}
+
+type companionServer struct {
+	c     Companion
+	cfg   *serverConf
+	ready chan ReadyInfo // receives when the main server is about to start serving
+}
+
+func (cs *companionServer) run(ctx context.Context, stop context.CancelCauseFunc) {
+	var info ReadyInfo
+	select {
+	case <-ctx.Done():
+		return
+	case info = <-cs.ready:
+	}
+
+	var tc *tls.Config
+	if info.TLS {
+		var err error
+		if tc, err = cs.cfg.effectiveTLSConfig(); err != nil {
+			stop(err)
+			return
+		}
+	}
+	if err := cs.c.Serve(ctx, cs.cfg.srv.Handler, tc); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		stop(err)
+	}
+}
+
+/*
+effectiveTLSConfig returns copy of the TLS config the server uses. Like [http.Server.ServeTLS]
+the certificate files take precedence over the certificates in the server's TLSConfig.
+*/
+func (cfg *serverConf) effectiveTLSConfig() (*tls.Config, error) {
+	if cfg.certFile == "" && cfg.keyFile == "" {
+		return cfg.srv.TLSConfig.Clone(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := cfg.srv.TLSConfig.Clone()
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc.Certificates = []tls.Certificate{cert}
+	return tc, nil
+}
@@ -0,0 +1,106 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type mockCompanion struct {
+	serving chan *tls.Config // receives the TLS config Serve was called with
+	stop    chan error       // Serve returns the error sent to it
+	drained chan struct{}
+}
+
+func (c *mockCompanion) Serve(ctx context.Context, handler http.Handler, tc *tls.Config) error {
+	c.serving <- tc
+	return <-c.stop
+}
+
+func (c *mockCompanion) Drain(ctx context.Context) error {
+	close(c.drained)
+	c.stop <- nil
+	return nil
+}
+
+func (c *mockCompanion) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Companion", "mock")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func Test_WithCompanion(t *testing.T) {
+	t.Parallel()
+
+	start := func(t *testing.T) (*mockCompanion, net.Listener, context.CancelFunc, chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		c := &mockCompanion{serving: make(chan *tls.Config, 1), stop: make(chan error, 1), drained: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Handler: http.NotFoundHandler()}, Listener(ln), WithCompanion("mock", c))
+		}()
+
+		select {
+		case tc := <-c.serving:
+			if tc != nil {
+				t.Errorf("expected nil TLS config for plaintext server, got %v", tc)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("companion wasn't started")
+		}
+		return c, ln, cancel, srvErr
+	}
+
+	t.Run("stopped with the main server", func(t *testing.T) {
+		t.Parallel()
+		c, ln, cancel, srvErr := start(t)
+
+		rsp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		if v := rsp.Header.Get("X-Companion"); v != "mock" {
+			t.Errorf("expected companion's wrapper to be installed, got header %q", v)
+		}
+
+		cancel()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		select {
+		case <-c.drained:
+		default:
+			t.Error("expected companion to be drained")
+		}
+	})
+
+	t.Run("failure stops the main server", func(t *testing.T) {
+		t.Parallel()
+		c, _, _, srvErr := start(t)
+
+		failure := errors.New("companion failed")
+		c.stop <- failure
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, failure)
+		}
+	})
+}
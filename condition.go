@@ -0,0 +1,68 @@
+package httpsrv
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ResourceConditionError is the reason server was shut down when the condition set by
+[ShutdownWhen] was met. Err is the error returned by the condition func, it may be nil.
+*/
+type ResourceConditionError struct {
+	Err error
+}
+
+func (e *ResourceConditionError) Error() string {
+	if e.Err == nil {
+		return "shutdown condition was met"
+	}
+	return "shutdown condition was met: " + e.Err.Error()
+}
+
+func (e *ResourceConditionError) Unwrap() error { return e.Err }
+
+/*
+ShutdownWhen instructs the server to shut down gracefully when the cond func returns true, the
+cond is polled at given interval (when interval is smaller than or equal to zero it defaults to
+ten seconds). The error returned alongside true describes the condition and [Run] returns it
+wrapped into [ResourceConditionError]. When cond returns false with non-nil error the check
+itself failed, the error is logged using the server's ErrorLog and polling continues.
+
+This generalizes [ShutdownOnMemoryPressure] - disk-full, license expiry or leader-loss checks
+can be used to stop the instance so that orchestrator can start a fresh one. The ctx passed
+to the cond func is cancelled when the server is stopped.
+*/
+func ShutdownWhen(cond func(ctx context.Context) (bool, error), interval time.Duration) ServerParam {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return serverParam{func(cfg *serverConf) {
+		cfg.watchers = append(cfg.watchers, func(ctx context.Context, stop context.CancelCauseFunc) {
+			cfg.watchCondition(ctx, stop, cond, interval)
+		})
+	}}
+}
+
+func (cfg *serverConf) watchCondition(
+	ctx context.Context, stop context.CancelCauseFunc, cond func(context.Context) (bool, error), interval time.Duration,
+) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			met, err := cond(ctx)
+			if met {
+				stop(&ResourceConditionError{Err: err})
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				cfg.logf("httpsrv: checking shutdown condition: %v", err)
+			}
+		}
+	}
+}
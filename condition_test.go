@@ -0,0 +1,50 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownWhen(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diskFull := errors.New("disk full")
+	var polls atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx,
+			&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			ShutdownWhen(func(ctx context.Context) (bool, error) {
+				if polls.Add(1) < 3 {
+					return false, nil
+				}
+				return true, diskFull
+			}, 10*time.Millisecond),
+			ShutdownTimeout(time.Second),
+		)
+	}()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-done:
+		var rcErr *ResourceConditionError
+		if !errors.As(err, &rcErr) {
+			t.Fatalf("expected ResourceConditionError, got %v", err)
+		}
+		expectError(t, err, diskFull)
+		if errors.Is(err, context.Canceled) {
+			t.Errorf("unexpectedly the error contains context.Canceled: %v", err)
+		}
+	}
+	if n := polls.Load(); n != 3 {
+		t.Errorf("expected condition to be polled 3 times, got %d", n)
+	}
+}
@@ -2,10 +2,20 @@ package httpsrv
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,16 +23,157 @@ type serverConf struct {
 	srv *http.Server
 	l   net.Listener
 
-	shutdownTO time.Duration // timeout for graceful shutdown
+	listenConfig *net.ListenConfig // used to create the listener when it is not assigned
+	acceptCtl    *AcceptController // pauses accepting connections, see AcceptControl
+	bandwidth    int               // bytes per second per connection, see ThrottleBandwidth
+	barrier      <-chan struct{}   // bind the listener only after it is closed
+	restartSig   os.Signal         // restart the process on this signal, see RestartOnSignal
+	listeners    []ListenerSpec    // served alongside the main listener, see Listeners
 
-	dieOnPanic bool
+	clock          clock                // source of time for the shutdown, real clock when nil
+	shutdownTO     time.Duration        // timeout for graceful shutdown
+	shutdownTOFunc func() time.Duration // when assigned overrides shutdownTO
+	stopTimeout    time.Duration        // shutdown timeout in effect when the server was stopped
+	adaptive       *adaptiveShutdown    // tracks in-flight requests to compute the shutdown timeout
+
+	dieOnPanic  bool
+	ignorePanic []func(any) bool // panics which do not shut down the server
+	panicLogger func(r any, stack []byte, req *http.Request)
 
 	certFile, keyFile string // serve TLS if assigned
+	optionalTLS       bool   // serve plaintext when cert files do not exist
+	autoTLS           bool   // serve TLS and plaintext on the same listener
+
+	runCtx  context.Context         // context of the Run, cancelled when the server is stopped
+	stopRun context.CancelCauseFunc // cancels the runCtx, ie triggers the shutdown
+
+	// funcs monitoring the server, launched as goroutines for the lifetime of the server.
+	// The stop func can be used to trigger (graceful) shutdown of the server.
+	watchers []func(ctx context.Context, stop context.CancelCauseFunc)
+
+	connState []func(net.Conn, http.ConnState) // hooks to call from srv.ConnState
+
+	readMemStats func(*runtime.MemStats) // samples memory statistics, runtime.ReadMemStats when nil
+
+	routeProbes      []string // paths to probe the handler with when validating config
+	allowEmptyRoutes bool     // skip probing the routes
+
+	drain      func(ctx context.Context) error // called after server has been stopped
+	drainables []Drainable                     // drained alongside stopping the server
+	phases     []shutdownPhase                 // run one by one after the server has been stopped
+	postBind   func(net.Listener) error        // called after bind, before serving
+	portFile   string                          // write the port into this file after bind
+	rmPortFile bool                            // remove the portFile when server stops
+
+	nilOnClean bool            // Run returns nil when the server was stopped cleanly
+	exitCode   func(error) int // maps the error returned by Run to exit code, see RunMain
+
+	notifyPID int       // process to signal when shutdown has completed
+	notifySig os.Signal // signal to send to the notifyPID
+
+	maxConcurrent int           // max number of requests served concurrently
+	queueTimeout  time.Duration // how long request waits for free slot when maxConcurrent is reached
+	maxQueueWait  time.Duration // shed requests which would wait for free slot longer than this
+	onLimit       http.Handler  // handler for requests over the maxConcurrent limit
+	retryAfter    func(OverloadStats) time.Duration
+
+	baseContext []func(context.Context) context.Context // hooks to call from srv.BaseContext
+	onShutdown  []func(timeout time.Duration)           // called when the shutdown begins
+	connInCtx   bool                                    // store connection into request context
+
+	serverTiming bool         // add Server-Timing header to responses
+	idempotency  *idempotency // replay responses to requests with the same idempotency key
+
+	allowedMethods func(*http.Request) []string // methods allowed for the path, see MethodNotAllowed
+	ensureStatus   int                          // status sent when the handler writes no response
+	defaultHeaders http.Header                  // added to every response
+	forceHeaders   bool                         // default headers replace the ones set by the handler
+
+	onReject func(RejectReason, net.Addr)
+
+	hostPolicy func(host string) (allowed bool, redirect string) // checked before the handler
+	maxHeaders int                                               // max number of header fields in request
+
+	ticketKeys     [][32]byte                         // TLS session ticket keys
+	slowHandshake  time.Duration                      // log TLS handshakes taking longer than this
+	alpn           []string                           // allowed application protocols
+	clientHello    []func(*tls.ClientHelloInfo) error // inspect TLS handshakes, see OnClientHello
+	clientKeyUsage []x509.ExtKeyUsage                 // required extended key usages of client certificates
+	ocsp           *ocspStapler                       // staples OCSP response to the certificate
+
+	readyGate *readyGate  // requests are rejected until the server is ready
+	warmup    *warmup     // warm up caches before serving requests
+	isLeader  func() bool // requests are rejected while it returns false
+
+	shuttingDown   atomic.Bool   // set when the shutdown of the server begins
+	shutdownSignal chan struct{} // closed when the shutdown of the server begins, see ShutdownSignal
+	shutdownStatus int           // respond with this status to requests arriving during shutdown
+	shutdownPage   []byte        // html page sent to browsers during shutdown, see MaintenancePageOnShutdown
+	probePaths     []string      // paths not rejected during shutdown
+	closeConns     bool          // close connections of requests arriving during shutdown
+	shutdownDelay  time.Duration // keep serving for this long after shutdown begins
+	deregistered   func() bool   // keep serving until it returns true (or deregisterTO elapses)
+	deregisterTO   time.Duration
+	guard          func() (ok bool, reason string) // postpones the shutdown while it returns false
+	guardMaxWait   time.Duration
+	readinessPath  string      // path of the readiness endpoint, empty means not served
+	livenessPath   string      // path of the liveness endpoint, empty means not served
+	stopping       atomic.Bool // set when the server stops accepting connections (after shutdownDelay)
+	versionPath    string      // path of the version endpoint, empty means not served
+	versionInfo    VersionInfo
+
+	policy         atomic.Pointer[ShutdownPolicy] // when set overrides shutdown delay and timeout
+	signalDeadline func(os.Signal) time.Duration  // time the process has to stop after the signal
+	stopDeadline   atomic.Pointer[time.Time]      // shutdown must complete before it, see SignalDeadline
+
+	maxRequests *maxRequests // shut down after serving given number of requests
+	maintenance *maintenance // route requests to maintenance handler when active
+	uploads     *uploadDrain // decides the fate of uploads when graceful shutdown times out
+
+	reportInFlight bool                     // list requests in flight when graceful shutdown times out
+	inFlightReqs   func() []InFlightRequest // requests being served, assigned by wrapHandler when reportInFlight is set
+	bodyLog        *bodyLogger              // logs request and response bodies, see LogBodies
+	notFound       http.Handler             // replaces bare 404 responses of the handler
+	notFoundProxy  bool                     // notFound is ProxyFallback, headers set by the handler are dropped
+
+	shutdownEvents chan<- ShutdownEvent
+	traceIDFunc    func() string // returns trace ID of the shutdown
+	traceID        string        // trace ID of the shutdown, assigned before shuttingDown is set
+	inFlight       atomic.Int64  // number of requests being served, tracked when shutdownEvents is set
+	eventsMu       sync.Mutex
+	eventsDone     bool // Completed event has been emitted
+
+	onReady    []func(ReadyInfo)
+	onExit     []func(error)                     // called with the error returned by Run
+	onSignal   []func(os.Signal)                 // called when the signal handled by the server is received
+	labels     map[string]string                 // appended to the log lines, see Labels
+	middleware []string                          // names of the handler wrappers installed, innermost first
+	wrappers   []handlerWrapper                  // wrappers installed by optional params, ie WithCompanion
+	use        []func(http.Handler) http.Handler // user middleware, see Use and UseFor
 }
 
+/*
+ConfigError is returned by [Run] (and [ResolveConfig]) when required field of the server
+is not assigned. The Field is the name of the [http.Server] field, ie "Addr" or "Handler",
+so the specific misconfiguration can be detected without matching the error message:
+
+	var cfgErr *httpsrv.ConfigError
+	if errors.As(err, &cfgErr) && cfgErr.Field == "Addr" {
+*/
+type ConfigError struct {
+	Field string // name of the misconfigured field of the http.Server
+	Msg   string
+}
+
+func (e *ConfigError) Error() string { return e.Msg }
+
 var (
-	errUnassignedAddr    = errors.New("address to listen to is not assigned - to fix use either Listener parameter or set the Addr field of the http.Server parameter of Run")
-	errUnassignedHandler = errors.New("misconfigured http server, no handlers attached - to fix use either Endpoints parameter or set the Handler field of the http.Server parameter of Run")
+	errUnassignedAddr    = &ConfigError{Field: "Addr", Msg: "address to listen to is not assigned - to fix use either Listener parameter or set the Addr field of the http.Server parameter of Run"}
+	errUnassignedHandler = &ConfigError{Field: "Handler", Msg: "misconfigured http server, no handlers attached - to fix use either Endpoints parameter or set the Handler field of the http.Server parameter of Run"}
+	errInvalidNotifyPID  = errors.New("invalid pid for NotifyOnShutdownComplete parameter, pid must be greater than zero")
+	errListenConfig      = errors.New("ListenConfig parameter can't be used together with the Listener parameter - the listener is already bound")
+	errRestartListener   = errors.New("RestartOnSignal parameter can't be used together with the Listener parameter - the restarted process must inherit the listener bound by Run")
+	errNoRoutes          = errors.New("handler responds with 404 to all probe requests - to fix register the routes or use AllowEmptyRoutes parameter if this is intended")
 )
 
 func (cfg *serverConf) validate() error {
@@ -34,42 +185,440 @@ func (cfg *serverConf) validate() error {
 		return errUnassignedAddr
 	}
 
+	if cfg.listenConfig != nil && cfg.l != nil {
+		return errListenConfig
+	}
+
+	if cfg.restartSig != nil && cfg.l != nil {
+		return errRestartListener
+	}
+
+	for _, ls := range cfg.listeners {
+		if ls.Listener == nil {
+			return errNilListenerSpec
+		}
+	}
+
+	if cfg.notifySig != nil && cfg.notifyPID <= 0 {
+		return errInvalidNotifyPID
+	}
+
 	return nil
 }
 
-func (cfg *serverConf) listener() (net.Listener, error) {
+/*
+validateRoutes probes the handler h (the server's handler wrapped by wrapHandler, so that the
+endpoints served by the params are taken into account) when RequireRoutes param is in effect.
+*/
+func (cfg *serverConf) validateRoutes(h http.Handler) error {
+	if len(cfg.routeProbes) > 0 && !cfg.allowEmptyRoutes && !cfg.hasRoutes(h) {
+		return errNoRoutes
+	}
+	return nil
+}
+
+/*
+logf logs using the server's ErrorLog or the standard logger when ErrorLog is not assigned.
+Labels (if any) are appended to the message.
+*/
+func (cfg *serverConf) logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...) + cfg.formatLabels()
+	if cfg.srv.ErrorLog != nil {
+		cfg.srv.ErrorLog.Print(msg)
+	} else {
+		log.Print(msg)
+	}
+}
+
+// formatLabels returns labels as space separated key=value pairs (with leading space).
+func (cfg *serverConf) formatLabels() string {
+	keys := make([]string, 0, len(cfg.labels))
+	for k := range cfg.labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := cfg.labels[k]
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
+
+// notifyShutdownComplete sends the notifySig to the notifyPID process.
+func (cfg *serverConf) notifyShutdownComplete() {
+	p, err := os.FindProcess(cfg.notifyPID)
+	if err != nil {
+		cfg.logf("httpsrv: finding process %d to notify about shutdown: %v", cfg.notifyPID, err)
+		return
+	}
+	if err := p.Signal(cfg.notifySig); err != nil {
+		cfg.logf("httpsrv: sending %s to process %d: %v", cfg.notifySig, cfg.notifyPID, err)
+	}
+}
+
+// hasRoutes probes the handler with requests to the routeProbes paths and returns
+// true if any of them gets response with status other than 404.
+func (cfg *serverConf) hasRoutes(h http.Handler) bool {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	ctx := context.WithValue(context.Background(), routeProbeKey{}, true)
+	for _, path := range cfg.routeProbes {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			continue
+		}
+		if cfg.probeRoute(h, req) != http.StatusNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// probeRoute serves the probe request, handler panicking counts as status 500.
+func (cfg *serverConf) probeRoute(h http.Handler, req *http.Request) (status int) {
+	w := &probeWriter{hdr: make(http.Header)}
+	defer func() {
+		if v := recover(); v != nil {
+			cfg.logf("httpsrv: handler panicked while probing route %q: %v", req.URL.Path, v)
+			status = http.StatusInternalServerError
+		}
+	}()
+	h.ServeHTTP(w, req)
+	return w.status
+}
+
+// routeProbeKey marks the requests sent by RequireRoutes validation, see isProbe.
+type routeProbeKey struct{}
+
+// probeWriter is ResponseWriter which only records the response status.
+type probeWriter struct {
+	hdr    http.Header
+	status int
+}
+
+func (w *probeWriter) Header() http.Header { return w.hdr }
+
+func (w *probeWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+func (w *probeWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (cfg *serverConf) listener(ctx context.Context) (net.Listener, error) {
 	if cfg.l != nil {
 		return cfg.l, nil
 	}
+	if cfg.restartSig != nil {
+		l, err := inheritedListener()
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			cfg.l = l
+			return l, nil
+		}
+	}
 
+	var lc net.ListenConfig
+	if cfg.listenConfig != nil {
+		lc = *cfg.listenConfig
+	}
 	var err error
-	if cfg.l, err = net.Listen("tcp", cfg.srv.Addr); err != nil {
+	if cfg.l, err = lc.Listen(ctx, "tcp", cfg.srv.Addr); err != nil {
 		return nil, fmt.Errorf("failed to create listener on %q: %w", cfg.srv.Addr, err)
 	}
 	return cfg.l, nil
 }
 
-func (cfg *serverConf) startFunc() func() error {
-	l, err := cfg.listener()
+/*
+startFunc returns func which binds the listener (unless already bound) and serves, the ctx
+is cancelled when the server is stopped.
+*/
+func (cfg *serverConf) startFunc(ctx context.Context) func() error {
+	if err := cfg.checkOptionalTLS(); err != nil {
+		return func() error { return err }
+	}
+	if cfg.barrier != nil {
+		if err := cfg.waitForBarrier(ctx); err != nil {
+			return func() error { return err }
+		}
+	}
+	l, err := cfg.listener(ctx)
 	if err != nil {
 		return func() error { return err }
 	}
+	if cfg.acceptCtl != nil {
+		l = cfg.acceptCtl.listener(l)
+	}
+	if cfg.bandwidth > 0 {
+		l = &rateLimitedListener{Listener: l, rate: cfg.bandwidth}
+	}
+
+	serve := func() error { return checkListenerErr(cfg.srv.Serve(l)) }
+	if cfg.autoTLS {
+		dl, err := cfg.autoTLSListener(l)
+		if err != nil {
+			l.Close()
+			return func() error { return err }
+		}
+		serve = func() error { return checkListenerErr(cfg.srv.Serve(dl)) }
+	} else if cfg.useTLS() {
+		sl := l
+		if cfg.slowHandshake > 0 {
+			sl = &handshakeListener{Listener: l, cfg: cfg}
+		}
+		serve = func() error { return checkListenerErr(cfg.srv.ServeTLS(sl, cfg.certFile, cfg.keyFile)) }
+	}
 
+	if len(cfg.listeners) != 0 {
+		serve = cfg.serveListeners(serve)
+	}
+	if cfg.warmup != nil {
+		serve = cfg.warmup.serveFunc(ctx, cfg.srv, serve)
+	}
+
+	if cfg.postBind == nil && len(cfg.onReady) == 0 && cfg.warmup == nil && cfg.portFile == "" {
+		return serve
+	}
+	return func() error {
+		if cfg.postBind != nil {
+			if err := cfg.postBind(l); err != nil {
+				l.Close()
+				return fmt.Errorf("post bind hook: %w", err)
+			}
+		}
+		if cfg.warmup != nil && !cfg.warmup.reject {
+			if err := cfg.warmup.run(ctx); err != nil {
+				l.Close()
+				if ctx.Err() != nil {
+					return http.ErrServerClosed
+				}
+				return err
+			}
+		}
+		if cfg.portFile != "" && cfg.writePortFile(l) && cfg.rmPortFile {
+			defer cfg.removePortFile()
+		}
+		if len(cfg.onReady) != 0 {
+			info := cfg.readyInfo(l.Addr())
+			for _, f := range cfg.onReady {
+				f(info)
+			}
+		}
+		return serve()
+	}
+}
+
+// checkListenerErr makes the error returned by Serve more understandable when
+// it is caused by the listener being closed by someone else than the server.
+func checkListenerErr(err error) error {
+	if errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("listener was closed while the server was running: %w", err)
+	}
+	return err
+}
+
+// useTLS reports whether the server should be started using ServeTLS.
+func (cfg *serverConf) useTLS() bool {
 	hasTLSConfig := cfg.srv.TLSConfig != nil && (len(cfg.srv.TLSConfig.Certificates) > 0 || cfg.srv.TLSConfig.GetCertificate != nil)
-	if cfg.keyFile != "" || cfg.certFile != "" || hasTLSConfig {
-		return func() error { return cfg.srv.ServeTLS(l, cfg.certFile, cfg.keyFile) }
+	return cfg.keyFile != "" || cfg.certFile != "" || hasTLSConfig
+}
+
+// shutdownTimeout returns timeout for graceful shutdown, zero or negative
+// value means that server should be closed immediately.
+func (cfg *serverConf) shutdownTimeout() time.Duration {
+	if cfg.shutdownTOFunc != nil {
+		return cfg.shutdownTOFunc()
 	}
-	return func() error { return cfg.srv.Serve(l) }
+	return cfg.shutdownTO
 }
 
-func (cfg *serverConf) stopFunc() func() error {
-	if cfg.shutdownTO <= 0 {
-		return func() error { return cfg.srv.Close() }
+// shutdownMode returns how the server will be stopped and the graceful shutdown timeout
+// (zero when the server is closed immediately).
+func (cfg *serverConf) shutdownMode() (ShutdownMode, time.Duration) {
+	to := cfg.shutdownTimeout()
+	if p := cfg.policy.Load(); p != nil {
+		to = p.Timeout
+	}
+	if to > 0 {
+		return ShutdownGraceful, to
 	}
+	return ShutdownImmediate, 0
+}
 
+func (cfg *serverConf) stopFunc() func() error {
 	return func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTO)
-		defer cancel()
-		return cfg.srv.Shutdown(ctx)
+		if cfg.guard != nil {
+			cfg.waitForGuard()
+		}
+		ctx := context.Background()
+		delay, to := cfg.shutdownDelay, cfg.shutdownTimeout()
+		if p := cfg.policy.Load(); p != nil {
+			delay, to = p.Delay, p.Timeout
+		}
+		delay, to = cfg.capToDeadline(delay, to)
+		cfg.stopTimeout = max(to, 0)
+		if cfg.traceIDFunc != nil {
+			cfg.traceID = cfg.traceIDFunc()
+		}
+		cfg.shuttingDown.Store(true)
+		if cfg.shutdownSignal != nil {
+			close(cfg.shutdownSignal)
+		}
+		cfg.emit(DrainStarted)
+		defer cfg.emit(Completed)
+		if delay > 0 {
+			cfg.sleep(delay)
+		}
+		if cfg.deregistered != nil {
+			cfg.waitForDeregistration()
+			// time spent waiting for the deregistration counts against the deadline too
+			_, to = cfg.capToDeadline(0, to)
+			cfg.stopTimeout = max(to, 0)
+		}
+		cfg.stopping.Store(true)
+		for _, f := range cfg.onShutdown {
+			f(to)
+		}
+
+		if to > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = cfg.withTimeout(ctx, to)
+			defer cancel()
+		}
+		drained := cfg.drainAll(ctx)
+
+		var err error
+		if to <= 0 {
+			if e := cfg.srv.Close(); e != nil {
+				err = &StopError{Mode: ShutdownImmediate, Err: e}
+			}
+			cfg.emit(ForceClosed)
+		} else {
+			cfg.emit(KeepAlivesDisabled)
+			if cfg.inFlight.Load() > 0 {
+				cfg.emit(WaitingForRequests)
+			}
+			if e := cfg.srv.Shutdown(ctx); e != nil {
+				se := &StopError{Mode: ShutdownGraceful, Err: e}
+				err = se
+				if errors.Is(e, context.DeadlineExceeded) {
+					if cfg.inFlightReqs != nil {
+						se.InFlight = cfg.inFlightReqs()
+					}
+					cfg.emit(TimedOut)
+					if cfg.uploads != nil {
+						cfg.uploads.expire()
+					}
+				}
+			}
+		}
+
+		err = errors.Join(err, <-drained)
+		if cfg.drain != nil {
+			if e := cfg.drain(ctx); e != nil {
+				err = errors.Join(err, fmt.Errorf("draining: %w", e))
+			}
+		}
+		if len(cfg.phases) != 0 {
+			err = errors.Join(err, cfg.runPhases())
+		}
+		return err
+	}
+}
+
+// ShutdownMode describes how the server is stopped.
+type ShutdownMode int
+
+const (
+	ShutdownGraceful  ShutdownMode = iota + 1 // server is stopped using http.Server.Shutdown
+	ShutdownImmediate                         // server is stopped using http.Server.Close
+)
+
+func (m ShutdownMode) String() string {
+	switch m {
+	case ShutdownGraceful:
+		return "graceful shutdown"
+	case ShutdownImmediate:
+		return "immediate close"
+	default:
+		return fmt.Sprintf("ShutdownMode(%d)", int(m))
+	}
+}
+
+/*
+StopError is the error returned by [Run] (wrapped) when stopping the server fails. The Mode
+tells whether connections were gracefully drained ([ShutdownTimeout] was set) or force-closed.
+*/
+type StopError struct {
+	Mode     ShutdownMode
+	Err      error             // error returned by the http.Server Shutdown or Close method
+	InFlight []InFlightRequest // requests still running when the shutdown timed out, see ReportInFlight
+}
+
+func (e *StopError) Error() string {
+	if len(e.InFlight) == 0 {
+		return e.Mode.String() + ": " + e.Err.Error()
+	}
+	return e.Mode.String() + ": " + e.Err.Error() + "; in flight: " + inFlightSummary(e.InFlight)
+}
+
+func (e *StopError) Unwrap() error { return e.Err }
+
+/*
+ServerConfigView is read-only snapshot of the effective server configuration, see [ResolveConfig].
+*/
+type ServerConfigView struct {
+	Addr            string        // address the server listens to, taken from the Listener when it is set
+	HasHandler      bool          // whether the server has handler assigned
+	TLS             bool          // whether the server is started with TLS
+	ShutdownTimeout time.Duration // timeout for graceful shutdown, zero means connections are closed immediately (evaluates ShutdownTimeoutFunc)
+	ShutdownDelay   time.Duration // for how long the server keeps serving after shutdown begins
+	ShutdownOnPanic bool          // whether unhandled panic in a handler stops the server
+	ReadinessPath   string        // path of the readiness endpoint, empty when not served
+	LivenessPath    string        // path of the liveness endpoint, empty when not served
+}
+
+/*
+ResolveConfig applies params to the srv the same way [Run] does and validates the result
+without starting anything. It returns the same validation error [Run] would.
+
+Params are applied to a copy of the srv so the srv is not modified. The main use-case is
+testing configuration layer of the service, ie that params are assembled as intended.
+*/
+func ResolveConfig(srv *http.Server, params ...ServerParam) (ServerConfigView, error) {
+	cfg := serverConf{srv: &http.Server{Addr: srv.Addr, Handler: srv.Handler, TLSConfig: srv.TLSConfig}}
+	for _, p := range params {
+		p.apply(&cfg)
+	}
+
+	view := ServerConfigView{
+		Addr:            cfg.srv.Addr,
+		HasHandler:      cfg.srv.Handler != nil,
+		TLS:             cfg.useTLS(),
+		ShutdownDelay:   cfg.shutdownDelay,
+		ShutdownOnPanic: cfg.dieOnPanic,
+		ReadinessPath:   cfg.readinessPath,
+		LivenessPath:    cfg.livenessPath,
+	}
+	if to := cfg.shutdownTimeout(); to > 0 {
+		view.ShutdownTimeout = to
+	}
+	if cfg.l != nil {
+		view.Addr = cfg.l.Addr().String()
+	}
+	if err := cfg.validate(); err != nil {
+		return view, err
 	}
+	return view, cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler))
 }
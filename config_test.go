@@ -1,9 +1,14 @@
 package httpsrv
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -19,7 +24,7 @@ func Test_serverConf_listener(t *testing.T) {
 		defer ln.Close()
 
 		cfg := &serverConf{l: ln, srv: &http.Server{}}
-		l, err := cfg.listener()
+		l, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -31,7 +36,7 @@ func Test_serverConf_listener(t *testing.T) {
 	t.Run("both Addr and listener are unassigned", func(t *testing.T) {
 		// random port is opened when no Addr is provided
 		cfg := &serverConf{srv: &http.Server{}}
-		l, err := cfg.listener()
+		l, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Error("unexpected error", err)
 		}
@@ -51,7 +56,7 @@ func Test_serverConf_listener(t *testing.T) {
 		addr := ln.Addr().String()
 
 		cfg := &serverConf{l: ln, srv: &http.Server{Addr: "127.0.0.1:0"}}
-		l, err := cfg.listener()
+		l, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -68,13 +73,13 @@ func Test_serverConf_listener(t *testing.T) {
 
 	t.Run("multiple calls do return the same listener", func(t *testing.T) {
 		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0"}}
-		l1, err := cfg.listener()
+		l1, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 		addr := l1.Addr().String()
 
-		l2, err := cfg.listener()
+		l2, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -86,10 +91,29 @@ func Test_serverConf_listener(t *testing.T) {
 		}
 	})
 
+	t.Run("listen config is used to create listener", func(t *testing.T) {
+		var controlAddr string
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0"}}
+		ListenConfig(net.ListenConfig{
+			Control: func(network, address string, c syscall.RawConn) error {
+				controlAddr = address
+				return nil
+			},
+		}).apply(cfg)
+		l, err := cfg.listener(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer l.Close()
+		if controlAddr != "127.0.0.1:0" {
+			t.Errorf("expected Control hook to be called with the server's address, got %q", controlAddr)
+		}
+	})
+
 	t.Run("try to open the same Addr twice", func(t *testing.T) {
 		// first attempt should succeed
 		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0"}}
-		l, err := cfg.listener()
+		l, err := cfg.listener(context.Background())
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -100,7 +124,7 @@ func Test_serverConf_listener(t *testing.T) {
 
 		// second attempt with the same addr should fail
 		cfg2 := &serverConf{srv: &http.Server{Addr: l.Addr().String()}}
-		l2, err := cfg2.listener()
+		l2, err := cfg2.listener(context.Background())
 		if err != nil {
 			expErrMsg := fmt.Sprintf("failed to create listener on %q: listen tcp %[1]s: bind: address already in use", l.Addr().String())
 			if err.Error() != expErrMsg {
@@ -129,7 +153,7 @@ func Test_serverConf_startFunc(t *testing.T) {
 		defer ln.Close()
 
 		cfg := &serverConf{srv: &http.Server{Addr: ln.Addr().String()}}
-		sf := cfg.startFunc()
+		sf := cfg.startFunc(context.Background())
 
 		sfErr := make(chan error, 1)
 		go func() {
@@ -146,6 +170,48 @@ func Test_serverConf_startFunc(t *testing.T) {
 			}
 		}
 	})
+	t.Run("post bind hook returns error", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		hookErr := fmt.Errorf("can't drop privileges")
+		var hookLn net.Listener
+		cfg := &serverConf{l: ln, srv: &http.Server{Handler: http.NotFoundHandler()}}
+		PostBind(func(l net.Listener) error { hookLn = l; return hookErr }).apply(cfg)
+
+		err = cfg.startFunc(context.Background())()
+		expectError(t, err, hookErr)
+		if hookLn != ln {
+			t.Error("expected the hook to be called with the server's listener")
+		}
+		// listener should have been closed
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+			t.Error("expected listener to be closed")
+		}
+	})
+
+	t.Run("post bind hook is called before serving", func(t *testing.T) {
+		hookCalled := make(chan struct{})
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
+		PostBind(func(l net.Listener) error { close(hookCalled); return nil }).apply(cfg)
+
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- cfg.startFunc(context.Background())() }()
+
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("hook wasn't called within timeout")
+		case <-hookCalled:
+		}
+		cfg.srv.Close()
+		if err := <-serveErr; err != http.ErrServerClosed {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	})
 }
 
 func Test_serverConf_validate(t *testing.T) {
@@ -175,6 +241,59 @@ func Test_serverConf_validate(t *testing.T) {
 		}
 	})
 
+	t.Run("typed error identifies the field", func(t *testing.T) {
+		tests := []struct {
+			field string
+			srv   *http.Server
+			err   error
+		}{
+			{field: "Addr", srv: &http.Server{Handler: http.NotFoundHandler()}, err: errUnassignedAddr},
+			{field: "Handler", srv: &http.Server{Addr: "127.0.0.1:0"}, err: errUnassignedHandler},
+		}
+		for _, tc := range tests {
+			err := Run(context.Background(), tc.srv)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected error %v, got %v", tc.err, err)
+			}
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Errorf("expected ConfigError, got %T", err)
+			} else if cfgErr.Field != tc.field {
+				t.Errorf("expected field %q, got %q", tc.field, cfgErr.Field)
+			}
+		}
+	})
+
+	t.Run("both ListenConfig and Listener are assigned", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		cfg := &serverConf{srv: &http.Server{Handler: http.NotFoundHandler()}}
+		Listener(ln).apply(cfg)
+		ListenConfig(net.ListenConfig{}).apply(cfg)
+		if err := cfg.validate(); err != errListenConfig {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("both RestartOnSignal and Listener are assigned", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		cfg := &serverConf{srv: &http.Server{Handler: http.NotFoundHandler()}}
+		Listener(ln).apply(cfg)
+		RestartOnSignal(nil).apply(cfg)
+		if err := cfg.validate(); err != errRestartListener {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("Addr is assigned", func(t *testing.T) {
 		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
 		if err := cfg.validate(); err != nil {
@@ -194,4 +313,255 @@ func Test_serverConf_validate(t *testing.T) {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
+	t.Run("strict routes, handler responds 404 to everything", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
+		RequireRoutes().apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != errNoRoutes {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("strict routes, handler responds to one of the probes", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: mux}}
+		RequireRoutes("/", "/health").apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("strict routes, path served by param", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
+		RequireRoutes("/ready").apply(cfg)
+		ReadinessEndpoint("/ready").apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("strict routes, gate doesn't hide empty handler", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
+		RequireRoutes().apply(cfg)
+		ServeWhenLeader(func() bool { return false }).apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != errNoRoutes {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("strict routes, handler panics", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{
+			Addr:     "127.0.0.1:0",
+			Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") }),
+			ErrorLog: log.New(io.Discard, "", 0),
+		}}
+		RequireRoutes().apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("strict routes, nil handler probes DefaultServeMux", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0"}}
+		RequireRoutes("/httpsrv-test-no-such-route").apply(cfg)
+		if err := cfg.validateRoutes(nil); err != errNoRoutes {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("permissive routes", func(t *testing.T) {
+		cfg := &serverConf{srv: &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}}
+		AllowEmptyRoutes().apply(cfg)
+		RequireRoutes().apply(cfg)
+		if err := cfg.validateRoutes(cfg.wrapHandler(cfg.srv.Handler)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func Test_ResolveConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("params are reflected in the view", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		srv := &http.Server{Addr: "127.0.0.1:8080"}
+		view, err := ResolveConfig(srv,
+			Listener(ln),
+			Endpoints(http.NotFoundHandler()),
+			ShutdownTimeout(3*time.Second),
+			ShutdownOnPanic(),
+			TLS("cert", "key"),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := ServerConfigView{
+			Addr:            ln.Addr().String(),
+			HasHandler:      true,
+			TLS:             true,
+			ShutdownTimeout: 3 * time.Second,
+			ShutdownOnPanic: true,
+		}
+		if view != expect {
+			t.Errorf("expected\n%+v\ngot\n%+v", expect, view)
+		}
+		// params must not modify the server passed in
+		if srv.Handler != nil {
+			t.Error("unexpectedly handler was assigned to the srv")
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		view, err := ResolveConfig(&http.Server{Addr: "127.0.0.1:8080", Handler: http.NotFoundHandler()}, ShutdownTimeout(-1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expect := ServerConfigView{Addr: "127.0.0.1:8080", HasHandler: true}
+		if view != expect {
+			t.Errorf("expected\n%+v\ngot\n%+v", expect, view)
+		}
+	})
+
+	t.Run("validation error is returned", func(t *testing.T) {
+		view, err := ResolveConfig(&http.Server{Addr: "127.0.0.1:8080"})
+		if err != errUnassignedHandler {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if view.HasHandler {
+			t.Error("expected HasHandler to be false")
+		}
+	})
+}
+
+func Test_serverConf_stopFunc(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drain func is called after server stopped accepting", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		addr := ln.Addr().String()
+
+		drainErr := fmt.Errorf("drain failed")
+		var drained time.Time
+		cfg := &serverConf{l: ln, srv: &http.Server{Handler: http.NotFoundHandler()}}
+		ShutdownTimeout(time.Second).apply(cfg)
+		DrainFunc(func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("expected drain ctx to have deadline")
+			}
+			if c, err := net.Dial("tcp", addr); err == nil {
+				c.Close()
+				t.Error("expected server not to accept connections anymore")
+			}
+			time.Sleep(100 * time.Millisecond)
+			drained = time.Now()
+			return drainErr
+		}).apply(cfg)
+
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- cfg.startFunc(context.Background())() }()
+		// make sure the server is up before stopping it
+		if c, err := net.Dial("tcp", addr); err != nil {
+			t.Fatalf("dialing server: %v", err)
+		} else {
+			c.Close()
+		}
+
+		err = cfg.stopFunc()()
+		expectError(t, err, drainErr)
+		if drained.IsZero() {
+			t.Error("drain func hasn't been called")
+		}
+		if err := <-serveErr; err != http.ErrServerClosed {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	})
+	t.Run("shutdown timeout func is evaluated when stopping", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+
+		inHandler := make(chan struct{})
+		cfg := &serverConf{l: ln, srv: &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(inHandler)
+				time.Sleep(time.Second)
+			}),
+		}}
+		calls := 0
+		ShutdownTimeoutFunc(func() time.Duration { calls++; return 200 * time.Millisecond }).apply(cfg)
+
+		go cfg.startFunc(context.Background())()
+		go http.Get("http://" + ln.Addr().String())
+		<-inHandler
+
+		if calls != 0 {
+			t.Errorf("timeout func was called %d times before stopping the server", calls)
+		}
+		start := time.Now()
+		err = cfg.stopFunc()()
+		expectError(t, err, context.DeadlineExceeded)
+		if d := time.Since(start); d < 200*time.Millisecond || d > 500*time.Millisecond {
+			t.Errorf("expected shutdown to take ~200ms, took %s", d)
+		}
+		if calls != 1 {
+			t.Errorf("expected timeout func to be called once, it was called %d times", calls)
+		}
+		cfg.srv.Close()
+	})
+	t.Run("error reports the shutdown mode", func(t *testing.T) {
+		for _, mode := range []ShutdownMode{ShutdownGraceful, ShutdownImmediate} {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to create listener: %v", err)
+			}
+
+			closeErr := fmt.Errorf("failed to close listener")
+			cfg := &serverConf{l: &errCloseListener{ln, closeErr}, srv: &http.Server{Handler: http.NotFoundHandler()}}
+			if mode == ShutdownGraceful {
+				ShutdownTimeout(time.Second).apply(cfg)
+			}
+
+			serveErr := make(chan error, 1)
+			go func() { serveErr <- cfg.startFunc(context.Background())() }()
+			if c, err := net.Dial("tcp", ln.Addr().String()); err != nil {
+				t.Fatalf("dialing server: %v", err)
+			} else {
+				c.Close()
+			}
+
+			err = cfg.stopFunc()()
+			expectError(t, err, closeErr)
+			var se *StopError
+			if !errors.As(err, &se) {
+				t.Fatalf("expected StopError, got %#v", err)
+			}
+			if se.Mode != mode {
+				t.Errorf("expected mode %s, got %s", mode, se.Mode)
+			}
+			if s := err.Error(); s != mode.String()+": failed to close listener" {
+				t.Errorf("unexpected error message %q", s)
+			}
+			<-serveErr
+		}
+	})
+}
+
+// errCloseListener returns err from Close.
+type errCloseListener struct {
+	net.Listener
+	err error
+}
+
+func (l *errCloseListener) Close() error {
+	l.Listener.Close()
+	return l.err
 }
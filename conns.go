@@ -0,0 +1,79 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+/*
+ExportConnMetrics installs [http.Server.ConnState] hook which keeps count of connections
+by their state and calls report every time the counts change. The new, active and idle
+are number of connections currently in given state, hijacked and closed are total number
+of connections which have reached given state since the server was started.
+
+The report func is called synchronously from the ConnState hook so it should return quickly.
+ConnState hook assigned to the server by the user will still be called.
+*/
+func ExportConnMetrics(report func(new, active, idle, hijacked, closed int)) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cm := &connMetrics{report: report, conns: make(map[net.Conn]http.ConnState)}
+		cfg.connState = append(cfg.connState, cm.track)
+	}}
+}
+
+type connMetrics struct {
+	m      sync.Mutex
+	conns  map[net.Conn]http.ConnState
+	cnt    [http.StateClosed + 1]int
+	report func(new, active, idle, hijacked, closed int)
+}
+
+func (cm *connMetrics) track(c net.Conn, state http.ConnState) {
+	cm.m.Lock()
+	defer cm.m.Unlock()
+
+	if prev, ok := cm.conns[c]; ok {
+		cm.cnt[prev]--
+	}
+	cm.cnt[state]++
+	if state == http.StateHijacked || state == http.StateClosed {
+		delete(cm.conns, c)
+	} else {
+		cm.conns[c] = state
+	}
+
+	cm.report(cm.cnt[http.StateNew], cm.cnt[http.StateActive], cm.cnt[http.StateIdle], cm.cnt[http.StateHijacked], cm.cnt[http.StateClosed])
+}
+
+/*
+LocalAddr returns the local address of the connection the request is served on, the ctx must
+be the context of the request. This is more reliable than parsing the Host header when handler
+needs to know which address (port) it is serving on, ie to construct absolute URLs. When the ctx
+doesn't carry the address (it doesn't come from request served by [http.Server]) nil is returned.
+*/
+func LocalAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+/*
+installConnStateHooks multiplexes the ConnState callback of the server so that
+all the hooks registered by params are called, including the one assigned by user.
+*/
+func installConnStateHooks(srv *http.Server, hooks []func(net.Conn, http.ConnState)) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	next := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		if next != nil {
+			next(c, state)
+		}
+		for _, f := range hooks {
+			f(c, state)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package httpsrv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ExportConnMetrics(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	var m sync.Mutex
+	var last [5]int
+	report := func(new, active, idle, hijacked, closed int) {
+		m.Lock()
+		defer m.Unlock()
+		last = [5]int{new, active, idle, hijacked, closed}
+	}
+	// wait until the last report matches expectation
+	waitFor := func(t *testing.T, expect [5]int) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			m.Lock()
+			got := last
+			m.Unlock()
+			if got == expect {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected counts %v, got %v", expect, last)
+	}
+
+	var userHook atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{
+				Handler:   http.NotFoundHandler(),
+				ConnState: func(net.Conn, http.ConnState) { userHook.Add(1) },
+			},
+			Listener(ln),
+			ExportConnMetrics(report),
+		)
+	}()
+
+	// first connection makes a request and stays idle (keep-alive)
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing server: %v", err)
+	}
+	defer c1.Close()
+	if _, err := fmt.Fprintf(c1, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", ln.Addr()); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(c1), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	rsp.Body.Close()
+	waitFor(t, [5]int{0, 0, 1, 0, 0})
+
+	// second connection doesn't send anything so it stays in the "new" state
+	c2, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing server: %v", err)
+	}
+	waitFor(t, [5]int{1, 0, 1, 0, 0})
+
+	c1.Close()
+	c2.Close()
+	waitFor(t, [5]int{0, 0, 0, 0, 2})
+
+	if userHook.Load() == 0 {
+		t.Error("ConnState hook assigned by user hasn't been called")
+	}
+
+	cancel()
+	select {
+	case <-time.After(time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+}
+
+func Test_LocalAddr(t *testing.T) {
+	t.Parallel()
+
+	if addr := LocalAddr(context.Background()); addr != nil {
+		t.Errorf("expected nil address for context without connection, got %v", addr)
+	}
+
+	// same handler served on two listeners
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr := LocalAddr(r.Context()); addr != nil {
+			fmt.Fprint(w, addr.String())
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var listeners []net.Listener
+	srvErr := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+		listeners = append(listeners, ln)
+		go func() { srvErr <- Run(ctx, &http.Server{Handler: handler}, Listener(ln)) }()
+	}
+
+	for _, ln := range listeners {
+		rsp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading response body: %v", err)
+		}
+		if string(body) != ln.Addr().String() {
+			t.Errorf("expected local address %s, got %q", ln.Addr(), body)
+		}
+	}
+
+	cancel()
+	for range listeners {
+		expectError(t, <-srvErr, context.Canceled)
+	}
+}
@@ -0,0 +1,108 @@
+package httpsrv
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+/*
+ProductionServer returns http server and params (to be passed to [Run]) configured with
+sensible defaults for production use:
+  - read, write and idle timeouts are set so that slow or idle clients can't hold on
+    to the connections forever;
+  - TLS (when used) requires at least TLS 1.2;
+  - server's error log is written to the logger (at error level), when logger is nil
+    [slog.Default] is used;
+  - panic in a handler is recovered (see [WithRecovery]) and logged, client gets response with
+    status 500 (Internal Server Error);
+  - every request is logged (see [LogBodies], bodies are not logged);
+  - the server is shut down on interrupt and SIGTERM (see [ShutdownOnSignal]);
+  - lame-duck period of 5 seconds (see [ShutdownDelay], combine it with [ReadinessEndpoint]
+    so that load balancer notices the shutdown) followed by graceful shutdown with 20 second
+    timeout, together they fit into the default 30 second termination grace period of
+    Kubernetes.
+
+Returned values can be modified before passing them to [Run], params appended to the
+returned slice override the defaults.
+*/
+func ProductionServer(addr string, h http.Handler, logger *slog.Logger) (*http.Server, []ServerParam) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	return srv, []ServerParam{
+		Use(recoverAndLog(logger)),
+		LogBodies(0, logger),
+		ShutdownOnSignal(),
+		ShutdownDelay(5 * time.Second),
+		ShutdownTimeout(20 * time.Second),
+	}
+}
+
+// recoverAndLog returns middleware which recovers panics (responding with status 500) and logs them.
+func recoverAndLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			WithRecovery(next, func(v any) {
+				logger.ErrorContext(r.Context(), "httpsrv: panic serving request",
+					"method", r.Method, "path", r.URL.Path, "panic", v, "stack", string(debug.Stack()))
+			}).ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+KubernetesDefaults returns shutdown related params fitting into the pod's termination grace
+period:
+  - [ShutdownDelay] of one sixth of the grace period (5s for the default 30s) so that the
+    pod is removed from the service endpoints before it stops accepting connections;
+  - [ReadinessEndpoint] at "/readyz" which starts to fail as soon as shutdown begins;
+  - [LivenessEndpoint] at "/healthz" which keeps succeeding during the shutdown delay;
+  - [ShutdownTimeout] of the rest of the grace period minus 10% safety margin.
+
+When gracePeriod is not positive it is read from the TERMINATION_GRACE_PERIOD environment
+variable (seconds or [time.ParseDuration] format), when that is not set or invalid the
+Kubernetes default of 30 seconds is used.
+
+Params appended to the returned slice override the defaults.
+*/
+func KubernetesDefaults(gracePeriod time.Duration) []ServerParam {
+	if gracePeriod <= 0 {
+		gracePeriod = terminationGracePeriod()
+	}
+	delay := gracePeriod / 6
+	return []ServerParam{
+		ShutdownDelay(delay),
+		ReadinessEndpoint("/readyz"),
+		LivenessEndpoint("/healthz"),
+		ShutdownTimeout(gracePeriod - delay - gracePeriod/10),
+	}
+}
+
+func terminationGracePeriod() time.Duration {
+	v := os.Getenv("TERMINATION_GRACE_PERIOD")
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
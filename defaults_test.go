@@ -0,0 +1,133 @@
+package httpsrv
+
+import (
+	"bytes"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ProductionServer(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	h := http.NotFoundHandler()
+	srv, params := ProductionServer("127.0.0.1:8080", h, slog.New(slog.NewTextHandler(buf, nil)))
+
+	if srv.Addr != "127.0.0.1:8080" {
+		t.Errorf("unexpected Addr %q", srv.Addr)
+	}
+	if srv.Handler == nil {
+		t.Error("expected Handler to be assigned")
+	}
+	if srv.ReadHeaderTimeout <= 0 || srv.ReadTimeout <= 0 || srv.WriteTimeout <= 0 || srv.IdleTimeout <= 0 {
+		t.Errorf("expected all timeouts to be set: %+v", srv)
+	}
+	if srv.TLSConfig == nil || srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected TLS config: %+v", srv.TLSConfig)
+	}
+
+	srv.ErrorLog.Print("test message")
+	if s := buf.String(); !strings.Contains(s, "level=ERROR") || !strings.Contains(s, "test message") {
+		t.Errorf("unexpected log output: %q", s)
+	}
+
+	view, err := ResolveConfig(srv, params...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if view.ShutdownTimeout != 20*time.Second || view.ShutdownDelay != 5*time.Second {
+		t.Errorf("unexpected shutdown timeout %s and delay %s", view.ShutdownTimeout, view.ShutdownDelay)
+	}
+	if view.TLS {
+		t.Error("expected TLS to be off as no certificates are configured")
+	}
+
+	cfg := &serverConf{srv: srv}
+	for _, p := range params {
+		p.apply(cfg)
+	}
+	if cfg.bodyLog == nil || cfg.bodyLog.max != 0 {
+		t.Errorf("expected requests to be logged without bodies, got %+v", cfg.bodyLog)
+	}
+	if len(cfg.onReady) != 1 {
+		t.Errorf("expected quit signal handler to be installed, got %d OnReady hooks", len(cfg.onReady))
+	}
+	// panic is recovered into 500 response and logged, so is the request
+	buf.Reset()
+	rec := httptest.NewRecorder()
+	cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("oops") })).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/boom", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rec.Code)
+	}
+	if s := buf.String(); !strings.Contains(s, "panic serving request") || !strings.Contains(s, "panic=oops") ||
+		!strings.Contains(s, "path=/boom status=500") {
+		t.Errorf("expected panic and request to be logged, got %q", s)
+	}
+
+	// params appended later override the defaults
+	view, err = ResolveConfig(srv, append(params, ShutdownTimeout(time.Second))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if view.ShutdownTimeout != time.Second {
+		t.Errorf("unexpected shutdown timeout %s", view.ShutdownTimeout)
+	}
+}
+
+func Test_KubernetesDefaults(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:8080", Handler: http.NotFoundHandler()}
+
+	checkView := func(t *testing.T, params []ServerParam, delay, timeout time.Duration) {
+		t.Helper()
+		view, err := ResolveConfig(srv, params...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if view.ShutdownDelay != delay {
+			t.Errorf("expected shutdown delay %s, got %s", delay, view.ShutdownDelay)
+		}
+		if view.ShutdownTimeout != timeout {
+			t.Errorf("expected shutdown timeout %s, got %s", timeout, view.ShutdownTimeout)
+		}
+		if view.ReadinessPath != "/readyz" {
+			t.Errorf("unexpected readiness path %q", view.ReadinessPath)
+		}
+		if view.LivenessPath != "/healthz" {
+			t.Errorf("unexpected liveness path %q", view.LivenessPath)
+		}
+	}
+
+	t.Run("explicit grace period", func(t *testing.T) {
+		t.Setenv("TERMINATION_GRACE_PERIOD", "60")
+		checkView(t, KubernetesDefaults(30*time.Second), 5*time.Second, 22*time.Second)
+	})
+
+	t.Run("grace period from env", func(t *testing.T) {
+		t.Setenv("TERMINATION_GRACE_PERIOD", "60")
+		checkView(t, KubernetesDefaults(0), 10*time.Second, 44*time.Second)
+
+		t.Setenv("TERMINATION_GRACE_PERIOD", "1m")
+		checkView(t, KubernetesDefaults(0), 10*time.Second, 44*time.Second)
+	})
+
+	t.Run("invalid env", func(t *testing.T) {
+		t.Setenv("TERMINATION_GRACE_PERIOD", "forever")
+		checkView(t, KubernetesDefaults(0), 5*time.Second, 22*time.Second)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		view, err := ResolveConfig(srv, append(KubernetesDefaults(30*time.Second), ShutdownDelay(0), ShutdownTimeout(time.Second))...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if view.ShutdownDelay != 0 || view.ShutdownTimeout != time.Second {
+			t.Errorf("expected defaults to be overridden, got %+v", view)
+		}
+	})
+}
@@ -5,9 +5,10 @@ Setting up the server and managing it's lifetime is repetitive and it is easy to
 introduce subtle bugs. This library aims to solve these problems while being
 router agnostic and "errgroup pattern" friendly.
 
-This package has no third-party dependencies.
+This package has no third-party dependencies, HTTP/3 support lives in the separate
+github.com/ainvaltin/httpsrv/http3 module (see the WithCompanion parameter).
 
-Latest version requires Go 1.20 or newer, to use it with older Go versions use
-version v0.1.2 of the package.
+Latest version requires Go 1.21 or newer, to use it with Go 1.20 use version v0.3.1
+and with older Go versions use version v0.1.2 of the package.
 */
 package httpsrv
@@ -0,0 +1,59 @@
+package httpsrv
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+/*
+EnsureResponse makes the server to respond with the given status when the handler returns without
+writing anything (neither status nor body), which is usually a bug in the handler - instead of the
+ambiguous empty 200 (OK) response clients get ie 500 (Internal Server Error) or 204 (No Content).
+Such requests are logged using the server's ErrorLog. Zero status disables the check.
+
+Responses of the handlers which panic or hijack the connection are not touched.
+*/
+func EnsureResponse(status int) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.ensureStatus = status }}
+}
+
+func (cfg *serverConf) ensureResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &ensureWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if !ew.written {
+			cfg.logf("httpsrv: handler of %s %s wrote no response, sending status %d", r.Method, r.URL.Path, cfg.ensureStatus)
+			w.WriteHeader(cfg.ensureStatus)
+		}
+	})
+}
+
+// ensureWriter records whether the handler has written the response.
+type ensureWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *ensureWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ensureWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *ensureWriter) Flush() {
+	w.written = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *ensureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.written = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *ensureWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
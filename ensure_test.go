@@ -0,0 +1,46 @@
+package httpsrv
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_EnsureResponse(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+		body    string
+		logged  bool
+	}{
+		{"writes nothing", func(w http.ResponseWriter, r *http.Request) {}, http.StatusInternalServerError, "", true},
+		{"only sets headers", func(w http.ResponseWriter, r *http.Request) { w.Header().Set("X-Foo", "bar") }, http.StatusInternalServerError, "", true},
+		{"writes status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }, http.StatusAccepted, "", false},
+		{"writes body", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, http.StatusOK, "ok", false},
+		{"flushes", func(w http.ResponseWriter, r *http.Request) { http.NewResponseController(w).Flush() }, http.StatusOK, "", false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logs := &strings.Builder{}
+			cfg := &serverConf{srv: &http.Server{ErrorLog: log.New(logs, "", 0)}}
+			EnsureResponse(http.StatusInternalServerError).apply(cfg)
+			h := cfg.wrapHandler(tc.handler)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/foo", nil))
+			if rec.Code != tc.code || rec.Body.String() != tc.body {
+				t.Errorf("expected %d %q, got %d %q", tc.code, tc.body, rec.Code, rec.Body.String())
+			}
+			if logged := strings.Contains(logs.String(), "handler of GET /foo wrote no response, sending status 500"); logged != tc.logged {
+				t.Errorf("expected logged to be %t, got log %q", tc.logged, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package httpsrv
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ShutdownEventKind identifies the step of the shutdown, see [ShutdownEvents].
+type ShutdownEventKind int
+
+const (
+	DrainStarted       ShutdownEventKind = iota + 1 // shutdown has begun (before the ShutdownDelay)
+	KeepAlivesDisabled                              // graceful shutdown started, listener closed and keep-alives disabled
+	WaitingForRequests                              // number of in-flight requests changed while waiting for them to complete
+	TimedOut                                        // graceful shutdown didn't complete within the ShutdownTimeout
+	ForceClosed                                     // connections were closed immediately, in-flight requests are cut off
+	Completed                                       // shutdown is complete, always the last event
+)
+
+func (k ShutdownEventKind) String() string {
+	switch k {
+	case DrainStarted:
+		return "drain started"
+	case KeepAlivesDisabled:
+		return "keep-alives disabled"
+	case WaitingForRequests:
+		return "waiting for requests"
+	case TimedOut:
+		return "timed out"
+	case ForceClosed:
+		return "force closed"
+	case Completed:
+		return "completed"
+	default:
+		return "ShutdownEventKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// ShutdownEvent describes progress of the shutdown, see [ShutdownEvents].
+type ShutdownEvent struct {
+	Kind     ShutdownEventKind
+	InFlight int64  // number of requests still being served when the event was emitted
+	TraceID  string // trace ID of the shutdown, see ShutdownTraceContext
+}
+
+/*
+ShutdownEvents makes the server to emit events describing progress of the shutdown into the
+channel, ie so that TUI or log aggregator can visualize the drain. Sends are non-blocking so
+events are dropped when the channel is not ready to receive, use buffered channel to avoid
+losing events. The channel is not closed by the server, [Completed] is always the last event.
+When the server is closed because of unhandled panic (see [ShutdownOnPanic]) the events are
+DrainStarted, ForceClosed and Completed.
+*/
+func ShutdownEvents(events chan<- ShutdownEvent) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.shutdownEvents = events }}
+}
+
+/*
+ShutdownTraceContext sets hook which is called when the shutdown begins, the trace ID it returns
+is attached to all the [ShutdownEvent]s of the shutdown. This lets operators correlate the
+(deploy triggered) shutdown with the surrounding trace, ie the hook would return the ID of the
+span started by the code cancelling the Run's context.
+*/
+func ShutdownTraceContext(traceID func() string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.traceIDFunc = traceID }}
+}
+
+func (cfg *serverConf) emit(kind ShutdownEventKind) {
+	if cfg.shutdownEvents == nil {
+		return
+	}
+	// handlers may still be running after Shutdown has timed out, make sure
+	// they do not emit anything after the Completed event.
+	cfg.eventsMu.Lock()
+	defer cfg.eventsMu.Unlock()
+	if cfg.eventsDone {
+		return
+	}
+	cfg.eventsDone = kind == Completed
+	select {
+	case cfg.shutdownEvents <- ShutdownEvent{Kind: kind, InFlight: cfg.inFlight.Load(), TraceID: cfg.traceID}:
+	default:
+	}
+}
+
+/*
+emitPanicClose emits the events of the shutdown caused by unhandled panic - the server
+has been closed by the panic handler without calling the stop func. Nothing is emitted
+when the panic happened while the server was being stopped (the stop func emitted the
+events).
+*/
+func (cfg *serverConf) emitPanicClose() {
+	if cfg.shutdownEvents == nil {
+		return
+	}
+	cfg.eventsMu.Lock()
+	done := cfg.eventsDone
+	cfg.eventsMu.Unlock()
+	if done {
+		return
+	}
+	if cfg.traceIDFunc != nil {
+		cfg.traceID = cfg.traceIDFunc()
+	}
+	cfg.emit(DrainStarted)
+	cfg.emit(ForceClosed)
+	cfg.emit(Completed)
+}
+
+// trackInFlight wraps handler so that number of in-flight requests is tracked.
+func (cfg *serverConf) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.inFlight.Add(1)
+		defer func() {
+			cfg.inFlight.Add(-1)
+			if cfg.shuttingDown.Load() {
+				cfg.emit(WaitingForRequests)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,191 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownEvents(t *testing.T) {
+	t.Parallel()
+
+	// starts server with handler blocking until release is closed, sends
+	// n requests and waits until all of them have entered the handler
+	startServer := func(t *testing.T, n int, params ...ServerParam) (context.CancelFunc, chan struct{}, chan error) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+
+		entered, release := make(chan struct{}, n), make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- struct{}{}
+			<-release
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Handler: handler}, append(params, Listener(ln))...)
+		}()
+
+		for i := 0; i < n; i++ {
+			go func() {
+				if rsp, err := http.Get("http://" + ln.Addr().String()); err == nil {
+					rsp.Body.Close()
+				}
+			}()
+			<-entered
+		}
+		return cancel, release, srvErr
+	}
+
+	// collects events until Completed is received
+	collect := func(t *testing.T, events chan ShutdownEvent) (r []ShutdownEvent) {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if r = append(r, e); e.Kind == Completed {
+					return r
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("didn't receive Completed event, got %v", r)
+			}
+		}
+	}
+
+	expectEvents := func(t *testing.T, got, exp []ShutdownEvent) {
+		t.Helper()
+		if len(got) != len(exp) {
+			t.Fatalf("expected events\n%v\ngot\n%v", exp, got)
+		}
+		for i := range exp {
+			if got[i] != exp[i] {
+				t.Fatalf("expected events\n%v\ngot\n%v", exp, got)
+			}
+		}
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		events := make(chan ShutdownEvent, 10)
+		cancel, release, srvErr := startServer(t, 2, ShutdownEvents(events), ShutdownTimeout(time.Second))
+
+		cancel()
+		// wait until server is waiting for the in-flight requests before releasing them
+		var started []ShutdownEvent
+		for e := range events {
+			if started = append(started, e); e.Kind == WaitingForRequests {
+				break
+			}
+		}
+		expectEvents(t, started, []ShutdownEvent{
+			{Kind: DrainStarted, InFlight: 2},
+			{Kind: KeepAlivesDisabled, InFlight: 2},
+			{Kind: WaitingForRequests, InFlight: 2},
+		})
+		close(release)
+
+		expectEvents(t, collect(t, events), []ShutdownEvent{
+			{Kind: WaitingForRequests, InFlight: 1},
+			{Kind: WaitingForRequests, InFlight: 0},
+			{Kind: Completed, InFlight: 0},
+		})
+		expectError(t, <-srvErr, context.Canceled)
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		events := make(chan ShutdownEvent, 10)
+		cancel, release, srvErr := startServer(t, 1, ShutdownEvents(events), ShutdownTimeout(100*time.Millisecond))
+		defer close(release)
+
+		cancel()
+		expectEvents(t, collect(t, events), []ShutdownEvent{
+			{Kind: DrainStarted, InFlight: 1},
+			{Kind: KeepAlivesDisabled, InFlight: 1},
+			{Kind: WaitingForRequests, InFlight: 1},
+			{Kind: TimedOut, InFlight: 1},
+			{Kind: Completed, InFlight: 1},
+		})
+		err := <-srvErr
+		expectError(t, err, context.Canceled)
+		expectError(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("immediate", func(t *testing.T) {
+		events := make(chan ShutdownEvent, 10)
+		cancel, release, srvErr := startServer(t, 1, ShutdownEvents(events))
+		defer close(release)
+
+		cancel()
+		expectEvents(t, collect(t, events), []ShutdownEvent{
+			{Kind: DrainStarted, InFlight: 1},
+			{Kind: ForceClosed, InFlight: 1},
+			{Kind: Completed, InFlight: 1},
+		})
+		expectError(t, <-srvErr, context.Canceled)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		events := make(chan ShutdownEvent, 10)
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(context.Background(),
+				&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("oops") }), ErrorLog: log.New(io.Discard, "", 0)},
+				Listener(ln), ShutdownOnPanic(), ShutdownEvents(events), ShutdownTimeout(time.Second))
+		}()
+		if rsp, err := http.Get("http://" + ln.Addr().String()); err == nil {
+			rsp.Body.Close()
+		}
+
+		expectEvents(t, collect(t, events), []ShutdownEvent{
+			{Kind: DrainStarted},
+			{Kind: ForceClosed},
+			{Kind: Completed},
+		})
+		if err := <-srvErr; err == nil || !strings.Contains(err.Error(), "unhandled panic: oops") {
+			t.Errorf("expected panic error, got %v", err)
+		}
+	})
+
+	t.Run("trace ID", func(t *testing.T) {
+		events := make(chan ShutdownEvent, 10)
+		cancel, release, srvErr := startServer(t, 1, ShutdownEvents(events), ShutdownTraceContext(func() string { return "4bf92f3577b34da6" }))
+		defer close(release)
+
+		cancel()
+		expectEvents(t, collect(t, events), []ShutdownEvent{
+			{Kind: DrainStarted, InFlight: 1, TraceID: "4bf92f3577b34da6"},
+			{Kind: ForceClosed, InFlight: 1, TraceID: "4bf92f3577b34da6"},
+			{Kind: Completed, InFlight: 1, TraceID: "4bf92f3577b34da6"},
+		})
+		expectError(t, <-srvErr, context.Canceled)
+	})
+
+	t.Run("sends are non-blocking", func(t *testing.T) {
+		events := make(chan ShutdownEvent) // nobody is reading
+		cancel, release, srvErr := startServer(t, 0, ShutdownEvents(events), ShutdownTimeout(time.Second))
+		close(release)
+
+		cancel()
+		select {
+		case err := <-srvErr:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		}
+	})
+}
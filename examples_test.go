@@ -81,3 +81,33 @@ func ExampleListener() {
 		}
 	}
 }
+
+// ServeOnce simplifies tests where the server is expected to serve single request.
+func ExampleServeOnce() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("failed to create listener:", err)
+		return
+	}
+	defer ln.Close()
+
+	go func() {
+		rsp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			fmt.Println("GET request failed:", err)
+			return
+		}
+		rsp.Body.Close()
+	}()
+
+	err = httpsrv.ServeOnce(
+		&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Println("serving", r.Method, r.URL.Path)
+		})},
+		httpsrv.Listener(ln),
+	)
+	fmt.Println("server exited:", err)
+	// Output:
+	// serving GET /
+	// server exited: <nil>
+}
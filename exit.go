@@ -0,0 +1,156 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+ExitCode sets func which maps the error returned by [Run] to the exit code of the process
+used by [RunMain]. By default the exit code is 0 when the server was stopped cleanly (see
+[ReturnNilOnCleanShutdown]) and 1 otherwise.
+*/
+func ExitCode(mapper func(error) int) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.exitCode = mapper }}
+}
+
+func defaultExitCode(err error) int {
+	if err == nil || isCleanShutdown(err) {
+		return 0
+	}
+	return 1
+}
+
+/*
+RunMain runs the server (see [Run]) until interrupt or SIGTERM signal is received, logs the
+final message using the server's ErrorLog and exits the process with the code returned by the
+[ExitCode] mapper. It is meant to replace the boilerplate at the bottom of the main func:
+
+	func main() {
+		srv, params := httpsrv.ProductionServer(":8080", handler, nil)
+		httpsrv.RunMain(srv, append(params, httpsrv.KubernetesDefaults(0)...)...)
+	}
+
+RunMain never returns.
+*/
+func RunMain(srv *http.Server, params ...ServerParam) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var cfg *serverConf
+	err := Run(ctx, srv, append(params, serverParam{func(c *serverConf) { cfg = c }})...)
+	stop()
+
+	exitCode, logf := defaultExitCode, log.Printf
+	if cfg != nil {
+		if cfg.exitCode != nil {
+			exitCode = cfg.exitCode
+		}
+		logf = cfg.logf
+	}
+	code := exitCode(err)
+	if err == nil {
+		logf("httpsrv: server exited, exit code %d", code)
+	} else {
+		logf("httpsrv: server exited: %v, exit code %d", err, code)
+	}
+	os.Exit(code)
+}
+
+// ExitReason is the high-level reason the server exited, see [ClassifyExit].
+type ExitReason int
+
+const (
+	ExitClean       ExitReason = iota + 1 // Run returned nil (see ReturnNilOnCleanShutdown) or the server was closed directly
+	ExitStartFailed                       // server didn't start, ie configuration error
+	ExitPanic                             // unhandled panic in a handler, see ShutdownOnPanic
+	ExitServeFailed                       // serving failed, ie listener was closed
+	ExitSignal                            // stopped because of (quit) signal, see RunWithSignalPolicy
+	ExitCancelled                         // Run's context was cancelled (with context.Canceled as the cause)
+	ExitCause                             // other error, ie of errgroup sibling or Run's context cancelled by MaxRequests
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitClean:
+		return "clean"
+	case ExitStartFailed:
+		return "start failed"
+	case ExitPanic:
+		return "panic"
+	case ExitServeFailed:
+		return "serve failed"
+	case ExitSignal:
+		return "signal"
+	case ExitCancelled:
+		return "cancelled"
+	case ExitCause:
+		return "cancelled with cause"
+	default:
+		return fmt.Sprintf("ExitReason(%d)", int(r))
+	}
+}
+
+/*
+ClassifyExit inspects the error returned by [Run] (or any func wrapping it, ie errgroup's Wait)
+and returns the high-level reason the server exited. The whole error tree is inspected so the
+errors of the errgroup siblings are classified too. When several reasons apply the first one in
+the order panic, serve failure, signal, cancellation, other error is returned. Errors returned
+while stopping the server (ie graceful shutdown timed out) do not change the reason.
+
+The quitSignals are sentinel errors which mean that the process received quit signal, ie
+github.com/ainvaltin/wake.ErrReceivedQuitSignal returned by the errgroup member listening for
+the signals. [SignalError] is always classified as [ExitSignal]. Context cancelled by
+[os/signal.NotifyContext] is reported as [ExitCancelled] as it can't be told apart from the
+cancellation of the parent context.
+
+	err := g.Wait()
+	switch httpsrv.ClassifyExit(err, wake.ErrReceivedQuitSignal) {
+	...
+	}
+*/
+func ClassifyExit(err error, quitSignals ...error) ExitReason {
+	if err == nil {
+		return ExitClean
+	}
+	var re *RunError
+	if !errors.As(err, &re) {
+		re = &RunError{}
+	}
+	var sigErr *SignalError
+	switch {
+	case re.Panic != nil:
+		return ExitPanic
+	case re.Serve != nil:
+		return ExitServeFailed
+	case errors.As(err, &sigErr) || isAnyOf(err, quitSignals):
+		return ExitSignal
+	case errors.Is(err, context.Canceled):
+		return ExitCancelled
+	case isConfigError(err):
+		return ExitStartFailed
+	case re.Context != nil || re.Stop == nil:
+		return ExitCause
+	}
+	// only stopping the server failed, ie the server was closed directly
+	return ExitClean
+}
+
+func isAnyOf(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConfigError reports whether the err was returned by the validation of the Run's params.
+func isConfigError(err error) bool {
+	var cfgErr *ConfigError
+	return errors.As(err, &cfgErr) || isAnyOf(err, []error{errInvalidNotifyPID, errListenConfig, errNoRoutes})
+}
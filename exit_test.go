@@ -0,0 +1,115 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func Test_RunMain(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the "main" process
+	switch os.Getenv("HTTPSRV_TEST_RUNMAIN") {
+	case "":
+	case "clean":
+		RunMain(&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			// interrupt ourselves once the server is up
+			OnReady(func(ReadyInfo) {
+				p, _ := os.FindProcess(os.Getpid())
+				p.Signal(os.Interrupt)
+			}),
+		)
+	case "failure":
+		RunMain(&http.Server{Addr: "127.0.0.1:-1", Handler: http.NotFoundHandler()})
+	case "mapped":
+		RunMain(&http.Server{Addr: "127.0.0.1:-1", Handler: http.NotFoundHandler()},
+			ExitCode(func(err error) int { return 3 }),
+		)
+	}
+
+	run := func(t *testing.T, mode string) (int, string) {
+		cmd := exec.Command(os.Args[0], "-test.run=^Test_RunMain$")
+		cmd.Env = append(os.Environ(), "HTTPSRV_TEST_RUNMAIN="+mode)
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			t.Fatalf("running subprocess: %v", err)
+		}
+		return cmd.ProcessState.ExitCode(), string(out)
+	}
+
+	testCases := []struct {
+		mode string
+		code int
+		log  string
+	}{
+		{mode: "clean", code: 0, log: "exit code 0"},
+		{mode: "failure", code: 1, log: "failed to create listener"},
+		{mode: "mapped", code: 3, log: "exit code 3"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.mode, func(t *testing.T) {
+			t.Parallel()
+			code, out := run(t, tc.mode)
+			if code != tc.code {
+				t.Errorf("expected exit code %d, got %d\n%s", tc.code, code, out)
+			}
+			if !strings.Contains(out, tc.log) {
+				t.Errorf("expected output to contain %q, got:\n%s", tc.log, out)
+			}
+		})
+	}
+}
+
+func Test_ClassifyExit(t *testing.T) {
+	t.Parallel()
+
+	errSibling := errors.New("sibling failed")
+	// mimics wake.ErrReceivedQuitSignal
+	errQuit := errors.New("received quit signal")
+	stopErr := &StopError{Mode: ShutdownGraceful, Err: context.DeadlineExceeded}
+	testCases := []struct {
+		err    error
+		reason ExitReason
+	}{
+		{err: nil, reason: ExitClean},
+		{err: &RunError{Stop: stopErr}, reason: ExitClean},
+		{err: errUnassignedAddr, reason: ExitStartFailed},
+		{err: errNoRoutes, reason: ExitStartFailed},
+		{err: &RunError{Panic: errors.New("unhandled panic: oops"), Context: context.Canceled}, reason: ExitPanic},
+		{err: errors.Join(errSibling, &RunError{Panic: errors.New("unhandled panic: oops")}), reason: ExitPanic},
+		{err: &RunError{Serve: errors.New("listener closed")}, reason: ExitServeFailed},
+		{err: &RunError{Context: &SignalError{Signal: syscall.SIGTERM}, Stop: stopErr}, reason: ExitSignal},
+		{err: &SignalError{Signal: syscall.SIGTERM}, reason: ExitSignal},
+		// errgroup member listening for signals failed first, ie wake.ListenForQuitSignal
+		{err: fmt.Errorf("interrupt: %w", errQuit), reason: ExitSignal},
+		{err: &RunError{Context: context.Canceled}, reason: ExitCancelled},
+		{err: &RunError{Context: context.Canceled, Stop: stopErr}, reason: ExitCancelled},
+		// errgroup member returning ctx.Err()
+		{err: context.Canceled, reason: ExitCancelled},
+		{err: &RunError{Context: errSibling}, reason: ExitCause},
+		{err: &RunError{Context: ErrMaxRequestsReached}, reason: ExitCause},
+		// wrapped, ie returned by errgroup
+		{err: fmt.Errorf("running group: %w", &RunError{Context: errSibling}), reason: ExitCause},
+		// errgroup sibling failed first
+		{err: errSibling, reason: ExitCause},
+	}
+	for _, tc := range testCases {
+		if r := ClassifyExit(tc.err, errQuit); r != tc.reason {
+			t.Errorf("expected %q for error %v, got %q", tc.reason, tc.err, r)
+		}
+	}
+
+	// without the sentinel quit signal error is just another error
+	if r := ClassifyExit(fmt.Errorf("interrupt: %w", errQuit)); r != ExitCause {
+		t.Errorf("expected %q without quit signal sentinels, got %q", ExitCause, r)
+	}
+}
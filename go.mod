@@ -1,3 +1,3 @@
 module github.com/ainvaltin/httpsrv
 
-go 1.20
+go 1.21
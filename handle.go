@@ -0,0 +1,89 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ServerHandle returns param which attaches the returned handle to the server started by [Run], the
+handle allows to observe the running server from other goroutines, ie to wait in the integration
+test until the server accepts connections instead of polling its health endpoint:
+
+	param, srv := httpsrv.ServerHandle()
+	go httpsrv.Run(ctx, &http.Server{Addr: addr, Handler: mux}, param)
+	if err := srv.WaitReady(ctx); err != nil {
+		return err
+	}
+
+The handle must not be used with more than one Run.
+*/
+func ServerHandle() (ServerParam, *Server) {
+	s := &Server{ready: make(chan struct{}), done: make(chan struct{})}
+	return serverParam{func(cfg *serverConf) {
+		s.cfg.Store(cfg)
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) { s.readyOnce.Do(func() { close(s.ready) }) })
+		cfg.onExit = append(cfg.onExit, s.exited)
+	}}, s
+}
+
+/*
+Server is handle of the server started by [Run], see [ServerHandle].
+*/
+type Server struct {
+	cfg       atomic.Pointer[serverConf]
+	readyOnce sync.Once
+	ready     chan struct{} // closed when the server starts to accept connections
+	done      chan struct{} // closed when Run returns
+	err       error         // error returned by Run, valid after done is closed
+}
+
+// ErrNotStarted is returned by [Server.WaitReady] when [Run] returned before the server became ready.
+var ErrNotStarted = errors.New("server exited before it became ready")
+
+/*
+WaitReady blocks until the server has bound the listener and is about to accept connections (the
+same moment [OnReady] hooks are called) or the ctx is done. When Run returns before the server
+became ready error wrapping [ErrNotStarted] and the error returned by Run is returned.
+*/
+func (s *Server) WaitReady(ctx context.Context) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-s.done:
+		select {
+		case <-s.ready:
+			return nil
+		default:
+			if s.err == nil {
+				return ErrNotStarted
+			}
+			return fmt.Errorf("%w: %w", ErrNotStarted, s.err)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+ShutdownMode reports how the server will be stopped and the graceful shutdown timeout (zero for
+[ShutdownImmediate]), evaluated from the effective configuration of the server (ie the func given
+to [ShutdownTimeoutFunc] is called). Zero mode is returned when the handle hasn't been passed
+to [Run] yet.
+*/
+func (s *Server) ShutdownMode() (ShutdownMode, time.Duration) {
+	cfg := s.cfg.Load()
+	if cfg == nil {
+		return 0, 0
+	}
+	return cfg.shutdownMode()
+}
+
+func (s *Server) exited(err error) {
+	s.err = err
+	close(s.done)
+}
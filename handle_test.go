@@ -0,0 +1,97 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Server_WaitReady(t *testing.T) {
+	t.Parallel()
+
+	t.Run("slow binding", func(t *testing.T) {
+		t.Parallel()
+
+		param, srv := ServerHandle()
+		barrier := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}, param, WaitForBarrier(barrier))
+		}()
+
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer waitCancel()
+		if err := srv.WaitReady(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline to be exceeded while the listener is not bound, got %v", err)
+		}
+
+		close(barrier)
+		waitCtx, waitCancel = context.WithTimeout(context.Background(), time.Second)
+		defer waitCancel()
+		if err := srv.WaitReady(waitCtx); err != nil {
+			t.Errorf("expected server to become ready, got %v", err)
+		}
+
+		cancel()
+		expectError(t, <-srvErr, context.Canceled)
+		// the server stays "has been ready"
+		if err := srv.WaitReady(waitCtx); err != nil {
+			t.Errorf("expected nil after the server has been ready, got %v", err)
+		}
+	})
+
+	t.Run("server fails to start", func(t *testing.T) {
+		t.Parallel()
+
+		param, srv := ServerHandle()
+		err := Run(context.Background(), &http.Server{Handler: http.NotFoundHandler()}, param)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if werr := srv.WaitReady(ctx); !errors.Is(werr, ErrNotStarted) || !errors.Is(werr, err) {
+			t.Errorf("expected ErrNotStarted wrapping %v, got %v", err, werr)
+		}
+	})
+}
+
+func Test_Server_ShutdownMode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		params  []ServerParam
+		mode    ShutdownMode
+		timeout time.Duration
+	}{
+		{name: "close", params: nil, mode: ShutdownImmediate, timeout: 0},
+		{name: "shutdown", params: []ServerParam{ShutdownTimeout(3 * time.Second)}, mode: ShutdownGraceful, timeout: 3 * time.Second},
+		{name: "timeout func", params: []ServerParam{ShutdownTimeoutFunc(func() time.Duration { return time.Second })}, mode: ShutdownGraceful, timeout: time.Second},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			param, srv := ServerHandle()
+			if mode, to := srv.ShutdownMode(); mode != 0 || to != 0 {
+				t.Errorf("expected zero values before Run, got %s %s", mode, to)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			srvErr := make(chan error, 1)
+			go func() {
+				srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}, append(tc.params, param)...)
+			}()
+			if err := srv.WaitReady(ctx); err != nil {
+				t.Fatalf("waiting for server to become ready: %v", err)
+			}
+			if mode, to := srv.ShutdownMode(); mode != tc.mode || to != tc.timeout {
+				t.Errorf("expected %s with timeout %s, got %s %s", tc.mode, tc.timeout, mode, to)
+			}
+			cancel()
+			expectError(t, <-srvErr, context.Canceled)
+		})
+	}
+}
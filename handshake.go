@@ -0,0 +1,62 @@
+package httpsrv
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+/*
+LogSlowHandshakes makes the server to log (using the server's ErrorLog) TLS handshakes which took
+longer than threshold, with the remote address of the client. This helps to diagnose clients with
+bad TLS setup or networks.
+
+The standard library doesn't expose the handshake timing so it is approximated by instrumenting
+the connections - the handshake is considered complete when the first read following the server's
+first write (ie the client's response to the server's handshake messages) completes. The
+measurement starts when the connection is accepted. When the server doesn't serve TLS the
+param has no effect.
+*/
+func LogSlowHandshakes(threshold time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.slowHandshake = threshold }}
+}
+
+type handshakeListener struct {
+	net.Listener
+	cfg *serverConf
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	return &handshakeConn{Conn: c, cfg: l.cfg, accepted: time.Now()}, nil
+}
+
+/*
+handshakeConn measures the time from accepting the connection until the first
+successful read after the first write.
+*/
+type handshakeConn struct {
+	net.Conn
+	cfg      *serverConf
+	accepted time.Time
+	written  atomic.Bool // server has written to the connection
+	done     atomic.Bool // handshake has completed
+}
+
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.written.Load() && c.done.CompareAndSwap(false, true) {
+		if d := time.Since(c.accepted); d > c.cfg.slowHandshake {
+			c.cfg.logf("httpsrv: slow TLS handshake from %s took %s", c.RemoteAddr(), d)
+		}
+	}
+	return n, err
+}
+
+func (c *handshakeConn) Write(b []byte) (int, error) {
+	c.written.Store(true)
+	return c.Conn.Write(b)
+}
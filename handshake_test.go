@@ -0,0 +1,96 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// throttledConn delays every write to simulate slow client.
+type throttledConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(b)
+}
+
+// syncBuffer is strings.Builder safe for concurrent use.
+type syncBuffer struct {
+	m sync.Mutex
+	b strings.Builder
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.m.Lock()
+	defer sb.m.Unlock()
+	return sb.b.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.m.Lock()
+	defer sb.m.Unlock()
+	return sb.b.String()
+}
+
+func Test_LogSlowHandshakes(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	logs := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{
+			Handler:   http.NotFoundHandler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+			ErrorLog:  log.New(logs, "", 0),
+		},
+		Listener(ln),
+		LogSlowHandshakes(100*time.Millisecond),
+	)
+
+	// performs TLS handshake with the server, returns local address of the connection
+	handshake := func(t *testing.T, delay time.Duration) string {
+		t.Helper()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dialing server: %v", err)
+		}
+		tc := tls.Client(&throttledConn{Conn: c, delay: delay}, &tls.Config{InsecureSkipVerify: true})
+		defer tc.Close()
+		if err := tc.Handshake(); err != nil {
+			t.Fatalf("TLS handshake: %v", err)
+		}
+		// server completes the handshake when it reads our last handshake message
+		tc.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+		tc.Read(make([]byte, 1))
+		return c.LocalAddr().String()
+	}
+
+	fast := handshake(t, 0)
+	slow := handshake(t, 150*time.Millisecond)
+
+	for i := 0; !strings.Contains(logs.String(), slow); i++ {
+		if i == 20 {
+			t.Fatalf("slow handshake from %s wasn't logged, log: %q", slow, logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := logs.String(); strings.Contains(s, fast) || !strings.Contains(s, "slow TLS handshake") {
+		t.Errorf("unexpected log: %q", s)
+	}
+}
@@ -0,0 +1,84 @@
+package httpsrv
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"slices"
+)
+
+/*
+DefaultHeaders makes the server to add the headers to every response, including the responses
+generated by the params (ie rejections by [MaxConcurrentRequests]). This centralizes the security
+headers (HSTS, X-Content-Type-Options...) instead of setting them in every handler:
+
+	httpsrv.DefaultHeaders(http.Header{
+		"Strict-Transport-Security": {"max-age=63072000"},
+		"X-Content-Type-Options":    {"nosniff"},
+	}, false)
+
+The headers are added right before the response headers are written. When force is false the
+header is only added when the handler hasn't set it (handler overrides the default), when force
+is true the header set by the handler is replaced.
+*/
+func DefaultHeaders(h http.Header, force bool) ServerParam {
+	ch := make(http.Header, len(h))
+	for k, v := range h {
+		ch[http.CanonicalHeaderKey(k)] = slices.Clone(v)
+	}
+	return serverParam{func(cfg *serverConf) { cfg.defaultHeaders, cfg.forceHeaders = ch, force }}
+}
+
+func (cfg *serverConf) addDefaultHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headersWriter{ResponseWriter: w, headers: cfg.defaultHeaders, force: cfg.forceHeaders}
+		next.ServeHTTP(hw, r)
+		hw.writeHeaders()
+	})
+}
+
+/*
+headersWriter adds the default headers to the response right before the headers are written.
+*/
+type headersWriter struct {
+	http.ResponseWriter
+	headers http.Header
+	force   bool
+	written bool // headers have been written
+}
+
+func (w *headersWriter) writeHeaders() {
+	if w.written {
+		return
+	}
+	w.written = true
+	h := w.Header()
+	for k, v := range w.headers {
+		if _, ok := h[k]; !ok || w.force {
+			h[k] = v
+		}
+	}
+}
+
+func (w *headersWriter) WriteHeader(code int) {
+	w.writeHeaders()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headersWriter) Write(b []byte) (int, error) {
+	w.writeHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headersWriter) Flush() {
+	w.writeHeaders()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headersWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.written = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *headersWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
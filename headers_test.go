@@ -0,0 +1,53 @@
+package httpsrv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_DefaultHeaders(t *testing.T) {
+	t.Parallel()
+
+	defaults := http.Header{
+		"x-content-type-options": {"nosniff"},
+		"Cache-Control":          {"no-store"},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cached" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		if r.URL.Path != "/empty" {
+			w.Write([]byte("ok"))
+		}
+	})
+
+	serve := func(force bool, path string) http.Header {
+		cfg := &serverConf{}
+		DefaultHeaders(defaults, force).apply(cfg)
+		rec := httptest.NewRecorder()
+		cfg.wrapHandler(handler).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Header()
+	}
+
+	testCases := []struct {
+		force bool
+		path  string
+		cache string
+	}{
+		{force: false, path: "/", cache: "no-store"},
+		{force: false, path: "/empty", cache: "no-store"}, // handler doesn't write anything
+		{force: false, path: "/cached", cache: "max-age=60"},
+		{force: true, path: "/", cache: "no-store"},
+		{force: true, path: "/cached", cache: "no-store"},
+	}
+	for _, tc := range testCases {
+		h := serve(tc.force, tc.path)
+		if v := h.Get("X-Content-Type-Options"); v != "nosniff" {
+			t.Errorf("force=%t %s: expected X-Content-Type-Options to be nosniff, got %q", tc.force, tc.path, v)
+		}
+		if v := h.Get("Cache-Control"); v != tc.cache {
+			t.Errorf("force=%t %s: expected Cache-Control to be %q, got %q", tc.force, tc.path, tc.cache, v)
+		}
+	}
+}
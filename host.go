@@ -0,0 +1,37 @@
+package httpsrv
+
+import (
+	"net/http"
+)
+
+/*
+HostPolicy sets func which is consulted with the Host header of every request (including the
+port when it is present) before the request reaches the server's handler. When the policy
+returns non-empty redirect the request is redirected (301 Moved Permanently) to the same path
+and query on that host, otherwise when allowed is false the request gets 404 (Not Found) response.
+
+This allows to enforce canonical host (ie "www.example.com" instead of "example.com") or to
+reject requests for unknown tenants at the edge. The [ReadinessEndpoint] is not subject to
+the policy as probes are usually sent using the IP address of the instance.
+*/
+func HostPolicy(policy func(host string) (allowed bool, redirect string)) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.hostPolicy = policy }}
+}
+
+func (cfg *serverConf) enforceHostPolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, redirect := cfg.hostPolicy(r.Host)
+		switch {
+		case redirect != "":
+			scheme := "http://"
+			if r.TLS != nil {
+				scheme = "https://"
+			}
+			http.Redirect(w, r, scheme+redirect+r.URL.RequestURI(), http.StatusMovedPermanently)
+		case !allowed:
+			http.NotFound(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package httpsrv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_HostPolicy(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{}
+	HostPolicy(func(host string) (bool, string) {
+		switch host {
+		case "www.example.com":
+			return true, ""
+		case "example.com":
+			return false, "www.example.com"
+		default:
+			return false, ""
+		}
+	}).apply(cfg)
+	ReadinessEndpoint("/readyz").apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+
+	t.Run("allowed host", func(t *testing.T) {
+		if rec := serve("http://www.example.com/foo"); rec.Code != http.StatusNoContent {
+			t.Errorf("expected status 204, got %d", rec.Code)
+		}
+	})
+
+	t.Run("rejected host", func(t *testing.T) {
+		if rec := serve("http://evil.example.org/foo"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("redirected host", func(t *testing.T) {
+		rec := serve("http://example.com/foo?bar=1")
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("expected status 301, got %d", rec.Code)
+		}
+		if loc := rec.Header().Get("Location"); loc != "http://www.example.com/foo?bar=1" {
+			t.Errorf("unexpected redirect location %q", loc)
+		}
+	})
+
+	t.Run("readiness endpoint is not subject to policy", func(t *testing.T) {
+		if rec := serve("http://10.0.0.1/readyz"); rec.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+/*
+Package http3 implements HTTP/3 (QUIC) server running alongside the server managed by the
+[github.com/ainvaltin/httpsrv] package. It is separate module so that the httpsrv package
+doesn't depend on the quic-go module.
+*/
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/ainvaltin/httpsrv"
+	quic "github.com/quic-go/quic-go/http3"
+)
+
+var errWithoutTLS = errors.New("HTTP3 server requires the main server to be configured to serve TLS")
+
+/*
+Listen starts HTTP/3 (QUIC) server on the UDP address addr alongside the HTTP/1 and HTTP/2 server
+started by [httpsrv.Run], serving the same handler with the same certificate, and advertises it
+to the clients using the Alt-Svc header in the responses of the TLS server:
+
+	err := httpsrv.Run(ctx, srv, httpsrv.TLS(certFile, keyFile), http3.Listen(":443"))
+
+The HTTP/3 server is started after the main server's listener has been bound and it is closed
+alongside stopping the main server - as quic-go doesn't support graceful shutdown yet the
+in-flight HTTP/3 requests are aborted. When the HTTP/3 server fails the main server is stopped
+too. The main server must serve TLS (see [httpsrv.TLS]), otherwise Run fails.
+*/
+func Listen(addr string) httpsrv.ServerParam {
+	return httpsrv.WithCompanion("alt-svc", &server{addr: addr, done: make(chan struct{})})
+}
+
+type server struct {
+	addr string
+	done chan struct{} // closed when Serve has returned
+
+	m      sync.Mutex
+	srv    *quic.Server
+	closed bool
+}
+
+func (s *server) Serve(ctx context.Context, handler http.Handler, tc *tls.Config) error {
+	defer close(s.done)
+	if tc == nil {
+		return errWithoutTLS
+	}
+
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("http3 server: %w", err)
+	}
+	defer conn.Close()
+
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return nil
+	}
+	s.srv = &quic.Server{
+		Handler:   handler,
+		TLSConfig: quic.ConfigureTLSConfig(tc),
+		Port:      conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	s.m.Unlock()
+
+	if err := s.srv.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http3 server: %w", err)
+	}
+	return nil
+}
+
+// Drain closes the HTTP/3 server and waits until it has exited.
+func (s *server) Drain(ctx context.Context) error {
+	s.m.Lock()
+	s.closed = true
+	srv := s.srv
+	s.m.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	err := srv.Close()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+	return err
+}
+
+// Wrap adds the Alt-Svc header advertising the HTTP/3 server to the responses of the TLS server.
+func (s *server) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			s.m.Lock()
+			srv := s.srv
+			s.m.Unlock()
+			if srv != nil {
+				srv.SetQuicHeaders(w.Header())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package http3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ainvaltin/httpsrv"
+	quic "github.com/quic-go/quic-go/http3"
+)
+
+func Test_Listen(t *testing.T) {
+	t.Parallel()
+
+	cert := testCertificate(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	// UDP port for the HTTP/3 server
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	h3Addr := pc.LocalAddr().String()
+	pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- httpsrv.Run(ctx,
+			&http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(r.Proto))
+				}),
+				TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			},
+			httpsrv.Listener(ln),
+			httpsrv.ShutdownTimeout(time.Second),
+			Listen(h3Addr),
+		)
+	}()
+
+	tlsConf := &tls.Config{InsecureSkipVerify: true}
+	get := func(t *testing.T, c *http.Client, url string) *http.Response {
+		t.Helper()
+		var rsp *http.Response
+		for i := 0; ; i++ {
+			if rsp, err = c.Get(url); err == nil {
+				break
+			}
+			if i == 20 {
+				t.Fatalf("GET %s: %v", url, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	rt := &quic.RoundTripper{TLSClientConfig: tlsConf}
+	defer rt.Close()
+	if rsp := get(t, &http.Client{Timeout: time.Second, Transport: rt}, "https://"+h3Addr); rsp.ProtoMajor != 3 {
+		t.Errorf("expected HTTP/3 response, got %s", rsp.Proto)
+	}
+
+	c := &http.Client{Timeout: time.Second, Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	rsp := get(t, c, "https://"+ln.Addr().String())
+	if alt := rsp.Header.Get("Alt-Svc"); !strings.Contains(alt, `h3=":`+h3Addr[strings.LastIndex(h3Addr, ":")+1:]+`"`) {
+		t.Errorf("unexpected Alt-Svc header %q", alt)
+	}
+
+	cancel()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	}
+	// the HTTP/3 server has been stopped too
+	if conn, err := net.ListenPacket("udp", h3Addr); err != nil {
+		t.Errorf("expected HTTP/3 server to release the UDP port: %v", err)
+	} else {
+		conn.Close()
+	}
+}
+
+func Test_Listen_requires_TLS(t *testing.T) {
+	t.Parallel()
+
+	err := httpsrv.Run(context.Background(),
+		&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+		Listen("127.0.0.1:0"),
+	)
+	if !errors.Is(err, errWithoutTLS) {
+		t.Errorf("expected %v, got %v", errWithoutTLS, err)
+	}
+}
+
+// testCertificate returns self signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "httpsrv test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,266 @@
+package httpsrv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+IdempotentResponse is the response recorded for the idempotency key, see [IdempotencyKeys].
+*/
+type IdempotentResponse struct {
+	Status      int
+	Header      http.Header
+	Body        []byte
+	RequestHash []byte // SHA-256 of the body of the request the response was recorded for
+}
+
+/*
+IdempotencyStore stores the responses recorded by the [IdempotencyKeys] middleware. Store shared
+between the instances of the service (ie backed by Redis) allows to replay the response when the
+retry is routed to different instance. Methods must be safe for concurrent use.
+*/
+type IdempotencyStore interface {
+	// Get returns the response stored for the key, ok is false when there is no
+	// (unexpired) response for the key.
+	Get(key string) (rsp *IdempotentResponse, ok bool)
+	// Set stores the response for the key, it must expire after ttl.
+	Set(key string, rsp *IdempotentResponse, ttl time.Duration)
+}
+
+/*
+IdempotencyKeys installs middleware which makes the requests carrying the "Idempotency-Key" header
+safe to retry - the response to the first request is recorded and replayed (with header
+"Idempotent-Replayed: true") to the requests with the same key, method, path and scope arriving
+within the ttl, instead of invoking the handler again. This is common edge feature of (payment
+like) APIs where clients retry POST requests.
+
+The scope func returns the identity of the client (ie the authenticated user ID) so that the keys
+of different clients do not collide and client can't get the response recorded for another one
+by reusing (guessing) the key. When scope is nil the keys are shared by all the clients which is
+only safe when the responses are not sensitive. Retry with the key of the request which had
+different body gets response with status 422 (Unprocessable Entity).
+
+Only requests with unsafe methods (other than GET, HEAD, OPTIONS and TRACE) are considered, their
+body is read into memory to compute its hash before calling the handler - request with body larger
+than 1 MiB gets response with status 413 (Request Entity Too Large). Responses with status 5xx are
+not recorded so that the request can be retried. Neither are recorded responses with body larger
+than 1 MiB and streamed responses (the handler flushes or hijacks the connection). Request with
+the key of the request still being served gets response with status 409 (Conflict).
+
+When store is nil the responses are kept in memory, see [NewMemoryIdempotencyStore].
+*/
+func IdempotencyKeys(ttl time.Duration, store IdempotencyStore, scope func(*http.Request) string) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		if store == nil {
+			store = NewMemoryIdempotencyStore()
+		}
+		cfg.idempotency = &idempotency{ttl: ttl, store: store, scope: scope, inFlight: make(map[string]struct{})}
+	}}
+}
+
+type idempotency struct {
+	ttl   time.Duration
+	store IdempotencyStore
+	scope func(*http.Request) string // identity of the client, keys are shared when nil
+
+	m        sync.Mutex
+	inFlight map[string]struct{} // keys of the requests being served
+}
+
+func (id *idempotency) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			key = ""
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if id.scope != nil {
+			key = id.scope(r) + " " + key
+		}
+		key = r.Method + " " + r.URL.Path + " " + key
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "request body is too large for idempotent request", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		if rsp, ok := id.store.Get(key); ok {
+			replay(w, rsp, hash[:])
+			return
+		}
+		if !id.begin(key) {
+			http.Error(w, "request with the same idempotency key is being processed", http.StatusConflict)
+			return
+		}
+		defer id.end(key)
+		// the request with the same key might have completed between the Get and begin
+		if rsp, ok := id.store.Get(key); ok {
+			replay(w, rsp, hash[:])
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status < 500 && !rec.skip {
+			id.store.Set(key, &IdempotentResponse{Status: rec.status, Header: rec.header, Body: rec.body.Bytes(), RequestHash: hash[:]}, id.ttl)
+		}
+	})
+}
+
+func (id *idempotency) begin(key string) bool {
+	id.m.Lock()
+	defer id.m.Unlock()
+	if _, ok := id.inFlight[key]; ok {
+		return false
+	}
+	id.inFlight[key] = struct{}{}
+	return true
+}
+
+func (id *idempotency) end(key string) {
+	id.m.Lock()
+	defer id.m.Unlock()
+	delete(id.inFlight, key)
+}
+
+// replay writes the recorded response unless it was recorded for request with different body.
+func replay(w http.ResponseWriter, rsp *IdempotentResponse, requestHash []byte) {
+	if !bytes.Equal(rsp.RequestHash, requestHash) {
+		http.Error(w, "idempotency key was already used with different request body", http.StatusUnprocessableEntity)
+		return
+	}
+	for k, v := range rsp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rsp.Status)
+	w.Write(rsp.Body)
+}
+
+// maxIdempotentBody is the max size of the response body recorded for the idempotency key.
+const maxIdempotentBody = 1 << 20
+
+/*
+recordingWriter records the response while writing it to the client. When the body grows over
+maxIdempotentBody or the response is streamed the recording is abandoned (skip is set).
+*/
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+	skip   bool // response is not recordable
+}
+
+func (w *recordingWriter) abandon() {
+	w.skip = true
+	w.body = bytes.Buffer{}
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.skip {
+		if w.body.Len()+len(b) > maxIdempotentBody {
+			w.abandon()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Flush() {
+	w.abandon()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.abandon()
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *recordingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+/*
+NewMemoryIdempotencyStore returns [IdempotencyStore] which keeps the responses in memory,
+expired response is purged when it is looked up, the others are swept at most once a minute
+when new response is stored.
+*/
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+}
+
+// idempotencySweepInterval is how often memoryIdempotencyStore purges all the expired entries.
+const idempotencySweepInterval = time.Minute
+
+type memoryIdempotencyStore struct {
+	m         sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	nextSweep time.Time // when to purge the expired entries next
+}
+
+type memoryIdempotencyEntry struct {
+	rsp     *IdempotentResponse
+	expires time.Time
+}
+
+func (s *memoryIdempotencyStore) Get(key string) (*IdempotentResponse, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(e.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.rsp, true
+}
+
+func (s *memoryIdempotencyStore) Set(key string, rsp *IdempotentResponse, ttl time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	if !now.Before(s.nextSweep) {
+		s.nextSweep = now.Add(idempotencySweepInterval)
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[key] = memoryIdempotencyEntry{rsp: rsp, expires: now.Add(ttl)}
+}
@@ -0,0 +1,229 @@
+package httpsrv
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_IdempotencyKeys(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	cfg := &serverConf{}
+	IdempotencyKeys(200*time.Millisecond, nil, nil).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.URL.Path == "/fail" {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/payments/%d", n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "payment %d", n)
+	}))
+
+	serve := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	expectResponse := func(t *testing.T, rec *httptest.ResponseRecorder, body string, replayed bool) {
+		t.Helper()
+		if rec.Code != http.StatusCreated || rec.Body.String() != body || rec.Header().Get("Location") != "/payments/"+body[len("payment "):] {
+			t.Errorf("unexpected response %d %v: %q", rec.Code, rec.Header(), rec.Body.String())
+		}
+		if r := rec.Header().Get("Idempotent-Replayed") == "true"; r != replayed {
+			t.Errorf("expected replayed to be %t", replayed)
+		}
+	}
+
+	// first request is a miss, handler is called
+	expectResponse(t, serve("POST", "/pay", "k1"), "payment 1", false)
+	// retry is replayed without calling the handler
+	expectResponse(t, serve("POST", "/pay", "k1"), "payment 1", true)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected handler to be called once, got %d", n)
+	}
+
+	// different key, path or requests without key are not replayed
+	expectResponse(t, serve("POST", "/pay", "k2"), "payment 2", false)
+	expectResponse(t, serve("POST", "/pay2", "k1"), "payment 3", false)
+	expectResponse(t, serve("POST", "/pay", ""), "payment 4", false)
+	expectResponse(t, serve("GET", "/pay", "k1"), "payment 5", false)
+
+	// server errors are not recorded
+	serve("POST", "/fail", "k3")
+	if rec := serve("POST", "/fail", "k3"); rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("expected server error not to be replayed")
+	}
+	if n := calls.Load(); n != 7 {
+		t.Errorf("expected handler to be called 7 times, got %d", n)
+	}
+
+	// recorded response expires
+	time.Sleep(250 * time.Millisecond)
+	expectResponse(t, serve("POST", "/pay", "k1"), "payment 8", false)
+}
+
+// staleIdempotencyStore misses the first Get of every key as if the response was stored
+// concurrently right after it.
+type staleIdempotencyStore struct {
+	IdempotencyStore
+	seen sync.Map
+}
+
+func (s *staleIdempotencyStore) Get(key string) (*IdempotentResponse, bool) {
+	if _, seen := s.seen.LoadOrStore(key, true); !seen {
+		return nil, false
+	}
+	return s.IdempotencyStore.Get(key)
+}
+
+func Test_IdempotencyKeys_race(t *testing.T) {
+	t.Parallel()
+
+	// the first request has completed between the Get and begin of the second one
+	store := &staleIdempotencyStore{IdempotencyStore: NewMemoryIdempotencyStore()}
+	emptyBody := sha256.Sum256(nil)
+	store.IdempotencyStore.Set("POST /pay k1", &IdempotentResponse{Status: http.StatusCreated, Body: []byte("payment 1"), RequestHash: emptyBody[:]}, time.Minute)
+
+	cfg := &serverConf{}
+	IdempotencyKeys(time.Minute, store, nil).apply(cfg)
+	var calls atomic.Int32
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }))
+
+	req := httptest.NewRequest("POST", "/pay", nil)
+	req.Header.Set("Idempotency-Key", "k1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if n := calls.Load(); n != 0 {
+		t.Errorf("expected handler not to be called, got %d calls", n)
+	}
+	if rec.Code != http.StatusCreated || rec.Body.String() != "payment 1" || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected stored response to be replayed, got %d %v: %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+func Test_IdempotencyKeys_notRecorded(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	cfg := &serverConf{}
+	IdempotencyKeys(time.Minute, nil, nil).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/stream":
+			f, ok := w.(http.Flusher)
+			if !ok {
+				t.Error("expected ResponseWriter to implement http.Flusher")
+				return
+			}
+			fmt.Fprint(w, "chunk")
+			f.Flush()
+		case "/large":
+			w.Write(make([]byte, maxIdempotentBody+1))
+		}
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Idempotency-Key", "k1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/stream", "/large"} {
+		calls.Store(0)
+		serve(path)
+		if rec := serve(path); rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("[%s] expected response not to be replayed", path)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("[%s] expected handler to be called twice, got %d", path, n)
+		}
+	}
+	if rec := serve("/stream"); !rec.Flushed || rec.Body.String() != "chunk" {
+		t.Errorf("expected response to be flushed, got %q", rec.Body.String())
+	}
+}
+
+func Test_IdempotencyKeys_scopeAndBody(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	cfg := &serverConf{}
+	IdempotencyKeys(time.Minute, nil, func(r *http.Request) string { return r.Header.Get("X-User") }).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "payment %d: %s", n, b)
+	}))
+
+	serve := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/pay", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("alice", "10 EUR"); rec.Body.String() != "payment 1: 10 EUR" {
+		t.Errorf("unexpected response %d: %q", rec.Code, rec.Body.String())
+	}
+	// the handler sees the request body, retry with the same body is replayed
+	if rec := serve("alice", "10 EUR"); rec.Body.String() != "payment 1: 10 EUR" || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected response to be replayed, got %d: %q", rec.Code, rec.Body.String())
+	}
+	// same key used by another client is not replayed
+	if rec := serve("bob", "10 EUR"); rec.Body.String() != "payment 2: 10 EUR" || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected another client not to get the replay, got %d: %q", rec.Code, rec.Body.String())
+	}
+	// retry with different body
+	if rec := serve("alice", "20 EUR"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for different body, got %d: %q", rec.Code, rec.Body.String())
+	}
+	// too large body
+	if rec := serve("carol", strings.Repeat("x", maxIdempotentBody+1)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for too large body, got %d", rec.Code)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected handler to be called twice, got %d", n)
+	}
+}
+
+func Test_memoryIdempotencyStore_expire(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryIdempotencyStore().(*memoryIdempotencyStore)
+	store.Set("a", &IdempotentResponse{}, -time.Second)
+	store.Set("b", &IdempotentResponse{}, time.Minute)
+	// expired entries are swept at most once per interval
+	if n := len(store.entries); n != 2 {
+		t.Errorf("expected 2 entries before the next sweep, got %d", n)
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Error("expected expired entry not to be returned")
+	}
+	if _, ok := store.entries["a"]; ok {
+		t.Error("expected expired entry to be purged on lookup")
+	}
+	if _, ok := store.Get("b"); !ok {
+		t.Error("expected unexpired entry to be returned")
+	}
+}
@@ -0,0 +1,86 @@
+package httpsrv
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ReportInFlight makes the server to track the requests being served so that when the graceful
+shutdown times out (see [ShutdownTimeout]) the [StopError] returned by [Run] lists the requests
+which were still running at the time the connections were force-closed. This turns the plain
+"context deadline exceeded" into actionable report of which handler is too slow:
+
+	graceful shutdown: context deadline exceeded; in flight: GET /report (age 31.2s, from 10.0.0.7:51234)
+*/
+func ReportInFlight() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.reportInFlight = true }}
+}
+
+/*
+WithInFlight wraps handler h so that the requests being served by it are tracked, the returned
+func lists the requests currently in flight (the oldest first). This is the tracker used by the
+[ReportInFlight] parameter, exposed so that it can be used with handlers which are not served by
+[Run], ie to report the slow requests from a custom shutdown routine.
+*/
+func WithInFlight(h http.Handler) (http.Handler, func() []InFlightRequest) {
+	ifr := &inFlightRequests{active: make(map[*http.Request]time.Time)}
+	return ifr.wrap(h), ifr.snapshot
+}
+
+// InFlightRequest describes request which was still being served when the server was stopped, see [ReportInFlight].
+type InFlightRequest struct {
+	Method     string
+	Path       string
+	RemoteAddr string
+	Age        time.Duration // for how long the request had been served
+}
+
+func (r InFlightRequest) String() string {
+	return r.Method + " " + r.Path + " (age " + r.Age.Round(time.Millisecond).String() + ", from " + r.RemoteAddr + ")"
+}
+
+type inFlightRequests struct {
+	m      sync.Mutex
+	active map[*http.Request]time.Time // start time of the request
+}
+
+func (ifr *inFlightRequests) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifr.m.Lock()
+		ifr.active[r] = time.Now()
+		ifr.m.Unlock()
+		defer func() {
+			ifr.m.Lock()
+			delete(ifr.active, r)
+			ifr.m.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// snapshot returns the requests currently being served, the oldest first.
+func (ifr *inFlightRequests) snapshot() []InFlightRequest {
+	now := time.Now()
+	ifr.m.Lock()
+	reqs := make([]InFlightRequest, 0, len(ifr.active))
+	for r, start := range ifr.active {
+		reqs = append(reqs, InFlightRequest{Method: r.Method, Path: r.URL.Path, RemoteAddr: r.RemoteAddr, Age: now.Sub(start)})
+	}
+	ifr.m.Unlock()
+	slices.SortFunc(reqs, func(a, b InFlightRequest) int { return int(b.Age - a.Age) })
+	return reqs
+}
+
+// inFlightSummary formats the requests for the error message.
+func inFlightSummary(reqs []InFlightRequest) string {
+	s := make([]string, len(reqs))
+	for i, r := range reqs {
+		s[i] = r.String()
+	}
+	return strings.Join(s, ", ")
+}
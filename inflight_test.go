@@ -0,0 +1,106 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ReportInFlight(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	inHandler := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		<-release
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Handler: mux},
+			Listener(ln),
+			ShutdownTimeout(100*time.Millisecond),
+			ReportInFlight(),
+		)
+	}()
+
+	c := &http.Client{Timeout: time.Second}
+	rsp, err := c.Get("http://" + ln.Addr().String() + "/fast")
+	if err != nil {
+		t.Fatalf("GET request failed: %v", err)
+	}
+	rsp.Body.Close()
+	go func() {
+		if rsp, err := c.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+			rsp.Body.Close()
+		}
+	}()
+	<-inHandler
+	cancel()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		var se *StopError
+		if !errors.As(err, &se) {
+			t.Fatalf("expected StopError, got %v", err)
+		}
+		if len(se.InFlight) != 1 {
+			t.Fatalf("expected one in-flight request, got %v", se.InFlight)
+		}
+		if r := se.InFlight[0]; r.Method != "GET" || r.Path != "/slow" || r.Age < 100*time.Millisecond || r.RemoteAddr == "" {
+			t.Errorf("unexpected in-flight request: %#v", r)
+		}
+		if !strings.Contains(err.Error(), "in flight: GET /slow (age ") {
+			t.Errorf("expected error message to name the slow request, got %q", err)
+		}
+	}
+}
+
+func Test_WithInFlight(t *testing.T) {
+	t.Parallel()
+
+	inHandler := make(chan struct{})
+	release := make(chan struct{})
+	h, inFlight := WithInFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inHandler <- struct{}{}
+		<-release
+	}))
+
+	if reqs := inFlight(); len(reqs) != 0 {
+		t.Errorf("expected no requests in flight, got %v", reqs)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-inHandler
+	reqs := inFlight()
+	if len(reqs) != 1 || reqs[0].Method != "GET" || reqs[0].Path != "/slow" {
+		t.Errorf("unexpected requests in flight: %v", reqs)
+	}
+
+	close(release)
+	<-done
+	if reqs := inFlight(); len(reqs) != 0 {
+		t.Errorf("expected no requests in flight after the handler returned, got %v", reqs)
+	}
+}
@@ -0,0 +1,290 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrMaxRequestsReached is the reason server was shut down when it has served
+// the number of requests set by [MaxRequests] parameter.
+var ErrMaxRequestsReached = errors.New("maximum number of requests served")
+
+/*
+MaxConcurrentRequests limits the number of requests served concurrently to n. By default requests
+over the limit are not queued but served by onLimit handler immediately, use [RequestQueueTimeout]
+to make them wait for a free slot instead. When onLimit is nil requests over the limit get response
+with status 429 (Too Many Requests) and Retry-After header telling the client when to retry, see
+[RetryAfter].
+
+This is different from limiting the number of connections as HTTP/2 multiplexes many requests
+over single connection. When n is smaller than or equal to zero there is no limit.
+*/
+func MaxConcurrentRequests(n int, onLimit http.Handler) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.maxConcurrent, cfg.onLimit = n, onLimit }}
+}
+
+/*
+RequestQueueTimeout makes requests over the [MaxConcurrentRequests] limit to wait up to timeout
+for a free slot before they are handed to the onLimit handler. Request also stops waiting
+when it's context is cancelled (ie client went away).
+*/
+func RequestQueueTimeout(timeout time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.queueTimeout = timeout }}
+}
+
+/*
+MaxQueueWait caps the time requests over the [MaxConcurrentRequests] limit wait in the queue for
+a free slot, shedding the load based on latency rather than count. Request is rejected with
+status 503 (Service Unavailable) right away when the estimated wait (based on the number of
+queued requests and the average service time) is longer than d, or when it has waited for d
+without getting a slot. This prevents unbounded latency during overload. The Retry-After header
+is set like for the responses sent when the limit is reached, see [RetryAfter].
+
+MaxQueueWait enables queueing, it can be combined with [RequestQueueTimeout] in which case
+the shorter of the two applies. The time request waited in the queue is available to the
+handler (and middleware) via [QueueWait].
+*/
+func MaxQueueWait(d time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.maxQueueWait = d }}
+}
+
+type queueWaitKey struct{}
+
+/*
+QueueWait returns the time the request waited in the queue for a free slot of the
+[MaxConcurrentRequests] limit, the ctx must be the request context. Zero is returned
+when the request didn't wait or when queueing is not enabled (see [RequestQueueTimeout]
+and [MaxQueueWait]).
+*/
+func QueueWait(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(queueWaitKey{}).(time.Duration)
+	return d
+}
+
+/*
+OverloadStats describes the load of the server when request is rejected by the [MaxConcurrentRequests]
+limit, see [RetryAfter].
+*/
+type OverloadStats struct {
+	Limit          int           // max number of concurrent requests
+	InFlight       int           // number of requests being served
+	Queued         int           // number of requests waiting for a free slot, see RequestQueueTimeout
+	AvgServiceTime time.Duration // moving average of the time it takes to serve a request
+}
+
+/*
+RetryAfter sets func which computes the value of the Retry-After header of the responses to the
+requests rejected by the [MaxConcurrentRequests] limit (when the default onLimit handler is used).
+Value is sent in seconds, rounded up. When the func returns zero or negative duration the header
+is not sent.
+
+By default the retry delay is the time it takes to serve the queued requests (plus the rejected
+one) based on the average service time, but at least one second.
+*/
+func RetryAfter(f func(OverloadStats) time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.retryAfter = f }}
+}
+
+func defaultRetryAfter(s OverloadStats) time.Duration {
+	return max(time.Second, time.Duration(s.Queued+1)*s.AvgServiceTime/time.Duration(s.Limit))
+}
+
+type requestLimiter struct {
+	cfg     *serverConf
+	sem     chan struct{}
+	wait    time.Duration // max time to wait for free slot
+	shed    bool          // requests are shed by the MaxQueueWait
+	onLimit http.Handler
+	next    http.Handler
+
+	queued  atomic.Int64 // number of requests waiting for a free slot
+	avgTime movingAverage
+}
+
+func newRequestLimiter(cfg *serverConf, next http.Handler) *requestLimiter {
+	l := &requestLimiter{
+		cfg:     cfg,
+		sem:     make(chan struct{}, cfg.maxConcurrent),
+		wait:    cfg.queueTimeout,
+		onLimit: cfg.onLimit,
+		next:    next,
+	}
+	if cfg.maxQueueWait > 0 && (l.wait <= 0 || cfg.maxQueueWait <= l.wait) {
+		l.wait, l.shed = cfg.maxQueueWait, true
+	}
+	if l.onLimit == nil {
+		l.onLimit = http.HandlerFunc(l.tooManyRequests)
+	}
+	return l
+}
+
+func (l *requestLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ok, waited := l.acquire(r)
+	if !ok {
+		if l.shed {
+			l.cfg.rejected(r, RejectQueueWait)
+			l.setRetryAfter(w)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		l.cfg.rejected(r, RejectConcurrencyLimit)
+		l.onLimit.ServeHTTP(w, r)
+		return
+	}
+	if waited > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), queueWaitKey{}, waited))
+	}
+	defer func(start time.Time) {
+		<-l.sem
+		l.avgTime.add(time.Since(start))
+	}(time.Now())
+	l.next.ServeHTTP(w, r)
+}
+
+// movingAverage is exponential moving average of the (service) time.
+type movingAverage struct{ v atomic.Int64 }
+
+func (ma *movingAverage) add(d time.Duration) {
+	for {
+		avg := ma.v.Load()
+		n := int64(d)
+		if avg != 0 {
+			n = avg + (n-avg)/8
+		}
+		if ma.v.CompareAndSwap(avg, n) {
+			return
+		}
+	}
+}
+
+func (ma *movingAverage) value() time.Duration { return time.Duration(ma.v.Load()) }
+
+func (l *requestLimiter) tooManyRequests(w http.ResponseWriter, r *http.Request) {
+	l.setRetryAfter(w)
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+func (l *requestLimiter) setRetryAfter(w http.ResponseWriter) {
+	retryAfter := defaultRetryAfter
+	if l.cfg.retryAfter != nil {
+		retryAfter = l.cfg.retryAfter
+	}
+	d := retryAfter(l.stats())
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+	}
+}
+
+func (l *requestLimiter) stats() OverloadStats {
+	return OverloadStats{
+		Limit:          cap(l.sem),
+		InFlight:       len(l.sem),
+		Queued:         int(l.queued.Load()),
+		AvgServiceTime: l.avgTime.value(),
+	}
+}
+
+// acquire takes a free slot, waited is the time the request waited in the queue.
+func (l *requestLimiter) acquire(r *http.Request) (ok bool, waited time.Duration) {
+	select {
+	case l.sem <- struct{}{}:
+		return true, 0
+	default:
+		if l.wait <= 0 {
+			return false, 0
+		}
+	}
+	// shed right away when the request would wait longer than allowed
+	if l.shed {
+		if s := l.stats(); time.Duration(s.Queued+1)*s.AvgServiceTime/time.Duration(s.Limit) > l.wait {
+			return false, 0
+		}
+	}
+
+	start := time.Now()
+	l.queued.Add(1)
+	defer l.queued.Add(-1)
+	t := time.NewTimer(l.wait)
+	defer t.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return true, time.Since(start)
+	case <-t.C:
+		return false, time.Since(start)
+	case <-r.Context().Done():
+		return false, time.Since(start)
+	}
+}
+
+/*
+MaxRequests makes the server to shut down gracefully after it has served n requests, [Run]
+returns [ErrMaxRequestsReached]. The shutdown starts after the n-th request has completed,
+requests over the limit arriving before the server has stopped get response with status 503
+(Service Unavailable). Useful for canary and chaos testing.
+
+When n is smaller than or equal to zero there is no limit.
+*/
+func MaxRequests(n int64) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		if n <= 0 {
+			cfg.maxRequests = nil
+			return
+		}
+		mr := &maxRequests{limit: n, reached: make(chan struct{})}
+		cfg.maxRequests = mr
+		cfg.watchers = append(cfg.watchers, mr.wait)
+	}}
+}
+
+/*
+ServeOnce runs the server (see [Run]) until it has served exactly one request and then shuts it
+down gracefully, ie it is shorthand for Run with [MaxRequests](1) parameter. It returns nil when
+the server exited because the request was served and it was stopped without errors. Useful for
+integration tests and chaos testing scenarios where server is expected to handle single request.
+*/
+func ServeOnce(srv *http.Server, params ...ServerParam) error {
+	params = append(append([]ServerParam(nil), params...), MaxRequests(1))
+	err := Run(context.Background(), srv, params...)
+	if re, ok := err.(*RunError); ok && re.Context == ErrMaxRequestsReached && re.Panic == nil && re.Serve == nil && re.Stop == nil {
+		return nil
+	}
+	return err
+}
+
+type maxRequests struct {
+	limit   int64
+	count   atomic.Int64
+	reached chan struct{} // closed when the last request within the limit has been served
+}
+
+func (mr *maxRequests) wait(ctx context.Context, cancel context.CancelCauseFunc) {
+	select {
+	case <-ctx.Done():
+	case <-mr.reached:
+		cancel(ErrMaxRequestsReached)
+	}
+}
+
+func (mr *maxRequests) wrap(cfg *serverConf, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(routeProbeKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		n := mr.count.Add(1)
+		if n > mr.limit {
+			cfg.rejected(r, RejectMaxRequests)
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if n == mr.limit {
+			defer close(mr.reached)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,359 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_MaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	// returns handler which blocks until release is closed and chan which
+	// receives a value when request enters the handler
+	blockingHandler := func() (http.Handler, chan struct{}, chan struct{}) {
+		entered, release := make(chan struct{}, 10), make(chan struct{})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusNoContent)
+		}), entered, release
+	}
+
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	t.Run("requests over the limit are served by onLimit", func(t *testing.T) {
+		next, entered, release := blockingHandler()
+		cfg := &serverConf{}
+		MaxConcurrentRequests(2, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})).apply(cfg)
+		h := cfg.wrapHandler(next)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if rec := serve(h); rec.Code != http.StatusNoContent {
+					t.Errorf("unexpected status %d", rec.Code)
+				}
+			}()
+			<-entered
+		}
+
+		// limit has been reached
+		if rec := serve(h); rec.Code != http.StatusTeapot {
+			t.Errorf("expected request over the limit to be served by onLimit, got status %d", rec.Code)
+		}
+
+		close(release)
+		wg.Wait()
+		// slots are free again
+		if rec := serve(h); rec.Code != http.StatusNoContent {
+			t.Errorf("unexpected status %d", rec.Code)
+		}
+	})
+
+	t.Run("default onLimit handler", func(t *testing.T) {
+		next, entered, release := blockingHandler()
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		h := cfg.wrapHandler(next)
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		<-entered
+
+		rec := serve(h)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status 429, got %d", rec.Code)
+		}
+		if ra := rec.Header().Get("Retry-After"); ra != "1" {
+			t.Errorf("expected Retry-After to be 1 second, got %q", ra)
+		}
+		close(release)
+		<-done
+	})
+
+	t.Run("request waits for a free slot", func(t *testing.T) {
+		next, entered, release := blockingHandler()
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		RequestQueueTimeout(time.Second).apply(cfg)
+		h := cfg.wrapHandler(next)
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		<-entered
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+		}()
+		if rec := serve(h); rec.Code != http.StatusNoContent {
+			t.Errorf("expected queued request to be served, got status %d", rec.Code)
+		}
+		<-done
+	})
+
+	t.Run("queue timeout expires", func(t *testing.T) {
+		next, entered, release := blockingHandler()
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		RequestQueueTimeout(50 * time.Millisecond).apply(cfg)
+		h := cfg.wrapHandler(next)
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		<-entered
+
+		start := time.Now()
+		if rec := serve(h); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status 429, got %d", rec.Code)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("expected request to wait for the queue timeout, waited %s", d)
+		}
+		close(release)
+		<-done
+	})
+
+	t.Run("Retry-After under overload", func(t *testing.T) {
+		var stats OverloadStats
+		cfg := &serverConf{}
+		MaxConcurrentRequests(2, nil).apply(cfg)
+		RequestQueueTimeout(time.Second).apply(cfg)
+		RetryAfter(func(s OverloadStats) time.Duration {
+			stats = s
+			return defaultRetryAfter(s) + 2500*time.Millisecond
+		}).apply(cfg)
+		release := make(chan struct{})
+		h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				<-release
+			} else {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}))
+
+		// establish the average service time
+		for i := 0; i < 3; i++ {
+			serve(h)
+		}
+		// occupy both slots and the queue
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+			}()
+		}
+		for i := 0; len(h.(*requestLimiter).sem) != 2 || h.(*requestLimiter).queued.Load() != 1; i++ {
+			if i == 100 {
+				t.Fatal("requests didn't occupy the slots")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// cancelled request doesn't wait in the queue
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		close(release)
+		wg.Wait()
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status 429, got %d", rec.Code)
+		}
+		if ra := rec.Header().Get("Retry-After"); ra != "4" {
+			t.Errorf("expected Retry-After to be 4 seconds, got %q", ra)
+		}
+		if stats.Limit != 2 || stats.InFlight != 2 || stats.Queued != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+		if stats.AvgServiceTime < 20*time.Millisecond || stats.AvgServiceTime > time.Second {
+			t.Errorf("implausible average service time %s", stats.AvgServiceTime)
+		}
+	})
+
+	t.Run("queue wait is capped", func(t *testing.T) {
+		next, entered, release := blockingHandler()
+		var reasons []RejectReason
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		RequestQueueTimeout(time.Second).apply(cfg)
+		MaxQueueWait(50 * time.Millisecond).apply(cfg)
+		OnReject(func(reason RejectReason, remote net.Addr) { reasons = append(reasons, reason) }).apply(cfg)
+		h := cfg.wrapHandler(next)
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		<-entered
+
+		start := time.Now()
+		rec := serve(h)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %d", rec.Code)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond || d > 500*time.Millisecond {
+			t.Errorf("expected request to wait for the max queue wait, waited %s", d)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("expected Retry-After header to be set")
+		}
+		if len(reasons) != 1 || reasons[0] != RejectQueueWait {
+			t.Errorf("expected rejection reason to be reported, got %v", reasons)
+		}
+		close(release)
+		<-done
+	})
+
+	t.Run("request is shed when estimated wait is too long", func(t *testing.T) {
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		MaxQueueWait(100 * time.Millisecond).apply(cfg)
+		release := make(chan struct{})
+		var queueWait time.Duration
+		h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queueWait = QueueWait(r.Context())
+			<-release
+		}))
+		h.(*requestLimiter).avgTime.add(time.Second)
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		for len(h.(*requestLimiter).sem) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		start := time.Now()
+		if rec := serve(h); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %d", rec.Code)
+		}
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Errorf("expected request to be rejected without waiting, waited %s", d)
+		}
+		close(release)
+		<-done
+		if queueWait != 0 {
+			t.Errorf("expected request which got free slot right away not to wait, got %s", queueWait)
+		}
+	})
+
+	t.Run("queue wait is exposed to handler", func(t *testing.T) {
+		cfg := &serverConf{}
+		MaxConcurrentRequests(1, nil).apply(cfg)
+		MaxQueueWait(time.Second).apply(cfg)
+		entered, release := make(chan struct{}, 2), make(chan struct{})
+		waits := make(chan time.Duration, 2)
+		h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			waits <- QueueWait(r.Context())
+			entered <- struct{}{}
+			<-release
+		}))
+
+		done := make(chan struct{})
+		go func() { defer close(done); serve(h) }()
+		<-entered
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+		}()
+		if rec := serve(h); rec.Code != http.StatusOK {
+			t.Errorf("expected queued request to be served, got status %d", rec.Code)
+		}
+		<-done
+		if w := <-waits; w != 0 {
+			t.Errorf("expected the first request not to wait, got %s", w)
+		}
+		if w := <-waits; w < 100*time.Millisecond || w > time.Second {
+			t.Errorf("expected the second request to wait ~100ms, got %s", w)
+		}
+	})
+}
+
+func Test_MaxRequests(t *testing.T) {
+	t.Parallel()
+
+	const limit = 5
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	var served atomic.Int32
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		<-release
+	})
+
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(context.Background(), &http.Server{Handler: handler}, Listener(ln), MaxRequests(limit), ShutdownTimeout(time.Second))
+	}()
+
+	// send twice as many concurrent requests as is the limit
+	var wg sync.WaitGroup
+	var ok, rejected atomic.Int32
+	for i := 0; i < 2*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := http.Get("http://" + ln.Addr().String())
+			if err != nil {
+				t.Errorf("GET request failed: %v", err)
+				return
+			}
+			rsp.Body.Close()
+			switch rsp.StatusCode {
+			case http.StatusOK:
+				ok.Add(1)
+			case http.StatusServiceUnavailable:
+				rejected.Add(1)
+			}
+		}()
+	}
+
+	// requests over the limit are rejected while the ones within the limit are still running
+	for i := 0; rejected.Load() != limit; i++ {
+		if i == 100 {
+			t.Fatalf("expected %d requests to be rejected, got %d", limit, rejected.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-srvErr:
+		t.Fatalf("server stopped before requests completed: %v", err)
+	default:
+	}
+
+	close(release)
+	wg.Wait()
+	if n := ok.Load(); n != limit {
+		t.Errorf("expected %d successful requests, got %d", limit, n)
+	}
+	if n := served.Load(); n != limit {
+		t.Errorf("expected handler to serve %d requests, got %d", limit, n)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, ErrMaxRequestsReached)
+	}
+}
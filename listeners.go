@@ -0,0 +1,86 @@
+package httpsrv
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+)
+
+/*
+ListenerSpec is additional listener served by the server, see [Listeners].
+*/
+type ListenerSpec struct {
+	Listener net.Listener
+	// TLS config of the listener, nil means plaintext. When NextProtos is not set
+	// HTTP/2 and HTTP/1.1 are offered.
+	TLSConfig *tls.Config
+}
+
+/*
+Listeners makes the server to serve additional listeners, each with it's own TLS configuration,
+alongside the main one (the [Listener] param or the listener bound to the server's Addr). This
+allows ie to require client certificates on the internal port while serving public TLS on the
+external one:
+
+	httpsrv.Run(ctx, srv,
+		httpsrv.Listeners(
+			httpsrv.ListenerSpec{Listener: internal, TLSConfig: mTLSConfig},
+			httpsrv.ListenerSpec{Listener: metrics}, // plaintext
+		),
+	)
+
+All the listeners share the handler and the lifecycle of the server: they are served once the server
+is ready and closed when it shuts down, when serving any of them fails the server is closed. Params
+wrapping the listener (ie [AcceptControl], [ThrottleBandwidth], [AutoDetectTLS]) and the TLS config of
+the server apply to the main listener only.
+*/
+func Listeners(specs ...ListenerSpec) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.listeners = append(cfg.listeners, specs...) }}
+}
+
+var errNilListenerSpec = errors.New("Listeners parameter: ListenerSpec without Listener")
+
+// listener returns the listener of the spec, wrapped into TLS listener when TLSConfig is set.
+func (ls ListenerSpec) listener() net.Listener {
+	if ls.TLSConfig == nil {
+		return ls.Listener
+	}
+	tc := ls.TLSConfig
+	if len(tc.NextProtos) == 0 {
+		tc = tc.Clone()
+		tc.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return tls.NewListener(ls.Listener, tc)
+}
+
+/*
+serveListeners returns serve func which serves the additional listeners alongside the
+main listener served by serve. When serving any of the listeners fails the server is
+closed (so that all the serve calls return) and the first error is returned.
+*/
+func (cfg *serverConf) serveListeners(serve func() error) func() error {
+	return func() error {
+		errs := make(chan error, len(cfg.listeners))
+		for _, ls := range cfg.listeners {
+			go func(l net.Listener) {
+				err := cfg.srv.Serve(l)
+				if err != http.ErrServerClosed {
+					cfg.srv.Close()
+				}
+				errs <- checkListenerErr(err)
+			}(ls.listener())
+		}
+
+		err := serve()
+		if err != http.ErrServerClosed {
+			cfg.srv.Close()
+		}
+		for range cfg.listeners {
+			if e := <-errs; err == http.ErrServerClosed {
+				err = e
+			}
+		}
+		return err
+	}
+}
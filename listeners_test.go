@@ -0,0 +1,118 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Listeners(t *testing.T) {
+	t.Parallel()
+
+	listen := func(t *testing.T) net.Listener {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		return ln
+	}
+
+	t.Run("plaintext and TLS listener", func(t *testing.T) {
+		cert := testCertificate(t)
+		mainLn, plain, secure := listen(t), listen(t), listen(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{
+					Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						fmt.Fprintf(w, "%s TLS=%t", r.Proto, r.TLS != nil)
+					}),
+					ErrorLog: log.New(io.Discard, "", 0),
+				},
+				Listener(mainLn),
+				Listeners(
+					ListenerSpec{Listener: plain},
+					ListenerSpec{Listener: secure, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}},
+				),
+			)
+		}()
+
+		roots := x509.NewCertPool()
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("parsing certificate: %v", err)
+		}
+		roots.AddCert(leaf)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}}
+
+		get := func(url string) (int, string) {
+			t.Helper()
+			rsp, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("request to %s failed: %v", url, err)
+			}
+			defer rsp.Body.Close()
+			b, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatalf("reading response body: %v", err)
+			}
+			return rsp.StatusCode, string(b)
+		}
+
+		if _, body := get("http://" + mainLn.Addr().String()); body != "HTTP/1.1 TLS=false" {
+			t.Errorf("main listener: unexpected response %q", body)
+		}
+		if _, body := get("http://" + plain.Addr().String()); body != "HTTP/1.1 TLS=false" {
+			t.Errorf("plaintext listener: unexpected response %q", body)
+		}
+		if _, body := get("https://" + secure.Addr().String()); body != "HTTP/2.0 TLS=true" {
+			t.Errorf("TLS listener: unexpected response %q", body)
+		}
+		if code, _ := get("http://" + secure.Addr().String()); code != http.StatusBadRequest {
+			t.Errorf("plaintext request to TLS listener: expected status 400, got %d", code)
+		}
+
+		cancel()
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		for _, ln := range []net.Listener{plain, secure} {
+			if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+				c.Close()
+				t.Errorf("expected listener %s to be closed", ln.Addr())
+			}
+		}
+	})
+
+	t.Run("listener fails", func(t *testing.T) {
+		mainLn, extra := listen(t), listen(t)
+		extra.Close()
+
+		err := Run(context.Background(), &http.Server{Handler: http.NotFoundHandler()},
+			Listener(mainLn),
+			Listeners(ListenerSpec{Listener: extra}),
+		)
+		expectError(t, err, net.ErrClosed)
+	})
+
+	t.Run("spec without listener", func(t *testing.T) {
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			Listeners(ListenerSpec{}),
+		)
+		expectError(t, err, errNilListenerSpec)
+	})
+}
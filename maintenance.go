@@ -0,0 +1,107 @@
+package httpsrv
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ScheduledMaintenance makes the server to route requests to the handler during the maintenance
+window from start to end, flipping back to serving them normally after the window has passed.
+This allows pre-announced maintenance without redeploying the service. Requests to the
+[ProbePaths] and to the endpoints served by the params (ie [ReadinessEndpoint]) are not affected.
+
+When handler is nil requests get response with status 503 (Service Unavailable) and Retry-After
+header telling the client when the window ends.
+
+ScheduledMaintenance and [MaintenanceMode] can't be combined, the param given later wins.
+*/
+func ScheduledMaintenance(start, end time.Time, handler http.Handler) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		m := &maintenance{name: "scheduled-maintenance", start: start, end: end, handler: handler}
+		if m.handler == nil {
+			m.handler = http.HandlerFunc(m.unavailable)
+		}
+		cfg.maintenance = m
+		cfg.watchers = append(cfg.watchers, m.run)
+	}}
+}
+
+/*
+MaintenanceMode returns param which allows to switch the server into maintenance mode at runtime
+and the controller to toggle it, ie for planned maintenance of the backing services without
+restarting the server. While in maintenance mode requests are routed to the handler instead of
+the server's handler, requests to the [ProbePaths] and to the endpoints served by the params are
+not affected. Server starts with the maintenance mode off.
+
+When handler is nil requests get response with status 503 (Service Unavailable).
+
+MaintenanceMode and [ScheduledMaintenance] can't be combined, the param given later wins.
+*/
+func MaintenanceMode(handler http.Handler) (ServerParam, *MaintenanceController) {
+	m := &maintenance{name: "maintenance-mode", handler: handler}
+	if m.handler == nil {
+		m.handler = http.HandlerFunc(m.unavailable)
+	}
+	return serverParam{func(cfg *serverConf) { cfg.maintenance = m }}, &MaintenanceController{m: m}
+}
+
+/*
+MaintenanceController switches the maintenance mode of the server on and off, see [MaintenanceMode].
+*/
+type MaintenanceController struct {
+	m *maintenance
+}
+
+// SetMaintenance switches the maintenance mode on (true) or off (false).
+func (c *MaintenanceController) SetMaintenance(on bool) { c.m.active.Store(on) }
+
+// InMaintenance reports whether the maintenance mode is on.
+func (c *MaintenanceController) InMaintenance() bool { return c.m.active.Load() }
+
+type maintenance struct {
+	name       string // name of the middleware reported in ReadyInfo
+	start, end time.Time
+	handler    http.Handler
+	active     atomic.Bool
+}
+
+// run switches the maintenance mode on and off according to the window.
+func (m *maintenance) run(ctx context.Context, _ context.CancelCauseFunc) {
+	if !time.Now().Before(m.end) {
+		return
+	}
+	for _, t := range []struct {
+		at     time.Time
+		active bool
+	}{{m.start, true}, {m.end, false}} {
+		timer := time.NewTimer(time.Until(t.at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			m.active.Store(t.active)
+		}
+	}
+}
+
+func (m *maintenance) unavailable(w http.ResponseWriter, r *http.Request) {
+	if d := time.Until(m.end); d > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+	}
+	http.Error(w, "under maintenance", http.StatusServiceUnavailable)
+}
+
+func (m *maintenance) wrap(cfg *serverConf, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.active.Load() && !cfg.isProbe(r) {
+			m.handler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
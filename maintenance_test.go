@@ -0,0 +1,129 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_ScheduledMaintenance(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	start := time.Now().Add(200 * time.Millisecond)
+	end := start.Add(300 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			ScheduledMaintenance(start, end, nil),
+			ProbePaths("/healthz"),
+		)
+	}()
+
+	c := http.Client{Timeout: time.Second}
+	get := func(path string) *http.Response {
+		rsp, err := c.Get("http://" + ln.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	// before the window
+	if rsp := get("/"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 before maintenance window, got %s", rsp.Status)
+	}
+
+	time.Sleep(time.Until(start) + 50*time.Millisecond)
+	rsp := get("/")
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 during maintenance window, got %s", rsp.Status)
+	}
+	if ra := rsp.Header.Get("Retry-After"); ra != "1" {
+		t.Errorf("expected Retry-After to be 1 second, got %q", ra)
+	}
+	if rsp := get("/healthz"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected probe to be served during maintenance window, got %s", rsp.Status)
+	}
+
+	time.Sleep(time.Until(end) + 50*time.Millisecond)
+	if rsp := get("/"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 after maintenance window, got %s", rsp.Status)
+	}
+
+	cancel()
+	expectError(t, <-srvErr, context.Canceled)
+}
+
+func Test_MaintenanceMode(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	maintenanceMode, ctl := MaintenanceMode(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	ready := make(chan ReadyInfo, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			maintenanceMode,
+			ProbePaths("/healthz"),
+			OnReady(func(info ReadyInfo) { ready <- info }),
+		)
+	}()
+	if info := <-ready; len(info.Middleware) != 1 || info.Middleware[0] != "maintenance-mode" {
+		t.Errorf("expected maintenance-mode middleware, got %v", info.Middleware)
+	}
+
+	c := http.Client{Timeout: time.Second}
+	get := func(path string) *http.Response {
+		rsp, err := c.Get("http://" + ln.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	if rsp := get("/"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 before maintenance mode, got %s", rsp.Status)
+	}
+
+	ctl.SetMaintenance(true)
+	if !ctl.InMaintenance() {
+		t.Error("expected maintenance mode to be on")
+	}
+	if rsp := get("/"); rsp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected request to be routed to maintenance handler, got %s", rsp.Status)
+	}
+	if rsp := get("/healthz"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected probe to be served in maintenance mode, got %s", rsp.Status)
+	}
+
+	ctl.SetMaintenance(false)
+	if rsp := get("/"); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 after maintenance mode, got %s", rsp.Status)
+	}
+
+	cancel()
+	expectError(t, <-srvErr, context.Canceled)
+}
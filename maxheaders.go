@@ -0,0 +1,31 @@
+package httpsrv
+
+import "net/http"
+
+/*
+MaxHeaders makes the server to reject requests with more than count header fields with status 431
+(Request Header Fields Too Large). This complements the [http.Server.MaxHeaderBytes] limit against
+"header bombs" which stay under the byte limit by sending many tiny headers. Repeated fields with
+the same name are counted individually. Rejections are reported to the [OnReject] hook with the
+[RejectMaxHeaders] reason and the connection is closed after the response. Zero or negative
+count disables the check.
+*/
+func MaxHeaders(count int) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.maxHeaders = count }}
+}
+
+func (cfg *serverConf) limitHeaderCount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		for _, v := range r.Header {
+			n += len(v)
+		}
+		if n > cfg.maxHeaders {
+			cfg.rejected(r, RejectMaxHeaders)
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_MaxHeaders(t *testing.T) {
+	t.Parallel()
+
+	var reasons []RejectReason
+	cfg := &serverConf{}
+	MaxHeaders(3).apply(cfg)
+	OnReject(func(reason RejectReason, remote net.Addr) { reasons = append(reasons, reason) }).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Add(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("A", "1", "B", "2", "C", "3"); rec.Code != http.StatusNoContent {
+		t.Errorf("expected request with 3 headers to be served, got status %d", rec.Code)
+	}
+	if len(reasons) != 0 {
+		t.Errorf("expected no rejections, got %v", reasons)
+	}
+
+	var headers []string
+	for i := 0; i < 4; i++ {
+		headers = append(headers, fmt.Sprintf("X-H%d", i), "v")
+	}
+	rec := serve(headers...)
+	if rec.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected status 431 for request with 4 headers, got %d", rec.Code)
+	}
+	if c := rec.Header().Get("Connection"); c != "close" {
+		t.Errorf("expected connection to be closed, got Connection header %q", c)
+	}
+
+	// repeated fields are counted individually
+	if rec := serve("A", "1", "A", "2", "A", "3", "A", "4"); rec.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected status 431 for request with 4 repeated fields, got %d", rec.Code)
+	}
+	if len(reasons) != 2 || reasons[0] != RejectMaxHeaders || reasons[1] != RejectMaxHeaders {
+		t.Errorf("expected two max headers rejections, got %v", reasons)
+	}
+}
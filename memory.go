@@ -0,0 +1,60 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"time"
+)
+
+// ErrMemoryPressure is the reason server was shut down when it's heap size exceeded
+// the threshold set by [ShutdownOnMemoryPressure] parameter.
+var ErrMemoryPressure = errors.New("heap size exceeded memory pressure threshold")
+
+/*
+ShutdownOnMemoryPressure instructs the server to shut down gracefully when the heap size
+(as reported by [runtime.ReadMemStats] HeapAlloc field) exceeds thresholdBytes. Memory usage
+is sampled at given interval, when interval is smaller than or equal to zero it defaults
+to ten seconds. Keep in mind that reading memory statistics stops the world so interval
+shouldn't be too short.
+
+This is a self-protection feature for leaky workloads - [Run] returns [ErrMemoryPressure]
+and orchestrator can start a fresh instance.
+*/
+func ShutdownOnMemoryPressure(thresholdBytes uint64, interval time.Duration) ServerParam {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return serverParam{func(cfg *serverConf) {
+		cfg.watchers = append(cfg.watchers, func(ctx context.Context, stop context.CancelCauseFunc) {
+			read := cfg.readMemStats
+			if read == nil {
+				read = runtime.ReadMemStats
+			}
+			watchMemory(ctx, stop, read, thresholdBytes, interval)
+		})
+	}}
+}
+
+// withMemStats sets the func used to sample memory statistics, meant to be used by tests.
+func withMemStats(read func(*runtime.MemStats)) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.readMemStats = read }}
+}
+
+func watchMemory(ctx context.Context, stop context.CancelCauseFunc, readMemStats func(*runtime.MemStats), threshold uint64, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var ms runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if readMemStats(&ms); ms.HeapAlloc > threshold {
+				stop(ErrMemoryPressure)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownOnMemoryPressure(t *testing.T) {
+	t.Parallel()
+
+	// synthetic allocator - the test controls what the heap size is
+	var heap atomic.Uint64
+	memStats := withMemStats(func(ms *runtime.MemStats) { ms.HeapAlloc = heap.Load() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	heap.Store(1000)
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx,
+			&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			ShutdownOnMemoryPressure(2000, 10*time.Millisecond),
+			memStats,
+			ShutdownTimeout(time.Second),
+		)
+	}()
+
+	// heap is below threshold so server should keep running
+	select {
+	case err := <-done:
+		t.Fatalf("server exited before threshold was crossed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	heap.Store(2001)
+	select {
+	case <-time.After(time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-done:
+		expectError(t, err, ErrMemoryPressure)
+		if errors.Is(err, context.Canceled) {
+			t.Errorf("unexpectedly the error contains context.Canceled: %v", err)
+		}
+	}
+}
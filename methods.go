@@ -0,0 +1,82 @@
+package httpsrv
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+MethodNotAllowed makes the server to respond with status 405 (Method Not Allowed) and the Allow
+header (instead of 404) when the handler responds with status 404 (Not Found) to request for the
+path known to the router, but with wrong method. As the server has no knowledge of the routes
+the allowed func must report the methods allowed for the request's path, empty result means the
+path is unknown and the handler's 404 response is sent.
+
+The allowed func is only called for the requests the handler responded to with 404.
+*/
+func MethodNotAllowed(allowed func(*http.Request) []string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.allowedMethods = allowed }}
+}
+
+func (cfg *serverConf) methodNotAllowed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&notAllowedWriter{ResponseWriter: w, r: r, allowed: cfg.allowedMethods}, r)
+	})
+}
+
+/*
+notAllowedWriter replaces 404 response with 405 when the allowed func reports methods
+for the request, the body written by the handler is discarded then.
+*/
+type notAllowedWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	allowed     func(*http.Request) []string
+	wroteHeader bool
+	discard     bool // 405 response has been sent
+}
+
+func (w *notAllowedWriter) WriteHeader(code int) {
+	if w.discard {
+		return
+	}
+	if !w.wroteHeader && code == http.StatusNotFound {
+		if methods := w.allowed(w.r); len(methods) != 0 {
+			w.wroteHeader, w.discard = true, true
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w.ResponseWriter, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+	}
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notAllowedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *notAllowedWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *notAllowedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *notAllowedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,87 @@
+package httpsrv
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_MethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	// router which only knows GET /items
+	routes := map[string][]string{"/items": {"GET", "HEAD"}}
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			return
+		case "/tunnel":
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\ntunnel")
+			buf.Flush()
+			return
+		}
+		if r.URL.Path != "/items" || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("items"))
+	})
+
+	cfg := &serverConf{}
+	MethodNotAllowed(func(r *http.Request) []string { return routes[r.URL.Path] }).apply(cfg)
+	h := cfg.wrapHandler(router)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := serve("GET", "/items"); rec.Code != http.StatusOK || rec.Body.String() != "items" {
+		t.Errorf("unexpected response %d: %q", rec.Code, rec.Body.String())
+	}
+
+	rec := serve("DELETE", "/items")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for known path with wrong method, got %d", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("unexpected Allow header %q", allow)
+	}
+	if body := rec.Body.String(); body != "Method Not Allowed\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	rec = serve("GET", "/unknown")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Allow") != "" {
+		t.Errorf("expected 404 without Allow header for unknown path, got %d %v", rec.Code, rec.Header())
+	}
+	if body := rec.Body.String(); body != "404 page not found\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	// wrapped writer keeps the optional interfaces of the ResponseWriter
+	if rec := serve("GET", "/stream"); rec.Code != http.StatusOK || !rec.Flushed || rec.Body.String() != "chunk" {
+		t.Errorf("expected flushed response, got %d %t %q", rec.Code, rec.Flushed, rec.Body.String())
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	rsp, err := http.Get(srv.URL + "/tunnel")
+	if err != nil {
+		t.Fatalf("request to hijacking handler failed: %v", err)
+	}
+	defer rsp.Body.Close()
+	if b, _ := io.ReadAll(rsp.Body); rsp.StatusCode != http.StatusOK || string(b) != "tunnel" {
+		t.Errorf("unexpected response from hijacked connection %d: %q", rsp.StatusCode, b)
+	}
+}
@@ -0,0 +1,59 @@
+package httpsrv
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+MetricsHandler returns param which collects lifecycle metrics of the server and handler which
+serves them in the Prometheus text exposition format:
+  - httpsrv_uptime_seconds: seconds since the server started serving (zero before that);
+  - httpsrv_requests_in_flight: number of requests currently being served;
+  - httpsrv_requests_total: total number of requests served;
+  - httpsrv_shutdowns_total: number of times the shutdown of the server has begun.
+
+The handler is meant to be mounted on the admin server (ie separate [Run] on internal port).
+When the param is passed to several Run calls (ie server is restarted) the counters accumulate.
+*/
+func MetricsHandler() (ServerParam, http.Handler) {
+	m := &lifecycleMetrics{}
+	return serverParam{func(cfg *serverConf) {
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) { m.started.Store(time.Now().UnixNano()) })
+		cfg.onShutdown = append(cfg.onShutdown, func(time.Duration) { m.shutdowns.Add(1) })
+		cfg.wrappers = append(cfg.wrappers, handlerWrapper{name: "metrics", wrap: m.track})
+	}}, m
+}
+
+type lifecycleMetrics struct {
+	started   atomic.Int64 // unix nano time the server started serving
+	inFlight  atomic.Int64
+	total     atomic.Uint64
+	shutdowns atomic.Uint64
+}
+
+func (m *lifecycleMetrics) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer func() {
+			m.inFlight.Add(-1)
+			m.total.Add(1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *lifecycleMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var uptime float64
+	if start := m.started.Load(); start != 0 {
+		uptime = time.Since(time.Unix(0, start)).Seconds()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP httpsrv_uptime_seconds Seconds since the server started serving.\n# TYPE httpsrv_uptime_seconds gauge\nhttpsrv_uptime_seconds %g\n", uptime)
+	fmt.Fprintf(w, "# HELP httpsrv_requests_in_flight Number of requests currently being served.\n# TYPE httpsrv_requests_in_flight gauge\nhttpsrv_requests_in_flight %d\n", m.inFlight.Load())
+	fmt.Fprintf(w, "# HELP httpsrv_requests_total Total number of requests served.\n# TYPE httpsrv_requests_total counter\nhttpsrv_requests_total %d\n", m.total.Load())
+	fmt.Fprintf(w, "# HELP httpsrv_shutdowns_total Number of times the shutdown of the server has begun.\n# TYPE httpsrv_shutdowns_total counter\nhttpsrv_shutdowns_total %d\n", m.shutdowns.Load())
+}
@@ -0,0 +1,104 @@
+package httpsrv
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	param, metrics := MetricsHandler()
+	entered, release := make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/block" {
+					close(entered)
+					<-release
+				}
+			})},
+			Listener(ln),
+			ShutdownTimeout(time.Second),
+			param,
+		)
+	}()
+
+	scrape := func(t *testing.T) map[string]string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("unexpected Content-Type %q", ct)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		line := regexp.MustCompile(`^(# (HELP|TYPE) httpsrv_\w+ .+|(httpsrv_\w+) (\S+))$`)
+		values := make(map[string]string)
+		for _, s := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+			m := line.FindStringSubmatch(s)
+			if m == nil {
+				t.Errorf("invalid line in the exposition: %q", s)
+				continue
+			}
+			if m[3] != "" {
+				values[m[3]] = m[4]
+			}
+		}
+		return values
+	}
+
+	for i := 0; i < 2; i++ {
+		rsp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+	}
+	go http.Get("http://" + ln.Addr().String() + "/block")
+	<-entered
+
+	values := scrape(t)
+	for name, exp := range map[string]string{
+		"httpsrv_requests_in_flight": "1",
+		"httpsrv_requests_total":     "2",
+		"httpsrv_shutdowns_total":    "0",
+	} {
+		if values[name] != exp {
+			t.Errorf("expected %s to be %s, got %q", name, exp, values[name])
+		}
+	}
+	if v, ok := values["httpsrv_uptime_seconds"]; !ok || v == "0" {
+		t.Errorf("expected uptime to be reported, got %q", v)
+	}
+
+	cancel()
+	close(release)
+	if err := <-srvErr; err == nil {
+		t.Error("expected Run to return error")
+	}
+	values = scrape(t)
+	for name, exp := range map[string]string{
+		"httpsrv_requests_in_flight": "0",
+		"httpsrv_requests_total":     "3",
+		"httpsrv_shutdowns_total":    "1",
+	} {
+		if values[name] != exp {
+			t.Errorf("expected %s to be %s, got %q", name, exp, values[name])
+		}
+	}
+}
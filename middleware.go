@@ -0,0 +1,276 @@
+package httpsrv
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"runtime/debug"
+)
+
+/*
+WithRecovery wraps handler h so that unhandled panic escaping it is recovered and the recovered
+value is passed to onPanic. When the handler hasn't written anything yet the client gets response
+with status 500 (Internal Server Error), otherwise (headers have already been sent) the response
+is aborted by panicking with [http.ErrAbortHandler]. Panic with http.ErrAbortHandler (also when
+wrapped inside another error) is recovered but onPanic is not called as it is the standard way
+to abort a handler.
+
+This is the wrapper used by the [ShutdownOnPanic] parameter, exposed so that it can be used
+with handlers which are not served by [Run].
+*/
+func WithRecovery(h http.Handler, onPanic func(v any)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &ensureWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				return
+			}
+			onPanic(v)
+			if ew.written {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		h.ServeHTTP(ew, r)
+	})
+}
+
+/*
+logPanics wraps the handler so that unhandled panic is reported to the panicLogger. When
+the server is not shut down on panic the panic is replaced by [http.ErrAbortHandler] so
+that http.Server aborts the connection without logging it again.
+*/
+func (cfg *serverConf) logPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+				cfg.panicLogger(v, debug.Stack(), r)
+				if !cfg.dieOnPanic {
+					v = http.ErrAbortHandler
+				}
+			}
+			panic(v)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+SplitByTLS returns handler which serves requests received over TLS connection by secure and the
+other requests by plain handler. This allows to share the same handler between servers accepting
+plaintext (internal) and TLS (external) connections while exposing some endpoints (ie admin) only
+on the internal plaintext listener:
+
+	h := httpsrv.SplitByTLS(publicMux, adminMux)
+	g.Go(func() error { return httpsrv.Run(ctx, &http.Server{Addr: ":8443", Handler: h}, httpsrv.TLS(cert, key)) })
+	g.Go(func() error { return httpsrv.Run(ctx, &http.Server{Addr: "127.0.0.1:8080", Handler: h}) })
+
+The connection type is detected using the [http.Request.TLS] field.
+*/
+func SplitByTLS(secure, plain http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			secure.ServeHTTP(w, r)
+		} else {
+			plain.ServeHTTP(w, r)
+		}
+	})
+}
+
+/*
+HandlerByHeader returns handler which routes requests to the handler registered for the value of
+the request header, requests without the header (or with unknown value) are served by def. This
+allows in-process canarying or blue/green testing of handler versions without separate deployment:
+
+	h := httpsrv.HandlerByHeader("X-Version", map[string]http.Handler{"canary": canaryMux}, stableMux)
+
+The header name is added to the "Vary" header of the responses so that caches do not mix up
+responses of the different versions. The handlers map is copied.
+*/
+func HandlerByHeader(header string, handlers map[string]http.Handler, def http.Handler) http.Handler {
+	header = http.CanonicalHeaderKey(header)
+	handlers = maps.Clone(handlers)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", header)
+		if h, ok := handlers[r.Header.Get(header)]; ok {
+			h.ServeHTTP(w, r)
+		} else {
+			def.ServeHTTP(w, r)
+		}
+	})
+}
+
+/*
+Use adds middleware which wraps the server's handler, the first middleware is the outermost
+one. Middleware is installed inside the wrappers enabled by other params (ie the requests
+rejected by [MaxConcurrentRequests] do not reach it). Parameter can be used multiple times,
+middleware added by the later call is wrapped by the middleware added by the earlier call.
+*/
+func Use(mw ...func(http.Handler) http.Handler) ServerParam {
+	return UseFor(nil, mw...)
+}
+
+/*
+UseFor is like [Use] but the middleware is applied only to the requests for which the match
+func returns true, other requests bypass it. The match func is evaluated for every request so
+it should be cheap, ie check the path prefix or method:
+
+	httpsrv.UseFor(func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin/") }, auth)
+
+When match is nil the middleware is applied to all requests.
+*/
+func UseFor(match func(*http.Request) bool, mw ...func(http.Handler) http.Handler) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cfg.use = append(cfg.use, func(next http.Handler) http.Handler {
+			h := next
+			for i := len(mw) - 1; i >= 0; i-- {
+				h = mw[i](h)
+			}
+			if match == nil {
+				return h
+			}
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if match(r) {
+					h.ServeHTTP(w, r)
+				} else {
+					next.ServeHTTP(w, r)
+				}
+			})
+		})
+	}}
+}
+
+/*
+wrapHandler wraps the handler with the wrappers enabled by params. The order of the
+wrappers is fixed, it doesn't depend on the order of params. Names of the installed
+wrappers are recorded in cfg.middleware (innermost first).
+*/
+func (cfg *serverConf) wrapHandler(h http.Handler) http.Handler {
+	for i := len(cfg.use) - 1; i >= 0; i-- {
+		h = cfg.use[i](h)
+	}
+	if len(cfg.use) != 0 {
+		cfg.middleware = append(cfg.middleware, "user-middleware")
+	}
+	if cfg.ensureStatus != 0 {
+		h = cfg.ensureResponse(h)
+		cfg.middleware = append(cfg.middleware, "ensure-response")
+	}
+	if cfg.allowedMethods != nil {
+		h = cfg.methodNotAllowed(h)
+		cfg.middleware = append(cfg.middleware, "method-not-allowed")
+	}
+	if cfg.notFound != nil {
+		h = cfg.replaceNotFound(h)
+		cfg.middleware = append(cfg.middleware, "not-found")
+	}
+	if cfg.bodyLog != nil {
+		h = cfg.bodyLog.wrap(h)
+		cfg.middleware = append(cfg.middleware, "body-logger")
+	}
+	if cfg.panicLogger != nil {
+		h = cfg.logPanics(h)
+		cfg.middleware = append(cfg.middleware, "panic-logger")
+	}
+	if cfg.serverTiming {
+		h = serverTiming(h)
+		cfg.middleware = append(cfg.middleware, "server-timing")
+	}
+	if cfg.idempotency != nil {
+		h = cfg.idempotency.wrap(h)
+		cfg.middleware = append(cfg.middleware, "idempotency-keys")
+	}
+	if cfg.maxRequests != nil {
+		h = cfg.maxRequests.wrap(cfg, h)
+		cfg.middleware = append(cfg.middleware, "max-requests")
+	}
+	if cfg.maxConcurrent > 0 {
+		h = newRequestLimiter(cfg, h)
+		cfg.middleware = append(cfg.middleware, "concurrency-limit")
+	}
+	if cfg.hostPolicy != nil {
+		h = cfg.enforceHostPolicy(h)
+		cfg.middleware = append(cfg.middleware, "host-policy")
+	}
+	if cfg.maxHeaders > 0 {
+		h = cfg.limitHeaderCount(h)
+		cfg.middleware = append(cfg.middleware, "max-headers")
+	}
+	if cfg.maintenance != nil {
+		h = cfg.maintenance.wrap(cfg, h)
+		cfg.middleware = append(cfg.middleware, cfg.maintenance.name)
+	}
+	if cfg.readinessPath != "" {
+		h = cfg.readinessEndpoint(h)
+		cfg.middleware = append(cfg.middleware, "readiness-endpoint")
+	}
+	if cfg.livenessPath != "" {
+		h = cfg.livenessEndpoint(h)
+		cfg.middleware = append(cfg.middleware, "liveness-endpoint")
+	}
+	if cfg.versionPath != "" {
+		h = cfg.versionEndpoint(h)
+		cfg.middleware = append(cfg.middleware, "version-endpoint")
+	}
+	if cfg.warmup != nil && cfg.warmup.reject {
+		h = cfg.warmup.wrap(cfg, h)
+		cfg.middleware = append(cfg.middleware, "warmup-gate")
+	}
+	if cfg.readyGate != nil {
+		h = cfg.readyGate.wrap(cfg, h)
+		cfg.middleware = append(cfg.middleware, "ready-gate")
+	}
+	if cfg.isLeader != nil {
+		h = cfg.leaderGate(h)
+		cfg.middleware = append(cfg.middleware, "leader-gate")
+	}
+	if cfg.closeConns {
+		h = cfg.closeConnectionsOnShutdown(h)
+		cfg.middleware = append(cfg.middleware, "close-on-shutdown")
+	}
+	if cfg.shutdownStatus != 0 {
+		h = cfg.rejectDuringShutdown(h)
+		cfg.middleware = append(cfg.middleware, "reject-during-shutdown")
+	}
+	if cfg.uploads != nil {
+		h = cfg.uploads.wrap(h)
+		cfg.middleware = append(cfg.middleware, "upload-drain")
+	}
+	if cfg.adaptive != nil {
+		h = cfg.adaptive.wrap(h)
+		cfg.middleware = append(cfg.middleware, "adaptive-shutdown")
+	}
+	if cfg.reportInFlight {
+		h, cfg.inFlightReqs = WithInFlight(h)
+		cfg.middleware = append(cfg.middleware, "in-flight-report")
+	}
+	if cfg.shutdownEvents != nil {
+		h = cfg.trackInFlight(h)
+		cfg.middleware = append(cfg.middleware, "in-flight-tracker")
+	}
+	if len(cfg.defaultHeaders) != 0 {
+		h = cfg.addDefaultHeaders(h)
+		cfg.middleware = append(cfg.middleware, "default-headers")
+	}
+	for _, w := range cfg.wrappers {
+		h = w.wrap(h)
+		cfg.middleware = append(cfg.middleware, w.name)
+	}
+	return h
+}
+
+// handlerWrapper is handler wrapper installed by optional param (ie WithCompanion).
+type handlerWrapper struct {
+	name string
+	wrap func(http.Handler) http.Handler
+}
@@ -0,0 +1,267 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_WithRecovery(t *testing.T) {
+	t.Parallel()
+
+	// serve returns the values passed to onPanic, the response and the value
+	// of the panic escaping the wrapper (ie http.ErrAbortHandler)
+	serve := func(t *testing.T, h http.HandlerFunc) (recovered []any, rec *httptest.ResponseRecorder, aborted any) {
+		t.Helper()
+		rec = httptest.NewRecorder()
+		rh := WithRecovery(h, func(v any) { recovered = append(recovered, v) })
+		defer func() { aborted = recover() }()
+		rh.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return recovered, rec, nil
+	}
+
+	t.Run("no panic", func(t *testing.T) {
+		r, rec, aborted := serve(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+		if len(r) != 0 {
+			t.Errorf("unexpectedly onPanic was called: %v", r)
+		}
+		if rec.Code != http.StatusNoContent || aborted != nil {
+			t.Errorf("unexpected response status %d (aborted: %v)", rec.Code, aborted)
+		}
+	})
+
+	t.Run("panic before response is written", func(t *testing.T) {
+		r, rec, aborted := serve(t, func(w http.ResponseWriter, r *http.Request) { panic("foobar") })
+		if len(r) != 1 || r[0] != "foobar" {
+			t.Errorf("unexpected recovered values: %v", r)
+		}
+		if rec.Code != http.StatusInternalServerError || aborted != nil {
+			t.Errorf("expected status 500, got %d (aborted: %v)", rec.Code, aborted)
+		}
+	})
+
+	t.Run("panic after headers were sent", func(t *testing.T) {
+		r, rec, aborted := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("foobar")
+		})
+		if len(r) != 1 || r[0] != "foobar" {
+			t.Errorf("unexpected recovered values: %v", r)
+		}
+		if aborted != http.ErrAbortHandler {
+			t.Errorf("expected response to be aborted, got %v", aborted)
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+			t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("http.ErrAbortHandler is not reported", func(t *testing.T) {
+		for _, v := range []error{http.ErrAbortHandler, fmt.Errorf("wrapped: %w", http.ErrAbortHandler)} {
+			if r, _, aborted := serve(t, func(w http.ResponseWriter, r *http.Request) { panic(v) }); len(r) != 0 || aborted != nil {
+				t.Errorf("unexpectedly onPanic was called: %v (aborted: %v)", r, aborted)
+			}
+		}
+	})
+}
+
+func Test_UseFor(t *testing.T) {
+	t.Parallel()
+
+	// middleware which records it's name into the response header
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	cfg := &serverConf{}
+	Use(mark("a")).apply(cfg)
+	UseFor(func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin/") }, mark("b"), mark("c")).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(path string) []string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Header()["X-Middleware"]
+	}
+
+	t.Run("path matches", func(t *testing.T) {
+		if got := serve("/admin/users"); strings.Join(got, ",") != "a,b,c" {
+			t.Errorf("unexpected middleware chain %v", got)
+		}
+	})
+
+	t.Run("path doesn't match", func(t *testing.T) {
+		if got := serve("/users"); strings.Join(got, ",") != "a" {
+			t.Errorf("unexpected middleware chain %v", got)
+		}
+	})
+}
+
+func Test_PanicLogger(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	type panicInfo struct {
+		value               any
+		stack               string
+		method, path, reqID string
+	}
+	panics := make(chan panicInfo, 1)
+	logger := func(v any, stack []byte, r *http.Request) {
+		panics <- panicInfo{value: v, stack: string(stack), method: r.Method, path: r.URL.Path, reqID: r.Header.Get("X-Request-ID")}
+	}
+
+	logBuf := &strings.Builder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/panic", panickingHandler)
+		mux.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) })
+		srvErr <- Run(ctx,
+			&http.Server{Handler: mux, ErrorLog: log.New(logBuf, "", 0)},
+			Listener(ln),
+			PanicLogger(logger),
+		)
+	}()
+
+	req, err := http.NewRequest("POST", "http://"+ln.Addr().String()+"/panic", nil)
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	req.Header.Set("X-Request-ID", "req-42")
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Error("expected connection to be aborted")
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/abort"); err == nil {
+		t.Error("expected connection to be aborted")
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("panic logger wasn't called")
+	case p := <-panics:
+		if p.value != "oh-my-foobar" {
+			t.Errorf("unexpected panic value %v", p.value)
+		}
+		if p.method != "POST" || p.path != "/panic" || p.reqID != "req-42" {
+			t.Errorf("unexpected request info: %s %s %s", p.method, p.path, p.reqID)
+		}
+		if !strings.Contains(p.stack, "panickingHandler") {
+			t.Errorf("expected stack to contain the panicking func:\n%s", p.stack)
+		}
+	}
+	// ErrAbortHandler is not reported
+	select {
+	case p := <-panics:
+		t.Errorf("unexpected panic reported: %v", p.value)
+	default:
+	}
+
+	cancel()
+	expectError(t, <-srvErr, context.Canceled)
+	if s := logBuf.String(); s != "" {
+		t.Errorf("expected panic not to be logged by the server, got %q", s)
+	}
+}
+
+func panickingHandler(w http.ResponseWriter, r *http.Request) { panic("oh-my-foobar") }
+
+func Test_SplitByTLS(t *testing.T) {
+	t.Parallel()
+
+	handler := SplitByTLS(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "secure") }),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "plain") }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvErr := make(chan error, 2)
+	startServer := func(srv *http.Server) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() { srvErr <- Run(ctx, srv, Listener(ln)) }()
+		return ln.Addr().String()
+	}
+	plainAddr := startServer(&http.Server{Handler: handler})
+	tlsAddr := startServer(&http.Server{
+		Handler:   handler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+	})
+
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for url, expect := range map[string]string{"http://" + plainAddr: "plain", "https://" + tlsAddr: "secure"} {
+		rsp, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading response body: %v", err)
+		}
+		if string(body) != expect {
+			t.Errorf("expected %s to be served by %q handler, got %q", url, expect, body)
+		}
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		expectError(t, <-srvErr, context.Canceled)
+	}
+}
+
+func Test_HandlerByHeader(t *testing.T) {
+	t.Parallel()
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, name) })
+	}
+	handler := HandlerByHeader("x-version", map[string]http.Handler{"canary": named("canary"), "blue": named("blue")}, named("default"))
+
+	testCases := []struct {
+		value  string // value of the X-Version header, empty means no header
+		expect string
+	}{
+		{value: "canary", expect: "canary"},
+		{value: "blue", expect: "blue"},
+		{value: "green", expect: "default"},
+		{value: "", expect: "default"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.value != "" {
+			req.Header.Set("X-Version", tc.value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if body := rec.Body.String(); body != tc.expect {
+			t.Errorf("expected request with header %q to be served by %q handler, got %q", tc.value, tc.expect, body)
+		}
+		if vary := rec.Header().Get("Vary"); vary != "X-Version" {
+			t.Errorf("expected Vary header to be X-Version, got %q", vary)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package httpsrv
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+/*
+NotFoundHandler sets handler which serves the response instead of the bare 404 (Not Found) response
+of the server's handler, ie branded 404 page or JSON error body standardized across services. The
+response is considered bare when the handler responds with status 404 and either writes no body
+or the default "404 page not found" body of [http.NotFound] (which is used by [http.ServeMux] for
+unmatched paths). Responses with status 404 and other body are sent as they are.
+
+The Content-Type, Content-Length and X-Content-Type-Options headers set by the handler are removed
+before the not found handler is called, other headers are kept.
+*/
+func NotFoundHandler(h http.Handler) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.notFound, cfg.notFoundProxy = h, false }}
+}
+
+/*
+ProxyFallback makes the server to reverse proxy the requests the server's handler doesn't know (ie
+responds with bare 404, see [NotFoundHandler]) to the target upstream. This supports "strangler fig"
+migrations where the new routes are handled locally and the rest are passed to the legacy backend.
+The proxy is created by [httputil.NewSingleHostReverseProxy], when the upstream can't be reached the
+error is logged using the server's ErrorLog and status 502 (Bad Gateway) is sent.
+
+The handler must not consume the request body of the requests it doesn't handle. Headers set by the
+handler are not sent (headers set by the other params, ie "Connection: close" during the shutdown,
+are kept), otherwise the response of the upstream is passed on as is. ProxyFallback and
+NotFoundHandler share the mechanism, the one given later in the params is in effect.
+*/
+func ProxyFallback(target *url.URL) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			cfg.logf("httpsrv: proxying %s %s to fallback upstream: %v", r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		cfg.notFound, cfg.notFoundProxy = proxy, true
+	}}
+}
+
+// bareNotFound is the body written by http.NotFound.
+var bareNotFound = []byte("404 page not found\n")
+
+func (cfg *serverConf) replaceNotFound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var preset map[string]bool // headers set before the handler was called
+		if cfg.notFoundProxy {
+			preset = make(map[string]bool, len(w.Header()))
+			for k := range w.Header() {
+				preset[k] = true
+			}
+		}
+		nw := &notFoundWriter{ResponseWriter: w}
+		next.ServeHTTP(nw, r)
+		if nw.state != notFoundPending {
+			return
+		}
+		if len(nw.body) != 0 && !bytes.Equal(nw.body, bareNotFound) {
+			nw.commit()
+			return
+		}
+		nw.state = notFoundReplaced
+		h := w.Header()
+		if cfg.notFoundProxy {
+			for k := range h {
+				if !preset[k] {
+					delete(h, k)
+				}
+			}
+		} else {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			h.Del("X-Content-Type-Options")
+		}
+		cfg.notFound.ServeHTTP(w, r)
+	})
+}
+
+const (
+	notFoundUndecided = iota
+	notFoundPass      // response is not replaced, writes go to the underlying writer
+	notFoundPending   // handler responded with 404, body is buffered until it is known whether it is bare
+	notFoundReplaced  // not found handler serves the response
+)
+
+/*
+notFoundWriter holds back 404 response until it is known whether it is bare (see [NotFoundHandler]).
+At most len(bareNotFound) bytes of the body are buffered.
+*/
+type notFoundWriter struct {
+	http.ResponseWriter
+	state int
+	body  []byte // buffered body of the pending 404 response
+}
+
+// commit writes the pending 404 response.
+func (w *notFoundWriter) commit() {
+	w.state = notFoundPass
+	w.ResponseWriter.WriteHeader(http.StatusNotFound)
+	if len(w.body) != 0 {
+		w.ResponseWriter.Write(w.body)
+	}
+	w.body = nil
+}
+
+func (w *notFoundWriter) WriteHeader(code int) {
+	switch w.state {
+	case notFoundPending, notFoundReplaced:
+		return
+	case notFoundUndecided:
+		if code == http.StatusNotFound {
+			w.state = notFoundPending
+			return
+		}
+		if code >= 200 {
+			w.state = notFoundPass
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notFoundWriter) Write(b []byte) (int, error) {
+	switch w.state {
+	case notFoundUndecided:
+		w.state = notFoundPass
+	case notFoundReplaced:
+		return len(b), nil
+	case notFoundPending:
+		w.body = append(w.body, b...)
+		if !bytes.HasPrefix(bareNotFound, w.body) {
+			w.commit()
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *notFoundWriter) Flush() {
+	if w.state == notFoundPending {
+		w.commit()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *notFoundWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.state = notFoundPass
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *notFoundWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,121 @@
+package httpsrv
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_NotFoundHandler(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/known", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "known") })
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "no such user")
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) })
+
+	cfg := &serverConf{}
+	NotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error":"not found","path":%q}`, r.URL.Path)
+	})).apply(cfg)
+	h := cfg.wrapHandler(mux)
+
+	testCases := []struct {
+		path        string
+		code        int
+		contentType string
+		body        string
+	}{
+		{path: "/unknown", code: 404, contentType: "application/json", body: `{"error":"not found","path":"/unknown"}`},
+		{path: "/empty", code: 404, contentType: "application/json", body: `{"error":"not found","path":"/empty"}`},
+		{path: "/user", code: 404, contentType: "text/plain; charset=utf-8", body: "no such user"},
+		{path: "/known", code: 200, contentType: "text/plain; charset=utf-8", body: "known"},
+	}
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.code, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.contentType, ct)
+		}
+		if body := rec.Body.String(); body != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+		if h := rec.Header().Get("X-Content-Type-Options"); h != "" && tc.contentType == "application/json" {
+			t.Errorf("%s: expected X-Content-Type-Options of the bare response to be removed, got %q", tc.path, h)
+		}
+	}
+}
+
+func Test_ProxyFallback(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", "legacy")
+		fmt.Fprintf(w, "legacy %s %s", r.Method, r.URL.Path)
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("parsing upstream URL: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "new") })
+
+	logs := &strings.Builder{}
+	cfg := &serverConf{srv: &http.Server{ErrorLog: log.New(logs, "", 0)}}
+	ProxyFallback(target).apply(cfg)
+	CloseConnectionsOnShutdown().apply(cfg)
+	h := cfg.wrapHandler(mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/new")
+	if rec.Code != http.StatusOK || rec.Body.String() != "new" || rec.Header().Get("X-Served-By") != "" {
+		t.Errorf("expected local route to be served directly, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	rec = get("/old/page")
+	if rec.Code != http.StatusOK || rec.Body.String() != "legacy GET /old/page" {
+		t.Errorf("expected unmatched route to be proxied, got %d %q", rec.Code, rec.Body.String())
+	}
+	if sb := rec.Header().Get("X-Served-By"); sb != "legacy" {
+		t.Errorf("expected upstream response headers, got %v", rec.Header())
+	}
+	if ct := rec.Header().Get("X-Content-Type-Options"); ct != "" {
+		t.Errorf("expected headers of the local 404 response not to be sent, got %v", rec.Header())
+	}
+
+	// headers set by the other params are kept
+	cfg.shuttingDown.Store(true)
+	if rec := get("/old/page"); rec.Header().Get("Connection") != "close" || rec.Body.String() != "legacy GET /old/page" {
+		t.Errorf("expected proxied response to close the connection during shutdown, got %v", rec.Header())
+	}
+	cfg.shuttingDown.Store(false)
+
+	upstream.Close()
+	rec = get("/old/page")
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502 when upstream is down, got %d", rec.Code)
+	}
+	if s := logs.String(); !strings.Contains(s, "httpsrv: proxying GET /old/page to fallback upstream: ") {
+		t.Errorf("expected proxy error to be logged, got %q", s)
+	}
+}
@@ -0,0 +1,107 @@
+package httpsrv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+NotifyURL makes the server to POST JSON object describing the lifecycle transition to the url when
+the server becomes ready (right before it starts to accept connections) and when the shutdown
+begins, so that external control plane can react to them:
+
+	{"event":"ready","addr":"10.0.0.7:8080","labels":{"zone":"eu-1"}}
+	{"event":"shutdown","addr":"10.0.0.7:8080","labels":{"zone":"eu-1"}}
+
+The notifications are sent in the background with 5 second timeout, failures (including non 2xx
+responses) are logged using the server's ErrorLog. Stopping the server waits for the shutdown
+notification to complete, but not longer than the graceful shutdown deadline (see [ShutdownTimeout]).
+*/
+func NotifyURL(url string) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		n := &notifier{url: url, cfg: cfg, client: &http.Client{Timeout: 5 * time.Second}}
+		cfg.onReady = append(cfg.onReady, n.ready)
+		cfg.watchers = append(cfg.watchers, n.watch)
+		cfg.drainables = append(cfg.drainables, n)
+	}}
+}
+
+type lifecycleEvent struct {
+	Event  string            `json:"event"`
+	Addr   string            `json:"addr,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type notifier struct {
+	url    string
+	cfg    *serverConf
+	client *http.Client
+
+	m            sync.Mutex
+	addr         string          // address the server is listening on
+	pending      []chan struct{} // closed when the notification has been sent
+	shutdownOnce sync.Once
+}
+
+func (n *notifier) ready(ri ReadyInfo) {
+	n.m.Lock()
+	n.addr = ri.Addr.String()
+	n.m.Unlock()
+	n.send("ready")
+}
+
+// watch sends the shutdown notification as soon as the shutdown begins.
+func (n *notifier) watch(ctx context.Context, _ context.CancelCauseFunc) {
+	select {
+	case <-ctx.Done():
+	case <-n.cfg.shutdownSignal:
+	}
+	n.shutdownOnce.Do(func() { n.send("shutdown") })
+}
+
+// Drain waits for the notifications to complete, the shutdown notification is sent
+// first in case the watcher hasn't done it yet.
+func (n *notifier) Drain(ctx context.Context) error {
+	n.shutdownOnce.Do(func() { n.send("shutdown") })
+	n.m.Lock()
+	pending := n.pending
+	n.m.Unlock()
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+func (n *notifier) send(event string) {
+	done := make(chan struct{})
+	n.m.Lock()
+	ev := lifecycleEvent{Event: event, Addr: n.addr, Labels: n.cfg.labels}
+	n.pending = append(n.pending, done)
+	n.m.Unlock()
+
+	go func() {
+		defer close(done)
+		body, err := json.Marshal(ev)
+		if err != nil {
+			n.cfg.logf("httpsrv: encoding %s notification: %v", event, err)
+			return
+		}
+		rsp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			n.cfg.logf("httpsrv: sending %s notification: %v", event, err)
+			return
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+			n.cfg.logf("httpsrv: sending %s notification: unexpected response status %s", event, rsp.Status)
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package httpsrv
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_NotifyURL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("notifications are sent", func(t *testing.T) {
+		t.Parallel()
+		events := make(chan lifecycleEvent, 10)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ev lifecycleEvent
+			if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected request %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+			}
+			if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+				t.Errorf("decoding notification: %v", err)
+			}
+			events <- ev
+		}))
+		defer receiver.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+				NotifyURL(receiver.URL),
+				Labels(map[string]string{"zone": "test"}),
+				ShutdownTimeout(time.Second),
+			)
+		}()
+
+		var addr string
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("ready notification wasn't received")
+		case ev := <-events:
+			if ev.Event != "ready" || ev.Addr == "" || ev.Labels["zone"] != "test" {
+				t.Errorf("unexpected notification: %+v", ev)
+			}
+			addr = ev.Addr
+		}
+
+		cancel()
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		// Run waits for the shutdown notification
+		select {
+		case ev := <-events:
+			if ev.Event != "shutdown" || ev.Addr != addr {
+				t.Errorf("unexpected notification: %+v", ev)
+			}
+		default:
+			t.Error("shutdown notification wasn't received")
+		}
+	})
+
+	t.Run("failure is logged", func(t *testing.T) {
+		t.Parallel()
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer receiver.Close()
+
+		logs := &strings.Builder{}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler(), ErrorLog: log.New(logs, "", 0)},
+			NotifyURL(receiver.URL),
+			ShutdownTimeout(time.Second),
+		)
+		expectError(t, err, context.DeadlineExceeded)
+		if s := logs.String(); !strings.Contains(s, "sending shutdown notification: unexpected response status 502") {
+			t.Errorf("expected failure to be logged, got %q", s)
+		}
+	})
+}
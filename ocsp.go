@@ -0,0 +1,223 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+/*
+OCSPStapling makes the server to staple OCSP response to the certificate it serves, so that clients
+do not need to contact the CA's OCSP responder to check the revocation status (which speeds up the
+handshake and improves privacy). The responder func is called when the server starts and then every
+refresh interval to fetch fresh OCSP response (DER encoded) for the server's certificate, ie from the
+URL listed in the certificate's OCSPServer field. When refresh is smaller than or equal to zero it
+defaults to one hour.
+
+The fetched response is checked to be successful response reporting the certificate (matched by the
+serial number) as good and not expired, its signature is left for the clients to verify. When fetching
+fails or the response is not valid the error is logged using the server's ErrorLog and the previous
+response is kept stapled. Until the first valid response has been fetched the certificate is served
+without staple.
+
+The staple is attached to the certificate loaded from the files given with the [TLS] param or to the
+first certificate in the server's TLSConfig. The certificates are moved from [tls.Config.Certificates]
+to the [tls.Config.GetCertificate] hook which serves the stapled certificate, the GetCertificate
+assigned by user is still called first (for the handshakes with SNI, as without the hook).
+*/
+func OCSPStapling(responder func() ([]byte, error), refresh time.Duration) ServerParam {
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	return serverParam{func(cfg *serverConf) {
+		s := &ocspStapler{fetch: responder, refresh: refresh}
+		cfg.ocsp = s
+		cfg.watchers = append(cfg.watchers, func(ctx context.Context, _ context.CancelCauseFunc) { s.run(ctx, cfg) })
+	}}
+}
+
+type ocspStapler struct {
+	fetch   func() ([]byte, error)
+	refresh time.Duration
+
+	certs   []tls.Certificate               // certificates served, the staple is attached to the first one
+	leaf    *x509.Certificate               // parsed certs[0]
+	stapled atomic.Pointer[tls.Certificate] // certs[0] with the current staple, nil until first staple
+}
+
+/*
+setup prepares the stapler for the TLS config tc and installs the GetCertificate hook which
+serves the certificates. When the certificate is loaded from files the files are cleared from
+cfg so that ServeTLS uses the certificate from the config.
+*/
+func (s *ocspStapler) setup(cfg *serverConf, tc *tls.Config) {
+	if cfg.certFile != "" || cfg.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			// ServeTLS (or OptionalTLS) reports the error
+			return
+		}
+		tc.Certificates = []tls.Certificate{cert}
+		cfg.certFile, cfg.keyFile, cfg.optionalTLS = "", "", false
+	}
+	if len(tc.Certificates) == 0 || len(tc.Certificates[0].Certificate) == 0 {
+		return
+	}
+	s.certs = tc.Certificates
+	s.leaf, _ = x509.ParseCertificate(s.certs[0].Certificate[0])
+	tc.Certificates = nil
+
+	next := tc.GetCertificate
+	tc.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if next != nil && hello.ServerName != "" {
+			if c, err := next(hello); c != nil || err != nil {
+				return c, err
+			}
+		}
+		return s.certificate(hello), nil
+	}
+}
+
+// certificate selects the certificate for the handshake like crypto/tls does for Certificates.
+func (s *ocspStapler) certificate(hello *tls.ClientHelloInfo) *tls.Certificate {
+	first := &s.certs[0]
+	if c := s.stapled.Load(); c != nil {
+		first = c
+	}
+	if len(s.certs) == 1 || hello.SupportsCertificate(first) == nil {
+		return first
+	}
+	for i := 1; i < len(s.certs); i++ {
+		if hello.SupportsCertificate(&s.certs[i]) == nil {
+			return &s.certs[i]
+		}
+	}
+	return first
+}
+
+// run refreshes the staple until ctx is cancelled.
+func (s *ocspStapler) run(ctx context.Context, cfg *serverConf) {
+	if s.leaf == nil {
+		cfg.logf("httpsrv: OCSP stapling: no certificate to staple the response to")
+		return
+	}
+
+	tick := time.NewTicker(s.refresh)
+	defer tick.Stop()
+	for {
+		if err := s.update(); err != nil {
+			cfg.logf("httpsrv: OCSP stapling: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// update fetches new OCSP response and when it is valid makes it the current staple.
+func (s *ocspStapler) update() error {
+	staple, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("fetching OCSP response: %w", err)
+	}
+	if err := validateOCSPResponse(staple, s.leaf, time.Now()); err != nil {
+		return fmt.Errorf("invalid OCSP response: %w", err)
+	}
+
+	cert := s.certs[0]
+	cert.OCSPStaple = staple
+	s.stapled.Store(&cert)
+	return nil
+}
+
+// ASN.1 structures of the OCSP response, see RFC 6960
+type (
+	ocspResponse struct {
+		Status   asn1.Enumerated
+		Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+	}
+
+	ocspResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	}
+
+	ocspBasicResponse struct {
+		TBSResponseData    ocspResponseData
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+		Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+	}
+
+	ocspResponseData struct {
+		Version        int `asn1:"optional,default:0,explicit,tag:0"`
+		RawResponderID asn1.RawValue
+		ProducedAt     time.Time `asn1:"generalized"`
+		Responses      []ocspSingleResponse
+	}
+
+	ocspSingleResponse struct {
+		CertID     ocspCertID
+		Good       asn1.Flag        `asn1:"tag:0,optional"`
+		Revoked    asn1.RawValue    `asn1:"tag:1,optional"`
+		Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+		ThisUpdate time.Time        `asn1:"generalized"`
+		NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+		Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+	}
+
+	ocspCertID struct {
+		HashAlgorithm pkix.AlgorithmIdentifier
+		NameHash      []byte
+		IssuerKeyHash []byte
+		SerialNumber  *big.Int
+	}
+)
+
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+/*
+validateOCSPResponse checks that the DER encoded OCSP response is successful basic response which
+reports the cert as good and which hasn't expired. The signature of the response is not verified.
+*/
+func validateOCSPResponse(der []byte, cert *x509.Certificate, now time.Time) error {
+	var rsp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &rsp); err != nil {
+		return err
+	} else if len(rest) != 0 {
+		return errors.New("trailing data after the response")
+	}
+	if rsp.Status != 0 {
+		return fmt.Errorf("response status %d is not successful", rsp.Status)
+	}
+	if !rsp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return fmt.Errorf("unsupported response type %v", rsp.Response.ResponseType)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(rsp.Response.Response, &basic); err != nil {
+		return fmt.Errorf("parsing basic response: %w", err)
+	}
+
+	for _, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber == nil || r.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		switch {
+		case !bool(r.Good):
+			return errors.New("certificate status is not good")
+		case !r.NextUpdate.IsZero() && r.NextUpdate.Before(now):
+			return fmt.Errorf("response expired at %s", r.NextUpdate)
+		}
+		return nil
+	}
+	return fmt.Errorf("response doesn't cover certificate with serial number %s", cert.SerialNumber)
+}
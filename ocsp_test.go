@@ -0,0 +1,192 @@
+package httpsrv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_OCSPStapling(t *testing.T) {
+	t.Parallel()
+
+	staple := testOCSPResponse(t, big.NewInt(1), true, time.Now().Add(time.Hour))
+
+	test := func(t *testing.T, tlsConfig *tls.Config, params ...ServerParam) {
+		var fail atomic.Bool
+		var calls atomic.Int32
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go Run(ctx,
+			&http.Server{
+				Handler:   http.NotFoundHandler(),
+				TLSConfig: tlsConfig,
+				ErrorLog:  log.New(io.Discard, "", 0),
+			},
+			append(params,
+				Listener(ln),
+				OCSPStapling(func() ([]byte, error) {
+					calls.Add(1)
+					if fail.Load() {
+						return nil, errors.New("responder unavailable")
+					}
+					return staple, nil
+				}, 20*time.Millisecond),
+			)...,
+		)
+
+		// returns the OCSP response stapled by the server and the negotiated protocol
+		handshake := func() ([]byte, string) {
+			c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+			if err != nil {
+				t.Fatalf("TLS handshake failed: %v", err)
+			}
+			defer c.Close()
+			cs := c.ConnectionState()
+			return cs.OCSPResponse, cs.NegotiatedProtocol
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for rsp, _ := handshake(); len(rsp) == 0; rsp, _ = handshake() {
+			if time.Now().After(deadline) {
+				t.Fatal("OCSP response wasn't stapled within timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		rsp, proto := handshake()
+		if !bytes.Equal(rsp, staple) {
+			t.Errorf("unexpected OCSP response stapled: %x", rsp)
+		}
+		if proto != "h2" {
+			t.Errorf("expected h2 to be negotiated with stapled certificate, got %q", proto)
+		}
+
+		// when fetching fails the previous response must be kept
+		fail.Store(true)
+		n := calls.Load()
+		for calls.Load() < n+2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if rsp, _ := handshake(); !bytes.Equal(rsp, staple) {
+			t.Errorf("expected previous OCSP response to be kept, got %x", rsp)
+		}
+	}
+
+	t.Run("certificate in config", func(t *testing.T) {
+		t.Parallel()
+		test(t, &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	})
+
+	t.Run("certificate files", func(t *testing.T) {
+		t.Parallel()
+		certFile, keyFile := writeTestCertificate(t)
+		test(t, nil, TLS(certFile, keyFile))
+	})
+}
+
+func Test_OCSPStapling_defaultRefresh(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{srv: &http.Server{ErrorLog: log.New(io.Discard, "", 0)}}
+	OCSPStapling(func() ([]byte, error) { return nil, errors.New("no responder") }, 0).apply(cfg)
+	if cfg.ocsp.refresh != time.Hour {
+		t.Errorf("expected default refresh interval to be one hour, got %s", cfg.ocsp.refresh)
+	}
+
+	// must not panic creating the ticker
+	cfg.ocsp.leaf = &x509.Certificate{SerialNumber: big.NewInt(1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.ocsp.run(ctx, cfg)
+}
+
+func Test_validateOCSPResponse(t *testing.T) {
+	t.Parallel()
+
+	cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	now := time.Now()
+
+	if err := validateOCSPResponse(testOCSPResponse(t, big.NewInt(1), true, now.Add(time.Hour)), cert, now); err != nil {
+		t.Errorf("expected valid response, got error: %v", err)
+	}
+	if err := validateOCSPResponse(testOCSPResponse(t, big.NewInt(1), true, time.Time{}), cert, now); err != nil {
+		t.Errorf("expected response without next update to be valid, got error: %v", err)
+	}
+
+	var testCases = []struct {
+		name string
+		rsp  []byte
+		err  string
+	}{
+		{"not DER", []byte("garbage"), "asn1: structure error"},
+		{"unsuccessful", []byte{0x30, 0x03, 0x0a, 0x01, 0x06}, "response status 6 is not successful"},
+		{"other certificate", testOCSPResponse(t, big.NewInt(2), true, now.Add(time.Hour)), "response doesn't cover certificate with serial number 1"},
+		{"not good", testOCSPResponse(t, big.NewInt(1), false, now.Add(time.Hour)), "certificate status is not good"},
+		{"expired", testOCSPResponse(t, big.NewInt(1), true, now.Add(-time.Minute)), "response expired at "},
+	}
+	for _, tc := range testCases {
+		err := validateOCSPResponse(tc.rsp, cert, now)
+		if err == nil {
+			t.Errorf("[%s] expected error, got nil", tc.name)
+		} else if !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("[%s] expected error starting with %q, got %q", tc.name, tc.err, err)
+		}
+	}
+}
+
+// testOCSPResponse returns DER encoded (unsigned) OCSP response for the certificate with given serial number.
+func testOCSPResponse(t *testing.T, serial *big.Int, good bool, nextUpdate time.Time) []byte {
+	t.Helper()
+
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, Parameters: asn1.NullRawValue},
+			NameHash:      make([]byte, 20),
+			IssuerKeyHash: make([]byte, 20),
+			SerialNumber:  serial,
+		},
+		Good:       asn1.Flag(good),
+		ThisUpdate: time.Now().Add(-time.Hour).UTC().Truncate(time.Second),
+		NextUpdate: nextUpdate.UTC().Truncate(time.Second),
+	}
+	if !good {
+		single.Revoked = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: mustMarshal(t, time.Now().UTC().Truncate(time.Second), "generalized")}
+	}
+	keyHash := mustMarshal(t, make([]byte, 20), "")
+	basic := mustMarshal(t, ocspBasicResponse{
+		TBSResponseData: ocspResponseData{
+			RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+			ProducedAt:     time.Now().UTC().Truncate(time.Second),
+			Responses:      []ocspSingleResponse{single},
+		},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	}, "")
+	return mustMarshal(t, ocspResponse{Response: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basic}}, "")
+}
+
+func mustMarshal(t *testing.T, v any, params string) []byte {
+	t.Helper()
+	b, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		t.Fatalf("marshaling %T: %v", v, err)
+	}
+	return b
+}
@@ -1,8 +1,11 @@
 package httpsrv
 
 import (
+	"context"
+	"maps"
 	"net"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -30,6 +33,17 @@ func Listener(l net.Listener) ServerParam {
 	return serverParam{func(cfg *serverConf) { cfg.l = l }}
 }
 
+/*
+ListenConfig sets the config used to create the listener on the server's Addr, the Run's
+context is passed to it's Listen method. This allows to set socket options (ie using the
+Control hook), TCP keep-alive or multipath TCP of the listener.
+
+ListenConfig can't be used together with the [Listener] parameter, [Run] returns error then.
+*/
+func ListenConfig(lc net.ListenConfig) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.listenConfig = &lc }}
+}
+
 /*
 Endpoints sets the HTTP request multiplexer for the server (Server.Handler field).
 
@@ -52,13 +66,25 @@ Keep in mind that in "managed environment" (ie Kubernetes) the instance (pod) co
 by the orchestrator before this timeout is reached.
 */
 func ShutdownTimeout(to time.Duration) ServerParam {
-	return serverParam{func(cfg *serverConf) { cfg.shutdownTO = to }}
+	return serverParam{func(cfg *serverConf) { cfg.shutdownTO, cfg.shutdownTOFunc = to, nil }}
+}
+
+/*
+ShutdownTimeoutFunc is like [ShutdownTimeout] but the timeout is evaluated when the shutdown starts,
+not when the server is configured. This allows the timeout to come from a live source, ie
+environment or dynamic configuration reflecting orchestrator's termination grace period.
+
+When both ShutdownTimeout and ShutdownTimeoutFunc are used the one given last wins.
+*/
+func ShutdownTimeoutFunc(to func() time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.shutdownTOFunc = to }}
 }
 
 /*
 ShutdownOnPanic instructs the http server to shut down when unhandled panic (except [http.ErrAbortHandler])
 escapes some handler. The http server's Close method will be used to shut down the server immediately, ie
-the [ShutdownTimeout] parameter is ignored.
+the [ShutdownTimeout] parameter is ignored. When several handlers panic (nearly) simultaneously
+the first panic is reported by [Run], the others just close the already closing server.
 
 By default http.Server just logs the panic and carries on but some argue that in case of
 unhandled panic service should always die and new instance started - this option provides
@@ -68,10 +94,122 @@ func ShutdownOnPanic() ServerParam {
 	return serverParam{func(cfg *serverConf) { cfg.dieOnPanic = true }}
 }
 
+/*
+PanicLogger sets func which is called with the recovered value, the stack trace of the panic and
+the request being served when unhandled panic (except [http.ErrAbortHandler]) escapes some handler.
+This allows to report panics with the request context (method, path, request ID header) instead
+of the stdlib's "http: panic serving ..." line written to the [http.Server.ErrorLog].
+
+The logger is called whether or not [ShutdownOnPanic] is used, when it is not used the connection
+is still aborted (as the stdlib does) but the panic is not logged by the http.Server.
+*/
+func PanicLogger(logger func(r any, stack []byte, req *http.Request)) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.panicLogger = logger }}
+}
+
+/*
+IgnorePanics adds predicate which is consulted when [ShutdownOnPanic] is in effect and
+unhandled panic escapes some handler - when the predicate returns true for the recovered
+value the panic is treated as benign, ie server is not shut down. This allows to add
+custom sentinel panic values (ie "client gone") to the [http.ErrAbortHandler] which is
+always ignored.
+
+Parameter can be used multiple times, panic is ignored when any of the predicates returns true.
+*/
+func IgnorePanics(ignore func(r any) bool) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.ignorePanic = append(cfg.ignorePanic, ignore) }}
+}
+
 /*
 TLS allows to start the server using [http.Server.ServeTLS].
 Alternatively the server's [http.Server.TLSConfig] field can be assigned when passing it to [Run].
 */
 func TLS(certFile, keyFile string) ServerParam {
-	return serverParam{func(cfg *serverConf) { cfg.certFile, cfg.keyFile = certFile, keyFile }}
+	return serverParam{func(cfg *serverConf) { cfg.certFile, cfg.keyFile, cfg.optionalTLS = certFile, keyFile, false }}
+}
+
+/*
+RequireRoutes enables stricter validation of the server's handler - before starting the server
+the handler is probed with GET requests to given paths (or to "/" when no paths are given) and
+when handler responds with 404 to all of them [Run] returns error. This helps to catch
+accidental deployments of "empty server" (ie routes were not registered). The probes go through
+the middleware installed by the params so the endpoints served by the params (ie
+[ReadinessEndpoint]) count as routes, gates like [ServeWhenLeader] do not reject the probes. Handler
+panicking during the probe doesn't crash [Run], the route is considered to exist.
+
+Keep in mind that probing sends real requests to the business handlers (and the middleware, ie
+metrics, sees them) before the server is started, so the probe paths should point to handlers
+without side effects. See also [AllowEmptyRoutes].
+*/
+func RequireRoutes(probe ...string) ServerParam {
+	if len(probe) == 0 {
+		probe = []string{"/"}
+	}
+	return serverParam{func(cfg *serverConf) { cfg.routeProbes = probe }}
+}
+
+/*
+AllowEmptyRoutes disables the route validation enabled by [RequireRoutes], regardless
+of the order the params are given. This makes it explicit that server which has no
+"business routes" (ie it only serves health endpoint) is intended.
+*/
+func AllowEmptyRoutes() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.allowEmptyRoutes = true }}
+}
+
+/*
+DrainFunc sets function which is called after the http server has stopped accepting new
+requests (ie after [http.Server.Shutdown] returned) but before [Run] returns. It is meant
+for flushing work queued by the handlers.
+
+The ctx passed to the drain func carries the deadline of the graceful shutdown (see
+[ShutdownTimeout]) so drain func knows how much time it has left. When no shutdown
+timeout is set the ctx has no deadline. Error returned by drain func is joined into the
+error returned by [Run].
+
+Drain func is not called when server is stopped because of panic (see [ShutdownOnPanic]).
+*/
+func DrainFunc(drain func(ctx context.Context) error) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.drain = drain }}
+}
+
+/*
+PostBind sets hook which is called right after the listener has been bound, on the goroutine
+which then starts serving, before the first connection is accepted. When the hook returns
+error the listener is closed and the server doesn't start, [Run] returns the error.
+
+Common use-case is the "bind privileged port, then de-escalate" pattern - the hook is the
+place to drop privileges or chown the socket before serving begins.
+*/
+func PostBind(hook func(net.Listener) error) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.postBind = hook }}
+}
+
+/*
+NotifyOnShutdownComplete instructs [Run] to send signal sig to the process pid once the
+server has shut down (just before Run returns). This allows to coordinate multi-process
+handoffs where supervisor waits for the old process to finish draining.
+
+The pid must be greater than zero, otherwise Run returns error without starting the server.
+Failure to send the signal is logged using the server's ErrorLog.
+*/
+func NotifyOnShutdownComplete(pid int, sig os.Signal) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.notifyPID, cfg.notifySig = pid, sig }}
+}
+
+/*
+Labels sets labels describing the service instance (ie environment, region, version) which are
+appended (as key=value pairs, sorted by key) to all the lifecycle log lines written by the server
+and reported in the [ReadyInfo]. This gives consistent, correlatable logs across the fleet without
+each call site threading the labels through.
+
+When the param is used multiple times the labels are merged, later value wins for the same key.
+*/
+func Labels(labels map[string]string) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		if cfg.labels == nil {
+			cfg.labels = make(map[string]string, len(labels))
+		}
+		maps.Copy(cfg.labels, labels)
+	}}
 }
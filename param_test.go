@@ -1,8 +1,14 @@
 package httpsrv
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"net"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -45,6 +51,19 @@ func Test_ServerParam(t *testing.T) {
 		}
 	})
 
+	t.Run("ShutdownTimeoutFunc", func(t *testing.T) {
+		cfg := serverConf{}
+		ShutdownTimeoutFunc(func() time.Duration { return 2 * time.Second }).apply(&cfg)
+		if to := cfg.shutdownTimeout(); to != 2*time.Second {
+			t.Errorf("unexpected timeout value %s", to)
+		}
+		// last one wins
+		ShutdownTimeout(time.Second).apply(&cfg)
+		if to := cfg.shutdownTimeout(); to != time.Second {
+			t.Errorf("unexpected timeout value %s", to)
+		}
+	})
+
 	t.Run("ShutdownOnPanic", func(t *testing.T) {
 		cfg := serverConf{}
 		ShutdownOnPanic().apply(&cfg)
@@ -53,6 +72,15 @@ func Test_ServerParam(t *testing.T) {
 		}
 	})
 
+	t.Run("IgnorePanics", func(t *testing.T) {
+		cfg := serverConf{}
+		IgnorePanics(func(r any) bool { return false }).apply(&cfg)
+		IgnorePanics(func(r any) bool { return true }).apply(&cfg)
+		if len(cfg.ignorePanic) != 2 {
+			t.Errorf("expected 2 predicates, got %d", len(cfg.ignorePanic))
+		}
+	})
+
 	t.Run("TLS", func(t *testing.T) {
 		cfg := serverConf{}
 		TLS("cert", "key").apply(&cfg)
@@ -63,4 +91,74 @@ func Test_ServerParam(t *testing.T) {
 			t.Errorf("unexpected keyFile value: %s", cfg.keyFile)
 		}
 	})
+	t.Run("RequireRoutes", func(t *testing.T) {
+		cfg := serverConf{}
+		RequireRoutes().apply(&cfg)
+		if len(cfg.routeProbes) != 1 || cfg.routeProbes[0] != "/" {
+			t.Errorf("unexpected default probes: %q", cfg.routeProbes)
+		}
+		RequireRoutes("/a", "/b").apply(&cfg)
+		if len(cfg.routeProbes) != 2 || cfg.routeProbes[0] != "/a" || cfg.routeProbes[1] != "/b" {
+			t.Errorf("unexpected probes: %q", cfg.routeProbes)
+		}
+	})
+
+	t.Run("AllowEmptyRoutes", func(t *testing.T) {
+		cfg := serverConf{}
+		AllowEmptyRoutes().apply(&cfg)
+		if !cfg.allowEmptyRoutes {
+			t.Errorf("unexpected allowEmptyRoutes value %t", cfg.allowEmptyRoutes)
+		}
+	})
+}
+
+func Test_Labels(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	logs := &bytes.Buffer{}
+	srv := &http.Server{
+		Handler:  http.NotFoundHandler(),
+		ErrorLog: slog.NewLogLogger(slog.NewJSONHandler(logs, nil), slog.LevelError),
+	}
+	dir := t.TempDir()
+	infoC := make(chan ReadyInfo, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, srv,
+			Listener(ln),
+			// missing certificate files cause lifecycle log line to be written
+			OptionalTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")),
+			Labels(map[string]string{"region": "eu-north", "env": "prod"}),
+			Labels(map[string]string{"version": "1.2 beta"}),
+			OnReady(func(ri ReadyInfo) { infoC <- ri }),
+		)
+	}()
+
+	var info ReadyInfo
+	select {
+	case info = <-infoC:
+	case <-time.After(time.Second):
+		t.Fatal("OnReady hook wasn't called")
+	}
+	cancel()
+	expectError(t, <-srvErr, context.Canceled)
+
+	if len(info.Labels) != 3 || info.Labels["env"] != "prod" || info.Labels["region"] != "eu-north" || info.Labels["version"] != "1.2 beta" {
+		t.Errorf("unexpected labels in ReadyInfo: %v", info.Labels)
+	}
+
+	var entry struct{ Msg string }
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding log entry %q: %v", logs.String(), err)
+	}
+	if !strings.HasSuffix(entry.Msg, ` env=prod region=eu-north version="1.2 beta"`) {
+		t.Errorf("expected labels to be appended to the log message, got %q", entry.Msg)
+	}
 }
@@ -0,0 +1,69 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ShutdownPhase registers named step of the teardown which is run after the server has been
+stopped (ie after [http.Server.Shutdown] has returned and the [DrainFunc] has been called).
+The phases are run one by one in the order they were registered, each of them is bounded by it's
+own timeout (zero or negative timeout means no deadline) independent of the [ShutdownTimeout]:
+
+	httpsrv.ShutdownPhase("flush queue", 10*time.Second, queue.Flush),
+	httpsrv.ShutdownPhase("close db", 2*time.Second, func(ctx context.Context) error { return db.Close() }),
+
+The ctx passed to fn is cancelled when the timeout elapses, when fn doesn't return by then
+it is abandoned (left running) and the next phase is started. Duration of each phase is
+logged using the server's ErrorLog, errors returned by the phases are joined into the error
+returned by [Run].
+
+Phases are not run when the server is stopped because of panic (see [ShutdownOnPanic]).
+*/
+func ShutdownPhase(name string, timeout time.Duration, fn func(context.Context) error) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cfg.phases = append(cfg.phases, shutdownPhase{name: name, timeout: timeout, fn: fn})
+	}}
+}
+
+type shutdownPhase struct {
+	name    string
+	timeout time.Duration
+	fn      func(context.Context) error
+}
+
+// runPhases runs the shutdown phases, returned error is the joined errors of the failed phases.
+func (cfg *serverConf) runPhases() error {
+	var errs []error
+	for _, p := range cfg.phases {
+		start := time.Now()
+		if err := p.run(); err != nil {
+			cfg.logf("httpsrv: shutdown phase %q failed after %s: %v", p.name, time.Since(start), err)
+			errs = append(errs, fmt.Errorf("shutdown phase %q: %w", p.name, err))
+		} else {
+			cfg.logf("httpsrv: shutdown phase %q completed in %s", p.name, time.Since(start))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p shutdownPhase) run() error {
+	ctx := context.Background()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
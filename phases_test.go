@@ -0,0 +1,68 @@
+package httpsrv
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownPhase(t *testing.T) {
+	t.Parallel()
+
+	var m sync.Mutex
+	var ran []string
+	record := func(name string) {
+		m.Lock()
+		defer m.Unlock()
+		ran = append(ran, name)
+	}
+	release := make(chan struct{})
+	defer close(release)
+
+	logs := &strings.Builder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler(), ErrorLog: log.New(logs, "", 0)},
+			ShutdownPhase("flush", 100*time.Millisecond, func(ctx context.Context) error {
+				record("flush")
+				// doesn't respect the ctx cancellation
+				<-release
+				return nil
+			}),
+			ShutdownPhase("close", time.Second, func(ctx context.Context) error {
+				record("close")
+				return nil
+			}),
+		)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 500*time.Millisecond {
+			t.Errorf("expected the slow phase to be abandoned after it's timeout, took %s", elapsed)
+		}
+		expectError(t, err, context.DeadlineExceeded)
+		if !strings.Contains(err.Error(), `shutdown phase "flush": context deadline exceeded`) {
+			t.Errorf("expected error to name the failed phase, got %q", err)
+		}
+	}
+
+	m.Lock()
+	if len(ran) != 2 || ran[0] != "flush" || ran[1] != "close" {
+		t.Errorf("expected both phases to run in order, got %v", ran)
+	}
+	m.Unlock()
+	if s := logs.String(); !strings.Contains(s, `shutdown phase "flush" failed after `) || !strings.Contains(s, `shutdown phase "close" completed in `) {
+		t.Errorf("expected phases to be logged, got %q", s)
+	}
+}
@@ -0,0 +1,40 @@
+package httpsrv
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+/*
+WritePortToFile makes the server to write the port it is listening on into the file at path
+after the listener has been bound (and [PostBind] hook has succeeded). This allows external
+processes (ie test orchestration scripts) to discover the port when the server is bound to the
+ephemeral port (Addr ":0"). When the listener is not TCP listener it's address is written instead.
+
+When removeOnShutdown is true the file is removed once the server has stopped serving. Failure
+to write or remove the file is logged using the server's ErrorLog, it doesn't stop the server.
+*/
+func WritePortToFile(path string, removeOnShutdown bool) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.portFile, cfg.rmPortFile = path, removeOnShutdown }}
+}
+
+// writePortFile writes the port of the listener l into the portFile, returns
+// true when the file was written.
+func (cfg *serverConf) writePortFile(l net.Listener) bool {
+	data := l.Addr().String()
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		data = strconv.Itoa(addr.Port)
+	}
+	if err := os.WriteFile(cfg.portFile, []byte(data+"\n"), 0o644); err != nil {
+		cfg.logf("httpsrv: writing port to file: %v", err)
+		return false
+	}
+	return true
+}
+
+func (cfg *serverConf) removePortFile() {
+	if err := os.Remove(cfg.portFile); err != nil {
+		cfg.logf("httpsrv: removing port file: %v", err)
+	}
+}
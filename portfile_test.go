@@ -0,0 +1,66 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_WritePortToFile(t *testing.T) {
+	t.Parallel()
+
+	// starts server on ephemeral port, makes request to the port read from the
+	// file and stops the server.
+	run := func(t *testing.T, remove bool) string {
+		path := filepath.Join(t.TempDir(), "port")
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Addr: "127.0.0.1:0", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+				WritePortToFile(path, remove),
+			)
+		}()
+
+		var port []byte
+		for i := 0; len(port) == 0; i++ {
+			if i == 100 {
+				t.Fatal("port file wasn't written")
+			}
+			time.Sleep(10 * time.Millisecond)
+			port, _ = os.ReadFile(path)
+		}
+		rsp, err := http.Get("http://127.0.0.1:" + strings.TrimSpace(string(port)))
+		if err != nil {
+			t.Fatalf("GET request to the port read from the file failed: %v", err)
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status %d", rsp.StatusCode)
+		}
+
+		cancel()
+		expectError(t, <-srvErr, context.Canceled)
+		return path
+	}
+
+	t.Run("file is removed on shutdown", func(t *testing.T) {
+		t.Parallel()
+		if _, err := os.Stat(run(t, true)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected port file to be removed, got %v", err)
+		}
+	})
+
+	t.Run("file is kept", func(t *testing.T) {
+		t.Parallel()
+		if _, err := os.Stat(run(t, false)); err != nil {
+			t.Errorf("expected port file to exist: %v", err)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+/*
+Package profiling provides handler serving the runtime profiling data (see [net/http/pprof]) and
+the exported variables (see [expvar]) which is meant to be mounted on the mux of the admin server
+(separate [httpsrv.Run] on internal port), never on the public one:
+
+	adminMux.Handle("/debug/", profiling.Handler())
+
+This is separate package because importing net/http/pprof and expvar registers their handlers
+on the [http.DefaultServeMux] as side effect - the httpsrv package doesn't import them so that
+profiling is not exposed by accident. Do not serve the DefaultServeMux on public port when this
+package is used.
+*/
+package profiling
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+/*
+Handler returns handler serving the pprof endpoints under "/debug/pprof/" and the exported
+variables at "/debug/vars". The handler must be mounted so that it sees the full request path,
+ie on "/debug/" pattern of the admin mux.
+*/
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
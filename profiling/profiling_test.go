@@ -0,0 +1,65 @@
+package profiling
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ainvaltin/httpsrv"
+)
+
+func Test_Handler(t *testing.T) {
+	t.Parallel()
+
+	// starts server with the mux, returns base URL of the server
+	startServer := func(t *testing.T, ctx context.Context, mux *http.ServeMux) string {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		srvErr := make(chan error, 1)
+		go func() { srvErr <- httpsrv.Run(ctx, &http.Server{Handler: mux}, httpsrv.Listener(ln)) }()
+		t.Cleanup(func() { <-srvErr })
+		return "http://" + ln.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/debug/", Handler())
+	admin := startServer(t, ctx, adminMux)
+
+	publicMux := http.NewServeMux()
+	publicMux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {})
+	public := startServer(t, ctx, publicMux)
+
+	c := &http.Client{Timeout: 5 * time.Second}
+	get := func(t *testing.T, url string) *http.Response {
+		t.Helper()
+		rsp, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline", "/debug/vars"} {
+		if rsp := get(t, admin+path); rsp.StatusCode != http.StatusOK {
+			t.Errorf("expected %s to be served on admin port, got %s", path, rsp.Status)
+		}
+	}
+	if rsp := get(t, admin+"/debug/vars"); rsp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("unexpected content type of expvars %q", rsp.Header.Get("Content-Type"))
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+		if rsp := get(t, public+path); rsp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected %s to be absent on public port, got %s", path, rsp.Status)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package httpsrv
+
+import (
+	"context"
+	"maps"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ReadyWhen delays serving the requests until the ready func succeeds (returns nil error). The
+port is bound immediately (so orchestrator sees an open socket) but until the server is ready
+all requests get response with status 503 (Service Unavailable). The ready func is retried
+with exponential backoff (starting from 100ms, up to 10s between attempts) until it succeeds
+or the server is stopped. The ctx passed to ready func is cancelled when the server is stopped.
+
+This separates "bound" from "ready to serve", ie service might need DB connection before
+it can serve any requests. Requests to the health probes ([LivenessEndpoint], [ReadinessEndpoint]
+and [ProbePaths]) are served while not ready, the readiness endpoint reports failure.
+*/
+func ReadyWhen(ready func(ctx context.Context) error) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		g := &readyGate{check: ready}
+		cfg.readyGate = g
+		cfg.watchers = append(cfg.watchers, g.wait)
+	}}
+}
+
+type readyGate struct {
+	check func(ctx context.Context) error
+	ready atomic.Bool
+}
+
+func (g *readyGate) wait(ctx context.Context, _ context.CancelCauseFunc) {
+	delay := 100 * time.Millisecond
+	for g.check(ctx) != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay = 2 * delay; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+	g.ready.Store(true)
+}
+
+func (g *readyGate) wrap(cfg *serverConf, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.ready.Load() && !cfg.isProbe(r) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+ServeWhenLeader makes the server to serve requests only while isLeader returns true, otherwise
+requests get response with status 503 (Service Unavailable). The port is bound regardless so
+that the standby instance of the singleton workload in HA deployment can take over quickly when
+it wins the leader election; when the leadership is lost the server flips back to rejecting.
+
+The isLeader func is called for every request so it should be cheap, ie load an atomic flag
+maintained by the leader election client. Requests to the health probes ([LivenessEndpoint],
+[ReadinessEndpoint] and [ProbePaths]) are served by the standby too (so the orchestrator doesn't
+restart it), the readiness endpoint reports failure.
+*/
+func ServeWhenLeader(isLeader func() bool) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.isLeader = isLeader }}
+}
+
+func (cfg *serverConf) leaderGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.isLeader() && !cfg.isProbe(r) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+ReadyInfo describes the effective configuration of the started server, see [OnReady].
+*/
+type ReadyInfo struct {
+	Addr            net.Addr          // address the server is listening on
+	TLS             bool              // whether the server is serving TLS
+	Protocols       []string          // protocols the server is expected to serve, ie "http/1.1", "h2"
+	ShutdownMode    ShutdownMode      // how the server will be stopped
+	ShutdownTimeout time.Duration     // graceful shutdown timeout (evaluated at the start)
+	Middleware      []string          // handler wrappers installed by params, outermost first
+	Labels          map[string]string // labels of the instance, see Labels
+}
+
+/*
+OnReady registers hook which is called with the effective configuration of the server after
+the listener has been bound (and [PostBind] hook has succeeded), right before the server starts
+to accept connections. The main use-case is to log a single informative startup line.
+
+The hook is called synchronously so it should return quickly.
+*/
+func OnReady(hook func(ReadyInfo)) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.onReady = append(cfg.onReady, hook) }}
+}
+
+func (cfg *serverConf) readyInfo(addr net.Addr) ReadyInfo {
+	info := ReadyInfo{
+		Addr:      addr,
+		TLS:       cfg.useTLS(),
+		Protocols: []string{"http/1.1"},
+	}
+	info.ShutdownMode, info.ShutdownTimeout = cfg.shutdownMode()
+	// stdlib enables HTTP/2 over TLS unless TLSNextProto has been assigned
+	if info.TLS && cfg.srv.TLSNextProto == nil {
+		info.Protocols = append(info.Protocols, "h2")
+	}
+	if len(cfg.labels) != 0 {
+		info.Labels = maps.Clone(cfg.labels)
+	}
+	for i := len(cfg.middleware) - 1; i >= 0; i-- {
+		info.Middleware = append(info.Middleware, cfg.middleware[i])
+	}
+	return info
+}
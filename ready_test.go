@@ -0,0 +1,233 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ReadyWhen(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	var readyAt atomic.Pointer[time.Time]
+	start := time.Now()
+	ready := func(ctx context.Context) error {
+		if time.Since(start) < 250*time.Millisecond {
+			return errors.New("DB not available")
+		}
+		now := time.Now()
+		readyAt.CompareAndSwap(nil, &now)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			ReadyWhen(ready),
+		)
+	}()
+
+	c := http.Client{Timeout: time.Second}
+	get := func() int {
+		rsp, err := c.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	// port is bound immediately but server is not ready
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 before server is ready, got %d", code)
+	}
+
+	code := 0
+	for i := 0; i < 40 && code != http.StatusOK; i++ {
+		time.Sleep(25 * time.Millisecond)
+		code = get()
+	}
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200 after server is ready, got %d", code)
+	}
+	if readyAt.Load() == nil {
+		t.Error("requests were served before the ready func succeeded")
+	}
+
+	cancel()
+	expectError(t, <-srvErr, context.Canceled)
+}
+
+func Test_ServeWhenLeader(t *testing.T) {
+	t.Parallel()
+
+	var leader atomic.Bool
+	cfg := &serverConf{}
+	ServeWhenLeader(leader.Load).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		leader bool
+		code   int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusNoContent},
+		{false, http.StatusServiceUnavailable}, // leadership lost
+		{true, http.StatusNoContent},
+	} {
+		leader.Store(tc.leader)
+		if code := serve(); code != tc.code {
+			t.Errorf("leader=%t: expected status %d, got %d", tc.leader, tc.code, code)
+		}
+	}
+}
+
+func Test_gatesExemptProbes(t *testing.T) {
+	t.Parallel()
+
+	var open atomic.Bool
+	gates := map[string]ServerParam{
+		"ReadyWhen": ReadyWhen(func(ctx context.Context) error { return errors.New("not ready") }),
+		"Warmup":    Warmup(func(ctx context.Context) error { return nil }, true),
+		"leader":    ServeWhenLeader(open.Load),
+	}
+	for name, gate := range gates {
+		cfg := &serverConf{}
+		gate.apply(cfg)
+		LivenessEndpoint("/healthz").apply(cfg)
+		ReadinessEndpoint("/readyz").apply(cfg)
+		ProbePaths("/status").apply(cfg)
+		h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		for path, code := range map[string]int{
+			"/":        http.StatusServiceUnavailable,
+			"/healthz": http.StatusOK,
+			"/readyz":  http.StatusServiceUnavailable,
+			"/status":  http.StatusNoContent,
+		} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			if rec.Code != code {
+				t.Errorf("[%s] expected status %d for %s, got %d", name, code, path, rec.Code)
+			}
+		}
+	}
+
+	// readiness succeeds once the gate opens
+	cfg := &serverConf{}
+	ServeWhenLeader(open.Load).apply(cfg)
+	ReadinessEndpoint("/readyz").apply(cfg)
+	h := cfg.wrapHandler(http.NotFoundHandler())
+	open.Store(true)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected readiness status 200 for the leader, got %d", rec.Code)
+	}
+}
+
+func Test_OnReady(t *testing.T) {
+	t.Parallel()
+
+	runServer := func(t *testing.T, srv *http.Server, params ...ServerParam) ReadyInfo {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		infoC := make(chan ReadyInfo, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, srv, append(params, Listener(ln), OnReady(func(ri ReadyInfo) { infoC <- ri }))...)
+		}()
+
+		var info ReadyInfo
+		select {
+		case info = <-infoC:
+		case <-time.After(time.Second):
+			t.Fatal("OnReady hook wasn't called")
+		}
+		if info.Addr.String() != ln.Addr().String() {
+			t.Errorf("expected address %s, got %s", ln.Addr(), info.Addr)
+		}
+		cancel()
+		expectError(t, <-srvErr, context.Canceled)
+		return info
+	}
+
+	t.Run("plain http", func(t *testing.T) {
+		info := runServer(t,
+			&http.Server{Handler: http.NotFoundHandler()},
+			ShutdownTimeout(5*time.Second),
+			MaxConcurrentRequests(2, nil),
+			RejectDuringShutdown(http.StatusServiceUnavailable),
+			ShutdownOnPanic(),
+		)
+		if info.TLS {
+			t.Error("expected TLS to be off")
+		}
+		if len(info.Protocols) != 1 || info.Protocols[0] != "http/1.1" {
+			t.Errorf("unexpected protocols %v", info.Protocols)
+		}
+		if info.ShutdownMode != ShutdownGraceful || info.ShutdownTimeout != 5*time.Second {
+			t.Errorf("unexpected shutdown config: %s %s", info.ShutdownMode, info.ShutdownTimeout)
+		}
+		exp := []string{"panic-recovery", "reject-during-shutdown", "concurrency-limit"}
+		if len(info.Middleware) != len(exp) {
+			t.Fatalf("expected middleware %v, got %v", exp, info.Middleware)
+		}
+		for i := range exp {
+			if info.Middleware[i] != exp[i] {
+				t.Errorf("expected middleware %v, got %v", exp, info.Middleware)
+				break
+			}
+		}
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		info := runServer(t, &http.Server{
+			Handler:   http.NotFoundHandler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		})
+		if !info.TLS {
+			t.Error("expected TLS to be on")
+		}
+		if len(info.Protocols) != 2 || info.Protocols[1] != "h2" {
+			t.Errorf("unexpected protocols %v", info.Protocols)
+		}
+		if info.ShutdownMode != ShutdownImmediate || info.ShutdownTimeout != 0 {
+			t.Errorf("unexpected shutdown config: %s %s", info.ShutdownMode, info.ShutdownTimeout)
+		}
+		if len(info.Middleware) != 0 {
+			t.Errorf("expected no middleware, got %v", info.Middleware)
+		}
+	})
+}
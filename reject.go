@@ -0,0 +1,83 @@
+package httpsrv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// RejectReason describes why the request was rejected, see [OnReject].
+type RejectReason int
+
+const (
+	RejectConcurrencyLimit RejectReason = iota + 1 // request was over the MaxConcurrentRequests limit
+	RejectMaxRequests                              // request was over the MaxRequests limit
+	RejectQueueWait                                // request would wait in the queue longer than MaxQueueWait
+	RejectMaxHeaders                               // request had more header fields than allowed by MaxHeaders
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectConcurrencyLimit:
+		return "concurrency limit"
+	case RejectMaxRequests:
+		return "max requests"
+	case RejectQueueWait:
+		return "queue wait"
+	case RejectMaxHeaders:
+		return "max headers"
+	default:
+		return "RejectReason(" + strconv.Itoa(int(r)) + ")"
+	}
+}
+
+/*
+OnReject sets hook which is called every time request is rejected by one of the limits
+(ie [MaxConcurrentRequests]) with the reason and the remote address of the connection.
+This allows to observe all rejections through single callback for metrics and alerting.
+
+The hook is called synchronously before the rejection response is sent so it should
+return quickly.
+*/
+func OnReject(hook func(reason RejectReason, remote net.Addr)) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cfg.onReject = hook
+		cfg.connInCtx = true
+	}}
+}
+
+// rejected calls the OnReject hook (if assigned).
+func (cfg *serverConf) rejected(r *http.Request, reason RejectReason) {
+	if cfg.onReject == nil {
+		return
+	}
+	var addr net.Addr
+	if c := connFromContext(r.Context()); c != nil {
+		addr = c.RemoteAddr()
+	}
+	cfg.onReject(reason, addr)
+}
+
+type connCtxKey struct{}
+
+// connFromContext returns connection stored into the request context, it is only
+// available when some param has requested it by setting the connInCtx flag.
+func connFromContext(ctx context.Context) net.Conn {
+	c, _ := ctx.Value(connCtxKey{}).(net.Conn)
+	return c
+}
+
+/*
+installConnContextHook wraps the ConnContext of the server so that the connection
+is stored into the context, the ConnContext assigned by user is still called.
+*/
+func installConnContextHook(srv *http.Server) {
+	next := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		return context.WithValue(ctx, connCtxKey{}, c)
+	}
+}
@@ -0,0 +1,74 @@
+package httpsrv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_OnReject(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	type rejection struct {
+		reason RejectReason
+		remote net.Addr
+	}
+	rejections := make(chan rejection, 1)
+	entered, release := make(chan struct{}), make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(entered)
+			<-release
+		})},
+		Listener(ln),
+		MaxConcurrentRequests(1, nil),
+		OnReject(func(reason RejectReason, remote net.Addr) { rejections <- rejection{reason, remote} }),
+	)
+
+	// first request occupies the only slot
+	go http.Get("http://" + ln.Addr().String())
+	<-entered
+	defer close(release)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing server: %v", err)
+	}
+	defer c.Close()
+	if _, err := fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", ln.Addr()); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %s", rsp.Status)
+	}
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("OnReject hook wasn't called")
+	case r := <-rejections:
+		if r.reason != RejectConcurrencyLimit {
+			t.Errorf("unexpected reason %s", r.reason)
+		}
+		if r.remote == nil || r.remote.String() != c.LocalAddr().String() {
+			t.Errorf("expected remote address %s, got %v", c.LocalAddr(), r.remote)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package httpsrv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// restartListenerEnv tells the restarted process the file descriptor of the inherited listener.
+const restartListenerEnv = "HTTPSRV_LISTENER_FD"
+
+/*
+RestartOnSignal enables graceful restart of the process (ie to upgrade the binary without downtime):
+when the signal is received the executable of the process is started again (with the same arguments)
+and the listener is passed to it, then the server is shut down gracefully (according to the params,
+ie [ShutdownTimeout]) and [Run] returns [SignalError]. The new process picks up the inherited listener
+when it calls Run with RestartOnSignal, so connections arriving during the restart are queued on the
+shared socket rather than refused. When sig is nil SIGHUP is used.
+
+The listener must be bound by Run (from the Addr of the server), RestartOnSignal can't be used together
+with the [Listener] param. When starting the new process fails the error is logged and the server keeps
+serving. The new process is not supervised, it's up to it to report (ie [NotifyURL]) that it has started.
+
+Platform limits: passing the listener to another process requires Unix (the restart fails on Windows)
+and the listener must be TCP or Unix socket. The new process is started using [os.Executable] so
+replacing the binary on disk before sending the signal is enough to upgrade it.
+*/
+func RestartOnSignal(sig os.Signal) ServerParam {
+	if sig == nil {
+		sig = syscall.SIGHUP
+	}
+	return serverParam{func(cfg *serverConf) {
+		cfg.restartSig = sig
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) {
+			sigC := make(chan os.Signal, 1)
+			signal.Notify(sigC, sig)
+			go func(ctx context.Context, cancel context.CancelCauseFunc) {
+				defer signal.Stop(sigC)
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-sigC:
+						cfg.signalled(sig)
+						if err := cfg.restart(); err != nil {
+							cfg.logf("httpsrv: restart on signal %s: %v", sig, err)
+							continue
+						}
+						cancel(&SignalError{Signal: sig})
+						return
+					}
+				}
+			}(cfg.runCtx, cfg.stopRun)
+		})
+	}}
+}
+
+// restart starts new instance of the executable passing the server's listener to it.
+func (cfg *serverConf) restart() error {
+	fl, ok := cfg.l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("listener %T can't be passed to another process", cfg.l)
+	}
+	f, err := fl.File()
+	if err != nil {
+		return fmt.Errorf("getting file of the listener: %w", err)
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating the executable: %w", err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// ExtraFiles[0] becomes fd 3 in the new process
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(withoutEnv(os.Environ(), restartListenerEnv), restartListenerEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting new process: %w", err)
+	}
+	// the socket is now shared with the new process, stopping the server must not remove it
+	if ul, ok := cfg.l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return cmd.Process.Release()
+}
+
+/*
+inheritedListener returns the listener passed to the process by [RestartOnSignal] of the
+parent process, nil when the process didn't inherit a listener.
+*/
+func inheritedListener() (net.Listener, error) {
+	v, ok := os.LookupEnv(restartListenerEnv)
+	if !ok {
+		return nil, nil
+	}
+	// so that processes started by this one do not try to use the fd
+	os.Unsetenv(restartListenerEnv)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", restartListenerEnv, v, err)
+	}
+	f := os.NewFile(uintptr(fd), "inherited listener")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("creating listener from inherited fd %d: %w", fd, err)
+	}
+	return l, nil
+}
+
+func withoutEnv(env []string, name string) []string {
+	r := env[:0:0]
+	for _, v := range env {
+		if !strings.HasPrefix(v, name+"=") {
+			r = append(r, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,177 @@
+package httpsrv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_RestartOnSignal(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the server process, the restarted
+	// process inherits the env so it runs the same code with the inherited listener
+	if os.Getenv("HTTPSRV_TEST_RESTART") != "" {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			fmt.Fprint(w, os.Getpid())
+		})
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: handler},
+			RestartOnSignal(nil),
+			ShutdownOnSignal(syscall.SIGTERM),
+			ShutdownTimeout(5*time.Second),
+			OnReady(func(info ReadyInfo) { fmt.Printf("ready %d %s\n", os.Getpid(), info.Addr) }),
+		)
+		fmt.Printf("Run returned %d: %v\n", os.Getpid(), err)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_RestartOnSignal$")
+	cmd.Env = append(os.Environ(), "HTTPSRV_TEST_RESTART=1")
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("creating stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting subprocess: %v", err)
+	}
+	// output of both the old and the new process, the pipe is closed when both have exited
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+	}()
+	// the processes run concurrently so the order of their lines is not known,
+	// waitLine returns the fields of the n-th (counting from zero) line matching the prefix
+	var output []string
+	waitLine := func(prefix string, n int) []string {
+		t.Helper()
+		for i := 0; ; {
+			for ; i < len(output); i++ {
+				if strings.HasPrefix(output[i], prefix) {
+					if n == 0 {
+						return strings.Fields(output[i])
+					}
+					n--
+				}
+			}
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("subprocess exited before %q line", prefix)
+				}
+				output = append(output, line)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timeout waiting for %q line", prefix)
+			}
+		}
+	}
+
+	ready := waitLine("ready ", 0)
+	oldPID, addr := ready[1], "http://"+ready[2]
+
+	// keep sending requests over new connections while the process restarts
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var failed []error
+	served := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				pid, err := get(client, addr)
+				mu.Lock()
+				if err != nil {
+					failed = append(failed, err)
+				} else {
+					served[pid]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("sending SIGHUP: %v", err)
+	}
+	newPID := waitLine("ready ", 1)[1]
+	pid, err := strconv.Atoi(newPID)
+	if err != nil {
+		t.Fatalf("invalid pid %q: %v", newPID, err)
+	}
+	t.Cleanup(func() { syscall.Kill(pid, syscall.SIGKILL) })
+	waitLine("Run returned "+oldPID, 0)
+
+	// keep the load on until the new process has served some requests
+	for i := 0; ; i++ {
+		mu.Lock()
+		n := served[newPID]
+		mu.Unlock()
+		if n > 10 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("new process didn't serve requests")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		t.Fatalf("stopping the new process: %v", err)
+	}
+	waitLine("Run returned "+newPID, 0)
+	for line := range lines {
+		output = append(output, line)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("subprocess failed: %v", err)
+	}
+
+	if len(failed) != 0 {
+		t.Errorf("expected no failed requests, got %d: %v", len(failed), failed[0])
+	}
+	if served[oldPID] == 0 {
+		t.Error("old process didn't serve any requests")
+	}
+	if out := strings.Join(output, "\n"); !strings.Contains(out, "Run returned "+oldPID+": received signal hangup") {
+		t.Errorf("expected old process to be stopped by the signal, got:\n%s", out)
+	}
+}
+
+// get sends GET request to the addr and returns the response body.
+func get(client *http.Client, addr string) (string, error) {
+	rsp, err := client.Get(addr)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return "", err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", rsp.Status)
+	}
+	return string(b), nil
+}
@@ -5,91 +5,250 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"sync"
+	"time"
 )
 
-func runServer(ctx context.Context, start, stop func() error, shutdown chan error) (rerr error) {
-	var m sync.Mutex
-	setReturnErr := func(err error) {
-		m.Lock()
-		defer m.Unlock()
-		if rerr == nil {
-			rerr = err
-		} else if err != nil {
-			rerr = errors.Join(rerr, err)
+/*
+ErrServeGoroutineHung is reported as the serve error of the [RunError] when the server's
+Serve method didn't return within the shutdown timeout (plus small slack) after the server
+was stopped. Run returns rather than blocks forever but the serve goroutine is leaked.
+*/
+var ErrServeGoroutineHung = errors.New("http server didn't exit after it was stopped")
+
+// serveQuitSlack is added to the shutdown timeout when waiting for the Serve to return.
+const serveQuitSlack = 5 * time.Second
+
+/*
+RunError is returned by [Run] when the server exits (after it has been started). Fields
+describe the reasons the server exited, more than one of them may be set when things happen
+(nearly) simultaneously, ie serving fails while the context is cancelled.
+
+RunError supports [errors.Is] and [errors.As] for all the errors it contains.
+*/
+type RunError struct {
+	Panic   error // unhandled panic in a handler, see [ShutdownOnPanic]
+	Context error // cause of the cancellation of the Run's context
+	Serve   error // error returned by the serve func (other than http.ErrServerClosed)
+	Stop    error // error returned while stopping the server
+}
+
+func (e *RunError) Error() string {
+	return errors.Join(e.Unwrap()...).Error()
+}
+
+// Unwrap returns non-nil errors in the order: panic, context, serve, stop.
+func (e *RunError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Panic, e.Context, e.Serve, e.Stop} {
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errs
+}
 
+/*
+runServer starts the server by calling start and blocks until it exits. The server is
+stopped by calling stop when ctx is cancelled or when the start func returns (ie the
+server failed). When error is received from the shutdown channel (unhandled panic in
+a handler) the server is assumed to be already closed and stop is not called.
+
+When several of these happen (nearly) simultaneously all the errors are reported in
+the returned [RunError].
+
+After the server has been stopped runServer waits for the start func to return at most
+for the duration returned by serveWait (nil means wait forever), when it's exceeded the
+serve error is [ErrServeGoroutineHung].
+*/
+func runServer(ctx context.Context, start, stop func() error, shutdown chan error, serveWait func() time.Duration) error {
+	rerr := &RunError{}
+
+	var serveErr error
 	serveQuit := make(chan struct{})
 	go func() {
 		defer close(serveQuit)
 		if err := start(); err != http.ErrServerClosed {
-			setReturnErr(fmt.Errorf("http server exited with error: %w", err))
+			serveErr = fmt.Errorf("http server exited with error: %w", err)
 		}
 	}()
 
 	select {
 	case <-serveQuit:
 	case <-ctx.Done():
-		setReturnErr(ctx.Err())
-	case err := <-shutdown:
-		setReturnErr(err)
-		<-serveQuit
-		return
+	case rerr.Panic = <-shutdown:
+	}
+	// prefer the panic error when it is available at the same time with the ctx cancellation
+	if rerr.Panic == nil {
+		select {
+		case rerr.Panic = <-shutdown:
+		default:
+		}
+	}
+	if ctx.Err() != nil {
+		rerr.Context = context.Cause(ctx)
 	}
 
-	if err := stop(); err != nil {
-		setReturnErr(fmt.Errorf("stopping http server: %w", err))
+	if rerr.Panic == nil {
+		stopDone := make(chan struct{})
+		go func() {
+			defer close(stopDone)
+			if err := stop(); err != nil {
+				rerr.Stop = fmt.Errorf("stopping http server: %w", err)
+			}
+		}()
+		// handler might panic while the server is being stopped
+		select {
+		case <-stopDone:
+		case rerr.Panic = <-shutdown:
+			<-stopDone
+		}
 	}
 
-	<-serveQuit
-	return
+	if serveWait == nil {
+		<-serveQuit
+		rerr.Serve = serveErr
+	} else {
+		select {
+		case <-serveQuit:
+			rerr.Serve = serveErr
+		case <-time.After(serveWait()):
+			rerr.Serve = ErrServeGoroutineHung
+		}
+	}
+	if len(rerr.Unwrap()) == 0 {
+		return nil
+	}
+	return rerr
 }
 
 /*
-Run starts the http server "srv" and blocks until it exits. It always return non-nil error.
-Server is stopped by cancelling the ctx.
+Run starts the http server "srv" and blocks until it exits. It always return non-nil error
+(unless [ReturnNilOnCleanShutdown] is used), after the server has been started it is [*RunError].
+Server is stopped by cancelling the ctx. The error wraps the context's error, so it has to be
+inspected using [errors.Is] (ie errors.Is(err, context.Canceled)) or [errors.As], not compared.
 
 The srv parameter must have Addr and Handler fields assigned unless [Listener] and [Endpoints]
 parameters are used to provide respective values.
 */
-func Run(ctx context.Context, srv *http.Server, params ...ServerParam) error {
+func Run(ctx context.Context, srv *http.Server, params ...ServerParam) (err error) {
 	cfg := serverConf{srv: srv}
 	for _, p := range params {
 		p.apply(&cfg)
 	}
+	defer func() {
+		for _, f := range cfg.onExit {
+			f(err)
+		}
+	}()
 	if err := cfg.validate(); err != nil {
 		return err
 	}
 
+	cfg.shutdownSignal = make(chan struct{})
+	cfg.baseContext = append(cfg.baseContext, cfg.shutdownSignalContext)
+
+	installConnStateHooks(cfg.srv, cfg.connState)
+	installBaseContextHooks(cfg.srv, cfg.baseContext)
+	if cfg.connInCtx {
+		installConnContextHook(cfg.srv)
+	}
+	h := cfg.wrapHandler(cfg.srv.Handler)
+	if err := cfg.validateRoutes(h); err != nil {
+		return err
+	}
+	cfg.srv.Handler = h
+	cfg.setupTLS()
+
 	var shutdown chan error
 	if cfg.dieOnPanic {
-		shutdown = installDieOnPanicHandler(cfg.srv)
+		shutdown = installDieOnPanicHandler(cfg.srv, cfg.ignorePanic)
+		cfg.middleware = append(cfg.middleware, "panic-recovery")
 	}
 
-	return runServer(
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	cfg.runCtx, cfg.stopRun = ctx, cancel
+	for _, w := range cfg.watchers {
+		go w(ctx, cancel)
+	}
+
+	err = runServer(
 		ctx,
-		cfg.startFunc(),
+		cfg.startFunc(ctx),
 		cfg.stopFunc(),
 		shutdown,
+		func() time.Duration { return cfg.stopTimeout + serveQuitSlack },
 	)
+	if re, ok := err.(*RunError); ok && re.Panic != nil {
+		cfg.emitPanicClose()
+	}
+	if cfg.notifySig != nil {
+		cfg.notifyShutdownComplete()
+	}
+	if cfg.nilOnClean && isCleanShutdown(err) {
+		return nil
+	}
+	return err
 }
 
-func installDieOnPanicHandler(srv *http.Server) chan error {
-	done := make(chan error)
-	next := srv.Handler
-	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if r := recover(); r != nil {
-				if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
-					return
-				}
-				done <- fmt.Errorf("unhandled panic: %v", r)
-				srv.Close()
-			}
-		}()
+/*
+ReturnNilOnCleanShutdown makes [Run] to return nil instead of [*RunError] when the server was
+stopped cleanly, ie the only reason it exited is that it's context was cancelled (with
+[context.Canceled], [SignalError] or the cause set by [os/signal.NotifyContext]) and stopping
+the server succeeded. This intentionally deviates from the "always returns non-nil error" contract of Run so that
 
-		next.ServeHTTP(w, r)
+	if err := httpsrv.Run(ctx, srv, httpsrv.ReturnNilOnCleanShutdown()); err != nil {
+		os.Exit(1)
+	}
+
+works intuitively. Shutdown because of a custom cause (ie [ErrMemoryPressure]) is not clean.
+*/
+func ReturnNilOnCleanShutdown() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.nilOnClean = true }}
+}
+
+func isCleanShutdown(err error) bool {
+	re, ok := err.(*RunError)
+	if !ok || re.Panic != nil || re.Serve != nil || re.Stop != nil {
+		return false
+	}
+	var sigErr *SignalError
+	return errors.Is(re.Context, context.Canceled) || errors.As(re.Context, &sigErr)
+}
+
+/*
+RunInGroup adds the server as member of the group g (typically *errgroup.Group from the
+golang.org/x/sync/errgroup package) and returns immediately, it is shorthand for
+
+	g.Go(func() error { return httpsrv.Run(ctx, srv, params...) })
+
+where ctx usually is the context returned by errgroup.WithContext so that the server is
+stopped when any other member of the group fails. Params are copied so caller may reuse
+the slice after the call.
+*/
+func RunInGroup(g interface{ Go(func() error) }, ctx context.Context, srv *http.Server, params ...ServerParam) {
+	params = append([]ServerParam(nil), params...)
+	g.Go(func() error { return Run(ctx, srv, params...) })
+}
+
+/*
+installDieOnPanicHandler wraps the server's handler so that unhandled panic closes the server.
+The first panic wins - it's error is sent to the returned channel (buffered so that the handler
+never blocks) while panics happening after that only close the (already closing) server.
+*/
+func installDieOnPanicHandler(srv *http.Server, ignore []func(any) bool) chan error {
+	done := make(chan error, 1)
+	srv.Handler = WithRecovery(srv.Handler, func(v any) {
+		for _, f := range ignore {
+			if f(v) {
+				return
+			}
+		}
+		select {
+		case done <- fmt.Errorf("unhandled panic: %v", v):
+		default:
+		}
+		srv.Close()
 	})
 	return done
 }
@@ -1,13 +1,19 @@
 package httpsrv
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -69,6 +75,27 @@ func Test_Run(t *testing.T) {
 		}
 	})
 
+	t.Run("listener is already closed", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ln.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(context.Background(), &http.Server{Handler: http.NotFoundHandler()}, Listener(ln))
+		}()
+
+		select {
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-done:
+			expectError(t, err, net.ErrClosed)
+			expectError(t, err, fmt.Sprintf("http server exited with error: listener was closed while the server was running: accept tcp %s: use of closed network connection", ln.Addr()))
+		}
+	})
+
 	listenerAndGetFunc := func(t *testing.T) (net.Listener, func(path string) (*http.Response, error)) {
 		t.Helper()
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -130,7 +157,11 @@ func Test_Run(t *testing.T) {
 			expectError(t, err, context.Canceled)
 			// request exceeded shutdown timeout, server should log error
 			expectError(t, err, context.DeadlineExceeded)
-			expectError(t, err, `stopping http server: context deadline exceeded`)
+			expectError(t, err, `stopping http server: graceful shutdown: context deadline exceeded`)
+			var se *StopError
+			if !errors.As(err, &se) || se.Mode != ShutdownGraceful {
+				t.Errorf("expected StopError with graceful mode, got %#v", se)
+			}
 		}
 
 		select {
@@ -344,6 +375,136 @@ func Test_Run(t *testing.T) {
 			t.Error("unexpectedly there is something in the error log:\n", s)
 		}
 	})
+
+	t.Run("using panic handler, custom ignored panic doesn't stop the server", func(t *testing.T) {
+		ln, doGet := listenerAndGetFunc(t)
+		defer ln.Close()
+
+		logBuf := &strings.Builder{}
+		errClientGone := errors.New("client gone")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		srvErr := make(chan error, 1)
+		go func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/gone", func(w http.ResponseWriter, req *http.Request) { panic(errClientGone) })
+			mux.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) { panic("foobar") })
+			mux.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) { fmt.Fprintf(w, "hello, world") })
+			srvErr <- Run(ctx,
+				&http.Server{
+					WriteTimeout: 5 * time.Second,
+					Handler:      mux,
+					ErrorLog:     log.New(logBuf, "", log.LstdFlags),
+				},
+				ShutdownOnPanic(),
+				IgnorePanics(func(r any) bool { return r == errClientGone }),
+				ShutdownTimeout(time.Second),
+				Listener(ln),
+			)
+		}()
+
+		err := queryServer(doGet, "gone")
+		expectError(t, err, "got response from server: 500 Internal Server Error")
+		err = queryServer(doGet, "hello")
+		expectError(t, err, "got response from server: 200 OK")
+
+		// panic with value not ignored by the predicate still stops the server
+		err = queryServer(doGet, "panic")
+		expectError(t, err, fmt.Sprintf("Get \"http://%s/panic\": EOF", ln.Addr().String()))
+
+		select {
+		case <-time.After(3 * time.Second):
+			t.Error("runServer didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, "unhandled panic: foobar")
+		}
+
+		if s := logBuf.String(); s != "" {
+			t.Error("unexpectedly there is something in the error log:\n", s)
+		}
+	})
+}
+
+func Test_RunInGroup(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	g, ctx := newTestGroup(context.Background())
+	params := []ServerParam{Listener(ln)}
+	RunInGroup(g, ctx, &http.Server{Handler: http.NotFoundHandler()}, params...)
+	// modifying the slice after the call must not affect the server
+	params[0] = Endpoints(nil)
+
+	rsp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("GET request failed: %v", err)
+	}
+	rsp.Body.Close()
+
+	// failing group member stops the server
+	workerErr := errors.New("worker failed")
+	g.Go(func() error { return workerErr })
+
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("group didn't exit within timeout")
+	case err := <-done:
+		if err != workerErr {
+			t.Errorf("expected group error to be %v, got %v", workerErr, err)
+		}
+	}
+	if err := g.errs[0]; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected server to be stopped by group context, got %v", err)
+	}
+}
+
+// testGroup mimics golang.org/x/sync/errgroup.Group, it also records errors
+// of all the members in the order the members were added.
+type testGroup struct {
+	wg     sync.WaitGroup
+	m      sync.Mutex
+	cancel context.CancelFunc
+	errs   []error
+	err    error
+}
+
+func newTestGroup(ctx context.Context) (*testGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &testGroup{cancel: cancel}, ctx
+}
+
+func (g *testGroup) Go(f func() error) {
+	g.m.Lock()
+	idx := len(g.errs)
+	g.errs = append(g.errs, nil)
+	g.m.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := f()
+		g.m.Lock()
+		defer g.m.Unlock()
+		g.errs[idx] = err
+		if err != nil && g.err == nil {
+			g.err = err
+			g.cancel()
+		}
+	}()
+}
+
+func (g *testGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
 }
 
 func Test_runServer(t *testing.T) {
@@ -356,6 +517,7 @@ func Test_runServer(t *testing.T) {
 			func() error { return fmt.Errorf("failed to start") },
 			func() error { stopCalled = true; return nil },
 			nil,
+			nil,
 		)
 		expectError(t, err, "http server exited with error: failed to start")
 
@@ -376,6 +538,7 @@ func Test_runServer(t *testing.T) {
 				func() error { <-ctx.Done(); return expErr },
 				func() error { stopCalled = true; return nil },
 				nil,
+				nil,
 			)
 		}()
 
@@ -407,6 +570,7 @@ func Test_runServer(t *testing.T) {
 				func() error { <-ctx.Done(); return http.ErrServerClosed },
 				func() error { stopCalled = true; return expErr },
 				nil,
+				nil,
 			)
 		}()
 
@@ -437,6 +601,7 @@ func Test_runServer(t *testing.T) {
 				func() error { <-ctx.Done(); return startErr },
 				func() error { return stopErr },
 				nil,
+				nil,
 			)
 		}()
 
@@ -451,7 +616,24 @@ func Test_runServer(t *testing.T) {
 			// "http server exited with error: error from start"
 			expectError(t, err, startErr)
 			// "stopping http server: error from stop"
-			expectError(t, err, startErr)
+			expectError(t, err, stopErr)
+
+			var re *RunError
+			if !errors.As(err, &re) {
+				t.Fatalf("expected RunError, got %T", err)
+			}
+			if re.Context != context.Canceled {
+				t.Errorf("unexpected Context error: %v", re.Context)
+			}
+			if !errors.Is(re.Serve, startErr) {
+				t.Errorf("unexpected Serve error: %v", re.Serve)
+			}
+			if !errors.Is(re.Stop, stopErr) {
+				t.Errorf("unexpected Stop error: %v", re.Stop)
+			}
+			if re.Panic != nil {
+				t.Errorf("unexpected Panic error: %v", re.Panic)
+			}
 		}
 	})
 
@@ -467,6 +649,7 @@ func Test_runServer(t *testing.T) {
 				func() error { <-ctx.Done(); return http.ErrServerClosed },
 				func() error { stopCalled = true; return nil },
 				shutdownCh,
+				nil,
 			)
 		}()
 
@@ -486,6 +669,59 @@ func Test_runServer(t *testing.T) {
 		}
 	})
 
+	t.Run("panic and context cancellation at the same time", func(t *testing.T) {
+		sdErr := fmt.Errorf("unhandled panic: oops")
+		// select picks ready case randomly so repeat to make sure the order is stable
+		for i := 0; i < 50; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			shutdownCh := make(chan error, 1)
+			shutdownCh <- sdErr
+			stopCalled := false
+
+			err := runServer(ctx,
+				func() error { <-ctx.Done(); return http.ErrServerClosed },
+				func() error { stopCalled = true; return nil },
+				shutdownCh,
+				nil,
+			)
+			errs, ok := err.(interface{ Unwrap() []error })
+			if !ok {
+				t.Fatalf("expected joined error, got %T: %v", err, err)
+			}
+			if e := errs.Unwrap(); len(e) != 2 || e[0] != sdErr || e[1] != context.Canceled {
+				t.Fatalf("unexpected errors (attempt %d): %v", i, e)
+			}
+			if stopCalled {
+				t.Fatal("unexpectedly the stop func was called")
+			}
+		}
+	})
+
+	t.Run("panic while stopping", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		sdErr := fmt.Errorf("unhandled panic: oops")
+		shutdownCh := make(chan error)
+
+		err := runServer(ctx,
+			func() error { <-ctx.Done(); return http.ErrServerClosed },
+			func() error { shutdownCh <- sdErr; return nil },
+			shutdownCh,
+			nil,
+		)
+		errs, ok := err.(interface{ Unwrap() []error })
+		if !ok {
+			t.Fatalf("expected joined error, got %T: %v", err, err)
+		}
+		if e := errs.Unwrap(); len(e) != 2 || e[0] != sdErr || e[1] != context.Canceled {
+			t.Errorf("unexpected errors: %v", e)
+		}
+		if re := err.(*RunError); re.Panic != sdErr || re.Context != context.Canceled || re.Serve != nil || re.Stop != nil {
+			t.Errorf("unexpected RunError fields: %#v", re)
+		}
+	})
+
 	t.Run("no errors to log", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		stopCalled := false
@@ -497,6 +733,7 @@ func Test_runServer(t *testing.T) {
 				func() error { <-ctx.Done(); return http.ErrServerClosed },
 				func() error { stopCalled = true; return nil },
 				make(chan error),
+				nil,
 			)
 		}()
 
@@ -513,6 +750,195 @@ func Test_runServer(t *testing.T) {
 			t.Error("unexpectedly the stop func hasn't been called")
 		}
 	})
+
+	t.Run("serve func doesn't return after stop", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		hung := make(chan struct{})
+		defer close(hung)
+		done := make(chan error, 1)
+		go func() {
+			done <- runServer(ctx,
+				func() error { <-hung; return http.ErrServerClosed },
+				func() error { return nil },
+				nil,
+				func() time.Duration { return 50 * time.Millisecond },
+			)
+		}()
+
+		select {
+		case <-time.After(time.Second):
+			t.Error("runServer didn't return within timeout")
+		case err := <-done:
+			expectError(t, err, context.Canceled)
+			expectError(t, err, ErrServeGoroutineHung)
+		}
+	})
+}
+
+func Test_ReturnNilOnCleanShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}, ReturnNilOnCleanShutdown())
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		select {
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		}
+	})
+
+	t.Run("server fails to start", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		defer ln.Close()
+
+		err = Run(context.Background(), &http.Server{Addr: ln.Addr().String(), Handler: http.NotFoundHandler()}, ReturnNilOnCleanShutdown())
+		if err == nil {
+			t.Error("expected non-nil error")
+		}
+	})
+
+	t.Run("context is cancelled with custom cause", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrMemoryPressure)
+		err := Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}, ReturnNilOnCleanShutdown())
+		expectError(t, err, ErrMemoryPressure)
+	})
+}
+
+func Test_installDieOnPanicHandler(t *testing.T) {
+	t.Parallel()
+
+	const N = 10
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("oops") })}
+	shutdown := installDieOnPanicHandler(srv, nil)
+
+	// handlers panicking simultaneously must not block
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("panicking handlers didn't return within timeout")
+	case <-done:
+	}
+
+	// first panic wins
+	select {
+	case err := <-shutdown:
+		expectError(t, err, "unhandled panic: oops")
+	default:
+		t.Fatal("expected panic error to be sent")
+	}
+	select {
+	case err := <-shutdown:
+		t.Errorf("expected single panic error, got another one: %v", err)
+	default:
+	}
+}
+
+func Test_NotifyOnShutdownComplete(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the supervisor process
+	// waiting for the notification from the server
+	if os.Getenv("HTTPSRV_TEST_NOTIFY") != "" {
+		sigC := make(chan os.Signal, 1)
+		signal.Notify(sigC, os.Interrupt)
+		fmt.Println("waiting")
+		select {
+		case sig := <-sigC:
+			fmt.Println("notified:", sig)
+		case <-time.After(10 * time.Second):
+			fmt.Println("timeout")
+		}
+		return
+	}
+
+	t.Run("invalid pid", func(t *testing.T) {
+		err := Run(context.Background(),
+			&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			NotifyOnShutdownComplete(0, os.Interrupt),
+		)
+		expectError(t, err, errInvalidNotifyPID)
+	})
+
+	t.Run("signal is sent after shutdown", func(t *testing.T) {
+		cmd := exec.Command(os.Args[0], "-test.run=^Test_NotifyOnShutdownComplete$")
+		cmd.Env = append(os.Environ(), "HTTPSRV_TEST_NOTIFY=1")
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatalf("creating stdout pipe: %v", err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("starting subprocess: %v", err)
+		}
+		lines := make(chan string, 10)
+		go func() {
+			defer close(lines)
+			s := bufio.NewScanner(stdout)
+			for s.Scan() {
+				lines <- s.Text()
+			}
+		}()
+		defer func() {
+			cmd.Process.Kill()
+			for range lines {
+			}
+			cmd.Wait()
+		}()
+		// the signal handler of the supervisor must be registered before it is notified
+		if line := <-lines; line != "waiting" {
+			t.Fatalf("unexpected output of the subprocess: %q", line)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+				NotifyOnShutdownComplete(cmd.Process.Pid, os.Interrupt),
+			)
+		}()
+
+		select {
+		case line := <-lines:
+			t.Fatalf("signal was sent before the server was stopped: %q", line)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		cancel()
+		expectError(t, <-srvErr, context.Canceled)
+		select {
+		case <-time.After(time.Second):
+			t.Error("signal wasn't received within timeout")
+		case line := <-lines:
+			if line != "notified: interrupt" {
+				t.Errorf("unexpected output of the subprocess: %q", line)
+			}
+		}
+	})
 }
 
 func expectError(t *testing.T, err error, expect any) {
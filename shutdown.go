@@ -0,0 +1,505 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+RequestDeadlineOnShutdown makes the contexts of all in-flight requests to get deadline
+equal to the remaining grace budget (see [ShutdownTimeout]) when the shutdown begins.
+Handlers respecting context cancellation will then wind down in time instead of being
+cut off when connections are closed. When the deadline passes [context.Cause] of the
+request context returns [context.DeadlineExceeded]. When no shutdown timeout is set request
+contexts are cancelled as soon as the shutdown begins.
+
+Request contexts are derived from the [http.Server.BaseContext] when it is assigned.
+*/
+func RequestDeadlineOnShutdown() ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		rd := &requestDeadlines{}
+		cfg.baseContext = append(cfg.baseContext, rd.baseContext)
+		cfg.onShutdown = append(cfg.onShutdown, rd.setDeadline)
+	}}
+}
+
+/*
+RejectDuringShutdown makes the server to respond with given status to all requests arriving
+after the graceful shutdown (or close) of the server has started, instead of serving them. The
+requests arriving during the [ShutdownDelay] (and [WaitForDeregistration]) are still served as
+the point of that period is to keep serving while the load balancer catches up. Requests may
+still arrive on existing keep-alive connections (ie while shutdown hooks run), rejecting them
+with "Connection: close" gives clients a clean signal to retry elsewhere. Typically status 503
+(Service Unavailable) would be used.
+*/
+func RejectDuringShutdown(status int) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.shutdownStatus = status }}
+}
+
+/*
+ProbePaths sets paths which are exempt from the [RejectDuringShutdown] treatment, requests to them
+are still served during the shutdown (and during the [ScheduledMaintenance] window). This way the
+health probes keep being answered by the real handler (so the orchestrator sees ie failing
+readiness) instead of being rejected together with the business endpoints, which could be
+misinterpreted. Paths of the [ReadinessEndpoint] and [LivenessEndpoint] are always exempt, they
+do not need to be listed. The paths must match the request's URL path exactly.
+
+Parameter can be used multiple times, the paths are accumulated.
+*/
+func ProbePaths(paths ...string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.probePaths = append(cfg.probePaths, paths...) }}
+}
+
+/*
+MaintenancePageOnShutdown makes the server to respond to requests arriving after the graceful
+shutdown has started (like [RejectDuringShutdown] with status 503) with user friendly response:
+browsers (GET requests accepting "text/html") get the html page while other clients get JSON
+object {"error":"shutting down"}. The Retry-After header tells the clients to retry once the
+instance has been drained (the [ShutdownTimeout], at least one second), by then the traffic
+should be routed to other (new) instances. Requests to the [ProbePaths] are served
+normally.
+
+When RejectDuringShutdown is used after MaintenancePageOnShutdown it's status is used instead of 503.
+*/
+func MaintenancePageOnShutdown(html []byte) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.shutdownStatus, cfg.shutdownPage = http.StatusServiceUnavailable, html }}
+}
+
+func (cfg *serverConf) rejectDuringShutdown(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.stopping.Load() && !cfg.isProbe(r) {
+			w.Header().Set("Connection", "close")
+			if cfg.shutdownPage != nil {
+				cfg.shuttingDownPage(w, r)
+			} else {
+				http.Error(w, http.StatusText(cfg.shutdownStatus), cfg.shutdownStatus)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shuttingDownPage responds with the maintenance page or JSON, depending on the Accept header.
+func (cfg *serverConf) shuttingDownPage(w http.ResponseWriter, r *http.Request) {
+	drain := max(cfg.stopTimeout, time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((drain+time.Second-1)/time.Second), 10))
+	w.Header().Set("Cache-Control", "no-store")
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && acceptsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(cfg.shutdownStatus)
+		w.Write(cfg.shutdownPage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(cfg.shutdownStatus)
+	w.Write([]byte(`{"error":"shutting down"}`))
+}
+
+// acceptsHTML reports whether the Accept header of the request lists HTML.
+func acceptsHTML(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, s := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(s); err == nil && (mt == "text/html" || mt == "application/xhtml+xml") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+CloseConnectionsOnShutdown makes the server to add "Connection: close" header to the responses
+of HTTP/1 requests arriving after the shutdown has begun, so the connection is closed after the
+response has been sent. Combined with [ShutdownDelay] this actively moves keep-alive clients
+(and reverse proxies) off the instance during the lame-duck period, prompting them to reconnect
+elsewhere while the requests are still served normally.
+*/
+func CloseConnectionsOnShutdown() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.closeConns = true }}
+}
+
+func (cfg *serverConf) closeConnectionsOnShutdown(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 1 && cfg.shuttingDown.Load() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type shutdownSignalKey struct{}
+
+/*
+ShutdownSignal returns channel which is closed when the shutdown of the server begins (before the
+[ShutdownDelay]), the ctx must be the context of the request served by [Run]. This allows handlers
+blocked on a long poll (or streaming) to return promptly and free the connection instead of being
+cut off when the graceful shutdown times out:
+
+	select {
+	case msg := <-messages:
+		json.NewEncoder(w).Encode(msg)
+	case <-httpsrv.ShutdownSignal(r.Context()):
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+
+When ctx doesn't come from request served by Run nil channel is returned, ie it never closes.
+*/
+func ShutdownSignal(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(shutdownSignalKey{}).(chan struct{})
+	return ch
+}
+
+func (cfg *serverConf) shutdownSignalContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shutdownSignalKey{}, cfg.shutdownSignal)
+}
+
+/*
+PropagateRunContext makes the contexts of the requests to be cancelled when the context passed
+to [Run] is cancelled (or Run is stopped by a param), with the same cause. By default request
+contexts are not derived from Run's context, the graceful shutdown lets in-flight requests to
+complete and only cancels the contexts of the requests still running when the connections are
+closed. With this param the handlers see the cancellation as soon as the shutdown begins (also
+the requests served during the [ShutdownDelay]), so context-aware operations are aborted instead
+of being allowed to finish. The graceful shutdown still waits for the handlers to return.
+
+Only the cancellation is propagated, values of the Run's context are not visible to the handlers.
+*/
+func PropagateRunContext() ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cfg.baseContext = append(cfg.baseContext, func(ctx context.Context) context.Context {
+			ctx, cancel := context.WithCancelCause(ctx)
+			context.AfterFunc(cfg.runCtx, func() { cancel(context.Cause(cfg.runCtx)) })
+			return ctx
+		})
+	}}
+}
+
+/*
+ShutdownDelay makes the server to keep serving requests for the given duration after the
+shutdown begins (ie Run's context is cancelled) before the graceful shutdown is started
+("lame-duck" period). Combined with [ReadinessEndpoint] this allows load balancer to notice
+that the instance is going away and stop sending traffic to it before the server stops
+accepting connections.
+
+The delay is not part of the [ShutdownTimeout] budget.
+*/
+func ShutdownDelay(delay time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.shutdownDelay = delay }}
+}
+
+/*
+WaitForDeregistration makes the server to keep serving requests after the shutdown begins until
+the probe reports that the instance has been deregistered from the load balancer (or timeout
+has elapsed), only then the graceful shutdown is started. This makes the lame-duck period
+adaptive instead of the fixed [ShutdownDelay] (when both are used the wait starts after the
+delay). The [ReadinessEndpoint] fails from the beginning of the shutdown.
+
+The probe is called every 100ms, it should observe the load balancer's state (ie query the
+registered targets) and return true once the instance is no longer receiving traffic.
+*/
+func WaitForDeregistration(probe func() bool, timeout time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.deregistered, cfg.deregisterTO = probe, timeout }}
+}
+
+// waitForDeregistration blocks until the deregistered probe returns true or timeout elapses.
+func (cfg *serverConf) waitForDeregistration() {
+	timeout, stop := cfg.clk().After(cfg.deregisterTO)
+	defer stop()
+
+	for !cfg.deregistered() {
+		tick, stopTick := cfg.clk().After(100 * time.Millisecond)
+		select {
+		case <-timeout:
+			stopTick()
+			cfg.logf("httpsrv: instance wasn't deregistered within %s, shutting down anyway", cfg.deregisterTO)
+			return
+		case <-tick:
+		}
+	}
+}
+
+/*
+ShutdownGuard sets guard which can veto (postpone) the shutdown while a critical operation (ie a
+financial transaction) is in progress, so that routine deploys do not interrupt it. When the
+shutdown begins the guard is called before anything else happens (the server keeps serving
+normally, readiness doesn't fail), as long as it returns false the server waits and re-checks
+with backoff (starting from 100ms, doubling up to 5s) until the guard returns true or maxWait has
+elapsed, then the shutdown proceeds. The reason returned alongside false is logged using the
+server's ErrorLog.
+
+The wait is not part of the [ShutdownTimeout] budget.
+*/
+func ShutdownGuard(guard func() (ok bool, reason string), maxWait time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.guard, cfg.guardMaxWait = guard, maxWait }}
+}
+
+// waitForGuard blocks until the shutdown guard allows the shutdown or guardMaxWait elapses.
+func (cfg *serverConf) waitForGuard() {
+	timeout, stop := cfg.clk().After(cfg.guardMaxWait)
+	defer stop()
+
+	backoff := 100 * time.Millisecond
+	for {
+		ok, reason := cfg.guard()
+		if ok {
+			return
+		}
+		cfg.logf("httpsrv: shutdown postponed by the guard: %s", reason)
+		retry, stopRetry := cfg.clk().After(backoff)
+		select {
+		case <-timeout:
+			stopRetry()
+			cfg.logf("httpsrv: shutdown guard didn't allow shutdown within %s, shutting down anyway", cfg.guardMaxWait)
+			return
+		case <-retry:
+			backoff = min(2*backoff, 5*time.Second)
+		}
+	}
+}
+
+/*
+ReadinessEndpoint makes the server to respond to requests to the path with status 200 (OK)
+while serving normally and with status 503 (Service Unavailable) once the shutdown has begun
+or while the server is not serving because of [ReadyWhen], [Warmup] or [ServeWhenLeader]. Requests
+to the path are not passed to the server's handler. Empty path disables the endpoint.
+*/
+func ReadinessEndpoint(path string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.readinessPath = path }}
+}
+
+/*
+LivenessEndpoint makes the server to respond to requests to the path with status 200 (OK) until
+the server stops accepting connections, unlike [ReadinessEndpoint] it keeps reporting success
+during the [ShutdownDelay]. This way the orchestrator removes the instance from the load balancer
+(readiness fails) but doesn't kill it (liveness succeeds) while it drains. Once the graceful
+shutdown (or close) of the server has started the endpoint responds with status 503 (Service
+Unavailable). Requests to the path are not passed to the server's handler. Empty path disables
+the endpoint.
+*/
+func LivenessEndpoint(path string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.livenessPath = path }}
+}
+
+func (cfg *serverConf) livenessEndpoint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != cfg.livenessPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cfg.stopping.Load() {
+			http.Error(w, "stopping", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+/*
+readyToServe reports whether the gates ([ReadyWhen], [Warmup], [ServeWhenLeader]) let the
+requests through, the probe requests are exempt from the gates so the readiness endpoint
+must check them.
+*/
+func (cfg *serverConf) readyToServe() bool {
+	switch {
+	case cfg.readyGate != nil && !cfg.readyGate.ready.Load():
+		return false
+	case cfg.warmup != nil && cfg.warmup.reject && !cfg.warmup.done.Load():
+		return false
+	case cfg.isLeader != nil && !cfg.isLeader():
+		return false
+	}
+	return true
+}
+
+// isProbe reports whether the request is for the path of health probe or it is
+// the route probe sent by the RequireRoutes validation.
+func (cfg *serverConf) isProbe(r *http.Request) bool {
+	p := r.URL.Path
+	return r.Context().Value(routeProbeKey{}) != nil ||
+		(cfg.livenessPath != "" && p == cfg.livenessPath) ||
+		(cfg.readinessPath != "" && p == cfg.readinessPath) ||
+		slices.Contains(cfg.probePaths, p)
+}
+
+func (cfg *serverConf) readinessEndpoint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != cfg.readinessPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cfg.shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if !cfg.readyToServe() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+/*
+Drainable is implemented by types managing long-lived connections the server can't close
+gracefully by itself, ie hijacked WebSocket connections which should be sent close frame
+before the process exits.
+*/
+type Drainable interface {
+	// Drain is called when the shutdown of the server begins, it should wind down the
+	// connections and return when done or when ctx is cancelled.
+	Drain(ctx context.Context) error
+}
+
+/*
+RegisterDrainable adds d to the list of drainables which are drained (concurrently) alongside
+stopping the server, ie while [http.Server.Shutdown] waits for the in-flight requests. The ctx
+passed to the Drain method carries the deadline of the graceful shutdown (see [ShutdownTimeout]),
+when no shutdown timeout is set the ctx has no deadline. Stopping the server completes when all
+the drainables have returned, errors returned by them are joined into the error returned by [Run].
+
+Parameter can be used multiple times to register multiple drainables.
+*/
+func RegisterDrainable(d Drainable) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.drainables = append(cfg.drainables, d) }}
+}
+
+/*
+WaitOnShutdown makes the server to wait for the wg (ie counting background goroutines started
+by handlers) when stopping, alongside waiting for the in-flight requests. The wait is bounded
+by the graceful shutdown deadline (see [ShutdownTimeout]), when the deadline is hit before the
+wg clears the error returned by [Run] wraps [context.DeadlineExceeded]. When no shutdown timeout
+is set the wait is unbounded.
+
+It is the simplest form of draining background work, see [RegisterDrainable] for more control.
+*/
+func WaitOnShutdown(wg *sync.WaitGroup) ServerParam {
+	return RegisterDrainable(waitGroup{wg})
+}
+
+type waitGroup struct{ wg *sync.WaitGroup }
+
+func (wg waitGroup) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wg.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait group didn't clear: %w", ctx.Err())
+	}
+}
+
+/*
+drainAll launches Drain of all the registered drainables, returned channel receives
+joined errors of them once all the drainables have returned.
+*/
+func (cfg *serverConf) drainAll(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+	if len(cfg.drainables) == 0 {
+		done <- nil
+		return done
+	}
+
+	errs := make([]error, len(cfg.drainables))
+	var wg sync.WaitGroup
+	for i, d := range cfg.drainables {
+		wg.Add(1)
+		go func(i int, d Drainable) {
+			defer wg.Done()
+			if err := d.Drain(ctx); err != nil {
+				errs[i] = fmt.Errorf("draining %T: %w", d, err)
+			}
+		}(i, d)
+	}
+	go func() {
+		wg.Wait()
+		done <- errors.Join(errs...)
+	}()
+	return done
+}
+
+type requestDeadlines struct {
+	m    sync.Mutex
+	ctxs []*shutdownDeadlineCtx
+}
+
+func (rd *requestDeadlines) baseContext(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	sdc := &shutdownDeadlineCtx{Context: ctx, cancel: cancel}
+
+	rd.m.Lock()
+	defer rd.m.Unlock()
+	rd.ctxs = append(rd.ctxs, sdc)
+	return sdc
+}
+
+func (rd *requestDeadlines) setDeadline(timeout time.Duration) {
+	rd.m.Lock()
+	defer rd.m.Unlock()
+	for _, c := range rd.ctxs {
+		c.setDeadline(timeout)
+	}
+}
+
+/*
+shutdownDeadlineCtx is a context which gets deadline assigned after it has been created.
+*/
+type shutdownDeadlineCtx struct {
+	context.Context
+	cancel   context.CancelCauseFunc
+	deadline atomic.Pointer[time.Time]
+}
+
+func (c *shutdownDeadlineCtx) setDeadline(timeout time.Duration) {
+	d := time.Now().Add(timeout)
+	c.deadline.Store(&d)
+	time.AfterFunc(timeout, func() { c.cancel(context.DeadlineExceeded) })
+}
+
+func (c *shutdownDeadlineCtx) Deadline() (time.Time, bool) {
+	d := c.deadline.Load()
+	if pd, ok := c.Context.Deadline(); ok && (d == nil || pd.Before(*d)) {
+		return pd, true
+	}
+	if d == nil {
+		return time.Time{}, false
+	}
+	return *d, true
+}
+
+/*
+installBaseContextHooks wraps the BaseContext of the server so that the contexts
+are passed through hooks registered by params, including the BaseContext assigned by user.
+*/
+func installBaseContextHooks(srv *http.Server, hooks []func(context.Context) context.Context) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	next := srv.BaseContext
+	srv.BaseContext = func(l net.Listener) context.Context {
+		ctx := context.Background()
+		if next != nil {
+			ctx = next(l)
+		}
+		for _, f := range hooks {
+			ctx = f(ctx)
+		}
+		return ctx
+	}
+}
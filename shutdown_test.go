@@ -0,0 +1,843 @@
+package httpsrv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RequestDeadlineOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		hadDeadline bool // did request ctx have deadline before shutdown
+		deadline    time.Time
+		err         error
+		took        time.Duration
+	}
+	inHandler := make(chan struct{})
+	handlerDone := make(chan result, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		res := result{}
+		_, res.hadDeadline = r.Context().Deadline()
+		close(inHandler)
+		// context aware slow handler
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+		res.deadline, _ = r.Context().Deadline()
+		res.err = context.Cause(r.Context())
+		res.took = time.Since(start)
+		handlerDone <- res
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Handler: handler},
+			Listener(ln),
+			ShutdownTimeout(300*time.Millisecond),
+			RequestDeadlineOnShutdown(),
+		)
+	}()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-inHandler
+	shutdownStart := time.Now()
+	cancel()
+
+	select {
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler didn't return within timeout")
+	case res := <-handlerDone:
+		if res.hadDeadline {
+			t.Error("expected request context not to have deadline before shutdown")
+		}
+		if res.err != context.DeadlineExceeded {
+			t.Errorf("expected context.DeadlineExceeded, got %v", res.err)
+		}
+		if d := res.deadline.Sub(shutdownStart); d < 250*time.Millisecond || d > 350*time.Millisecond {
+			t.Errorf("expected deadline to be ~300ms after shutdown start, got %s", d)
+		}
+		if res.took > time.Second {
+			t.Errorf("handler took %s", res.took)
+		}
+	}
+
+	select {
+	case <-time.After(3 * time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+}
+
+func Test_RejectDuringShutdown(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	// hold the shutdown in the phase where it has begun but the
+	// http.Server.Shutdown hasn't been called yet
+	shutdownStarted := make(chan struct{})
+	releaseShutdown := make(chan struct{})
+	holdShutdown := serverParam{func(cfg *serverConf) {
+		cfg.onShutdown = append(cfg.onShutdown, func(time.Duration) {
+			close(shutdownStarted)
+			<-releaseShutdown
+		})
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			ShutdownTimeout(time.Second),
+			RejectDuringShutdown(http.StatusServiceUnavailable),
+			holdShutdown,
+		)
+	}()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dialing server: %v", err)
+	}
+	defer c.Close()
+	rdr := bufio.NewReader(c)
+	doRequest := func() *http.Response {
+		t.Helper()
+		if _, err := fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", addr); err != nil {
+			t.Fatalf("writing request: %v", err)
+		}
+		rsp, err := http.ReadResponse(rdr, nil)
+		if err != nil {
+			t.Fatalf("reading response: %v", err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	if rsp := doRequest(); rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 before shutdown, got %s", rsp.Status)
+	}
+
+	cancel()
+	select {
+	case <-shutdownStarted:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't start")
+	}
+
+	// request on the same keep-alive connection after shutdown has begun
+	rsp := doRequest()
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 during shutdown, got %s", rsp.Status)
+	}
+	if !rsp.Close {
+		t.Error("expected response to close the connection")
+	}
+	close(releaseShutdown)
+
+	select {
+	case <-time.After(2 * time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+}
+
+func Test_ProbePaths(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{}
+	RejectDuringShutdown(http.StatusServiceUnavailable).apply(cfg)
+	ProbePaths("/readyz", "/healthz").apply(cfg)
+	ReadinessEndpoint("/readyz").apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	cfg.shuttingDown.Store(true)
+	cfg.stopping.Store(true)
+	// probe served by the real handler
+	if rec := serve("/healthz"); rec.Code != http.StatusNoContent {
+		t.Errorf("expected probe path to be served by the handler during shutdown, got status %d", rec.Code)
+	}
+	// probe served by the readiness endpoint
+	if rec := serve("/readyz"); rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "shutting down\n" {
+		t.Errorf("expected readiness endpoint to report shutdown, got status %d: %q", rec.Code, rec.Body.String())
+	}
+	rec := serve("/api")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Connection") != "close" {
+		t.Errorf("expected other paths to be rejected during shutdown, got status %d", rec.Code)
+	}
+}
+
+func Test_ShutdownDelay(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	addr := "http://" + ln.Addr().String()
+
+	const delay = 300 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			ShutdownDelay(delay),
+			ReadinessEndpoint("/readyz"),
+			ShutdownTimeout(time.Second),
+		)
+	}()
+
+	status := func(path string) int {
+		t.Helper()
+		rsp, err := http.Get(addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("expected readiness status 200 before shutdown, got %d", code)
+	}
+
+	start := time.Now()
+	cancel()
+	for i := 0; status("/readyz") != http.StatusServiceUnavailable; i++ {
+		if i == 20 {
+			t.Fatal("readiness endpoint didn't start failing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// server still serves normal requests during the delay
+	if code := status("/"); code != http.StatusOK {
+		t.Errorf("expected status 200 during shutdown delay, got %d", code)
+	}
+
+	select {
+	case <-time.After(2 * time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+		if d := time.Since(start); d < delay {
+			t.Errorf("expected Run to return after shutdown delay, took %s", d)
+		}
+	}
+}
+
+type mockDrainable struct {
+	drained     chan struct{}
+	hasDeadline bool
+	err         error
+}
+
+func (d *mockDrainable) Drain(ctx context.Context) error {
+	_, d.hasDeadline = ctx.Deadline()
+	close(d.drained)
+	return d.err
+}
+
+func Test_RegisterDrainable(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	drainErr := fmt.Errorf("hub failed to close")
+	dA := &mockDrainable{drained: make(chan struct{})}
+	dB := &mockDrainable{drained: make(chan struct{}), err: drainErr}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.NotFoundHandler()},
+			Listener(ln),
+			ShutdownTimeout(time.Second),
+			RegisterDrainable(dA),
+			RegisterDrainable(dB),
+		)
+	}()
+
+	select {
+	case <-dA.drained:
+		t.Fatal("drainable was drained before shutdown")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+		expectError(t, err, drainErr)
+	}
+	for _, d := range []*mockDrainable{dA, dB} {
+		select {
+		case <-d.drained:
+		default:
+			t.Error("drainable hasn't been drained")
+		}
+		if !d.hasDeadline {
+			t.Error("expected drain ctx to have deadline")
+		}
+	}
+}
+
+func Test_CloseConnectionsOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	addr := "http://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+			Listener(ln),
+			ShutdownDelay(300*time.Millisecond),
+			ReadinessEndpoint("/readyz"),
+			CloseConnectionsOnShutdown(),
+		)
+	}()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		rsp, err := http.Get(addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		rsp.Body.Close()
+		return rsp
+	}
+
+	if rsp := get("/"); rsp.Close {
+		t.Error("expected connection to be kept alive before shutdown")
+	}
+
+	cancel()
+	for i := 0; get("/readyz").StatusCode != http.StatusServiceUnavailable; i++ {
+		if i == 20 {
+			t.Fatal("readiness endpoint didn't start failing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rsp := get("/")
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 during shutdown delay, got %d", rsp.StatusCode)
+	}
+	if !rsp.Close {
+		t.Error("expected 'Connection: close' response during drain")
+	}
+
+	select {
+	case <-time.After(2 * time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+}
+
+func Test_ShutdownSignal(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	inHandler := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		// long poll which never gets a message
+		select {
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		case <-ShutdownSignal(r.Context()):
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Handler: handler}, Listener(ln), ShutdownTimeout(5*time.Second))
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		rsp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Errorf("GET request failed: %v", err)
+			status <- 0
+			return
+		}
+		rsp.Body.Close()
+		status <- rsp.StatusCode
+	}()
+	<-inHandler
+	cancel()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+	if code := <-status; code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", code)
+	}
+
+	if ch := ShutdownSignal(context.Background()); ch != nil {
+		t.Error("expected nil channel for context not served by Run")
+	}
+}
+
+func Test_PropagateRunContext(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+
+	inHandler := make(chan struct{})
+	cause := make(chan error, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		select {
+		case <-r.Context().Done():
+			cause <- context.Cause(r.Context())
+		case <-time.After(time.Second):
+			cause <- nil
+		}
+	})
+
+	stopErr := errors.New("deploying new version")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx, &http.Server{Handler: handler},
+			Listener(ln),
+			ShutdownTimeout(5*time.Second),
+			PropagateRunContext(),
+		)
+	}()
+
+	go func() {
+		if rsp, err := http.Get("http://" + ln.Addr().String()); err == nil {
+			rsp.Body.Close()
+		}
+	}()
+	<-inHandler
+	cancel(stopErr)
+
+	if err := <-cause; !errors.Is(err, stopErr) {
+		t.Errorf("expected request context to be cancelled with %v, got %v", stopErr, err)
+	}
+	select {
+	case <-time.After(time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, stopErr)
+	}
+}
+
+func Test_LivenessEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	addr := "http://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- Run(ctx,
+			&http.Server{Handler: http.NotFoundHandler()},
+			Listener(ln),
+			ShutdownDelay(300*time.Millisecond),
+			ReadinessEndpoint("/readyz"),
+			LivenessEndpoint("/healthz"),
+		)
+	}()
+
+	status := func(path string) int {
+		t.Helper()
+		rsp, err := http.Get(addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	if live, ready := status("/healthz"), status("/readyz"); live != http.StatusOK || ready != http.StatusOK {
+		t.Errorf("expected liveness and readiness status 200 before shutdown, got %d and %d", live, ready)
+	}
+
+	cancel()
+	for i := 0; status("/readyz") != http.StatusServiceUnavailable; i++ {
+		if i == 20 {
+			t.Fatal("readiness endpoint didn't start failing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// liveness keeps succeeding during drain
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("expected liveness status 200 during shutdown delay, got %d", code)
+	}
+
+	select {
+	case <-time.After(2 * time.Second):
+		t.Error("Run didn't return within timeout")
+	case err := <-srvErr:
+		expectError(t, err, context.Canceled)
+	}
+}
+
+func Test_WaitForDeregistration(t *testing.T) {
+	t.Parallel()
+
+	run := func(t *testing.T, probe func() bool, timeout time.Duration) (string, context.CancelFunc, chan error) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+				Listener(ln),
+				ReadinessEndpoint("/readyz"),
+				WaitForDeregistration(probe, timeout),
+			)
+		}()
+		return "http://" + ln.Addr().String(), cancel, srvErr
+	}
+
+	t.Run("probe reports deregistered after delay", func(t *testing.T) {
+		var deregisteredAt time.Time
+		addr, cancel, srvErr := run(t, func() bool {
+			if deregisteredAt.IsZero() {
+				deregisteredAt = time.Now().Add(300 * time.Millisecond)
+			}
+			return time.Now().After(deregisteredAt)
+		}, 5*time.Second)
+
+		start := time.Now()
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		// readiness fails but requests are still served while waiting for deregistration
+		for path, exp := range map[string]int{"/readyz": http.StatusServiceUnavailable, "/": http.StatusOK} {
+			rsp, err := http.Get(addr + path)
+			if err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+			rsp.Body.Close()
+			if rsp.StatusCode != exp {
+				t.Errorf("expected %s to respond with status %d, got %d", path, exp, rsp.StatusCode)
+			}
+		}
+
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		if d := time.Since(start); d < 300*time.Millisecond || d > time.Second {
+			t.Errorf("expected shutdown to wait ~300ms for the deregistration, took %s", d)
+		}
+	})
+
+	t.Run("timeout elapses", func(t *testing.T) {
+		_, cancel, srvErr := run(t, func() bool { return false }, 200*time.Millisecond)
+		start := time.Now()
+		cancel()
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		if d := time.Since(start); d < 200*time.Millisecond {
+			t.Errorf("expected shutdown to wait for the timeout, took %s", d)
+		}
+	})
+}
+
+func Test_ShutdownGuard(t *testing.T) {
+	t.Parallel()
+
+	run := func(t *testing.T, guard func() (bool, string), maxWait time.Duration) (*strings.Builder, time.Duration) {
+		t.Helper()
+		logs := &strings.Builder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler(), ErrorLog: log.New(logs, "", 0)},
+				ShutdownGuard(guard, maxWait),
+			)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		cancel()
+
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatal("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+		return logs, time.Since(start)
+	}
+
+	t.Run("guard vetoes then allows", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		logs, d := run(t, func() (bool, string) {
+			if calls.Add(1) <= 2 {
+				return false, "transaction in progress"
+			}
+			return true, ""
+		}, 5*time.Second)
+
+		if n := calls.Load(); n != 3 {
+			t.Errorf("expected guard to be called 3 times, got %d", n)
+		}
+		// re-checked after 100ms and 200ms
+		if d < 300*time.Millisecond || d > time.Second {
+			t.Errorf("expected shutdown to be postponed by ~300ms, took %s", d)
+		}
+		if n := strings.Count(logs.String(), "shutdown postponed by the guard: transaction in progress"); n != 2 {
+			t.Errorf("expected the veto to be logged twice, got %d in %q", n, logs)
+		}
+	})
+
+	t.Run("max wait elapses", func(t *testing.T) {
+		t.Parallel()
+		logs, d := run(t, func() (bool, string) { return false, "busy" }, 250*time.Millisecond)
+
+		if d < 250*time.Millisecond || d > time.Second {
+			t.Errorf("expected shutdown to wait ~250ms for the guard, took %s", d)
+		}
+		if !strings.Contains(logs.String(), "shutting down anyway") {
+			t.Errorf("expected giving up to be logged, got %q", logs)
+		}
+	})
+}
+
+func Test_MaintenancePageOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	page := []byte("<html><body>Back in a moment</body></html>")
+	cfg := &serverConf{}
+	MaintenancePageOnShutdown(page).apply(cfg)
+	ShutdownDelay(5 * time.Second).apply(cfg)
+	ProbePaths("/healthz").apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+
+	serve := func(method, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("GET", "/", "text/html"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("expected request to be served before shutdown, got %d %q", rec.Code, rec.Body)
+	}
+
+	// requests are served during the shutdown delay
+	cfg.stopTimeout = 10 * time.Second
+	cfg.shuttingDown.Store(true)
+	if rec := serve("GET", "/", "text/html"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("expected request to be served during shutdown delay, got %d %q", rec.Code, rec.Body)
+	}
+
+	// simulate the start of the graceful shutdown
+	cfg.stopping.Store(true)
+
+	testCases := []struct {
+		method, path, accept string
+		contentType, body    string
+	}{
+		{method: "GET", path: "/", accept: "text/html,application/xhtml+xml,*/*;q=0.8", contentType: "text/html; charset=utf-8", body: string(page)},
+		{method: "GET", path: "/api/items", accept: "application/json", contentType: "application/json", body: `{"error":"shutting down"}`},
+		{method: "GET", path: "/api/items", accept: "", contentType: "application/json", body: `{"error":"shutting down"}`},
+		{method: "POST", path: "/form", accept: "text/html", contentType: "application/json", body: `{"error":"shutting down"}`},
+	}
+	for _, tc := range testCases {
+		rec := serve(tc.method, tc.path, tc.accept)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s (Accept: %q): expected status 503, got %d", tc.method, tc.path, tc.accept, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s %s (Accept: %q): expected content type %q, got %q", tc.method, tc.path, tc.accept, tc.contentType, ct)
+		}
+		if body := rec.Body.String(); body != tc.body {
+			t.Errorf("%s %s (Accept: %q): expected body %q, got %q", tc.method, tc.path, tc.accept, tc.body, body)
+		}
+		if ra := rec.Header().Get("Retry-After"); ra != "10" {
+			t.Errorf("%s %s (Accept: %q): expected Retry-After to be 10 seconds, got %q", tc.method, tc.path, tc.accept, ra)
+		}
+	}
+
+	if rec := serve("GET", "/healthz", "text/html"); rec.Code != http.StatusOK {
+		t.Errorf("expected probe path to be served during shutdown, got status %d", rec.Code)
+	}
+}
+
+func Test_WaitOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	run := func(t *testing.T, work time.Duration) (error, time.Duration) {
+		t.Helper()
+		var wg sync.WaitGroup
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+				ShutdownTimeout(300*time.Millisecond),
+				WaitOnShutdown(&wg),
+			)
+		}()
+		// background work which clears the wait group after a delay
+		wg.Add(1)
+		time.AfterFunc(work, wg.Done)
+		start := time.Now()
+		cancel()
+
+		select {
+		case <-time.After(2 * time.Second):
+			t.Fatal("Run didn't return within timeout")
+			return nil, 0
+		case err := <-srvErr:
+			return err, time.Since(start)
+		}
+	}
+
+	t.Run("wait group clears", func(t *testing.T) {
+		t.Parallel()
+		err, d := run(t, 100*time.Millisecond)
+		expectError(t, err, context.Canceled)
+		if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected timeout: %v", err)
+		}
+		if d < 100*time.Millisecond || d > 300*time.Millisecond {
+			t.Errorf("expected Run to wait ~100ms for the wait group, took %s", d)
+		}
+	})
+
+	t.Run("timeout is hit", func(t *testing.T) {
+		t.Parallel()
+		err, d := run(t, time.Second)
+		expectError(t, err, context.DeadlineExceeded)
+		if !strings.Contains(err.Error(), "wait group didn't clear") {
+			t.Errorf("expected error to report the wait group, got %q", err)
+		}
+		if d < 300*time.Millisecond || d > 800*time.Millisecond {
+			t.Errorf("expected Run to wait for the shutdown timeout, took %s", d)
+		}
+	})
+}
+
+func Test_RejectDuringShutdown_healthEndpoints(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{}
+	RejectDuringShutdown(http.StatusGone).apply(cfg)
+	LivenessEndpoint("/healthz").apply(cfg)
+	ReadinessEndpoint("/readyz").apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// the health endpoints are exempt without being listed in ProbePaths
+	expect := func(phase string, responses map[string]string) {
+		t.Helper()
+		for path, want := range responses {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			if got := fmt.Sprintf("%d %s", rec.Code, rec.Body.String()); got != want {
+				t.Errorf("%s: expected %q for %s, got %q", phase, want, path, got)
+			}
+		}
+	}
+
+	cfg.shuttingDown.Store(true)
+	expect("shutdown delay", map[string]string{
+		"/":        "204 ",
+		"/healthz": "200 ok",
+		"/readyz":  "503 shutting down\n",
+	})
+
+	cfg.stopping.Store(true)
+	expect("graceful shutdown", map[string]string{
+		"/":        "410 Gone\n",
+		"/healthz": "503 stopping\n",
+		"/readyz":  "503 shutting down\n",
+	})
+}
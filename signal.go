@@ -0,0 +1,196 @@
+package httpsrv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+/*
+ShutdownPolicy describes how the server is stopped, see [RunWithSignalPolicy].
+*/
+type ShutdownPolicy struct {
+	Delay   time.Duration // lame-duck period, see [ShutdownDelay]
+	Timeout time.Duration // graceful shutdown timeout, zero means the server is closed immediately
+}
+
+// SignalError is the cancellation cause when server is stopped by [RunWithSignalPolicy].
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string { return "received signal " + e.Signal.String() }
+
+/*
+RunWithSignalPolicy is like [Run] but it also stops the server when one of the signals
+in the policy is received, using the shutdown policy assigned to the signal. Ie
+
+	httpsrv.RunWithSignalPolicy(ctx, srv, map[os.Signal]httpsrv.ShutdownPolicy{
+		os.Interrupt:    {}, // close immediately
+		syscall.SIGTERM: {Delay: 5 * time.Second, Timeout: 20 * time.Second},
+	})
+
+When the server is stopped because of the signal the returned error wraps [SignalError].
+When the ctx is cancelled the shutdown is done according to the params.
+*/
+func RunWithSignalPolicy(
+	ctx context.Context, srv *http.Server, policy map[os.Signal]ShutdownPolicy, params ...ServerParam,
+) error {
+	sigs := make([]os.Signal, 0, len(policy))
+	for sig := range policy {
+		sigs = append(sigs, sig)
+	}
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, sigs...)
+	defer signal.Stop(sigC)
+
+	return Run(ctx, srv, append(params, serverParam{func(cfg *serverConf) {
+		cfg.watchers = append(cfg.watchers, func(ctx context.Context, cancel context.CancelCauseFunc) {
+			select {
+			case <-ctx.Done():
+			case sig := <-sigC:
+				cfg.signalled(sig)
+				p := policy[sig]
+				cfg.policy.Store(&p)
+				cancel(&SignalError{Signal: sig})
+			}
+		})
+	}})...)
+}
+
+/*
+ShutdownOnSignal makes the server to shut down (according to the params, ie [ShutdownDelay] and
+[ShutdownTimeout]) when one of the signals is received, [Run] returns [SignalError]. When no signals
+are given [os.Interrupt] and [syscall.SIGTERM] are used. This saves wiring [os/signal.NotifyContext]
+into the Run's context and, unlike it, tells the signal apart from the cancellation of the context.
+
+The signals are handled while the server is running, they are registered right before the server
+starts to accept connections.
+*/
+func ShutdownOnSignal(sigs ...os.Signal) ServerParam {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return serverParam{func(cfg *serverConf) {
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) {
+			sigC := make(chan os.Signal, 1)
+			signal.Notify(sigC, sigs...)
+			go func(ctx context.Context, cancel context.CancelCauseFunc) {
+				defer signal.Stop(sigC)
+				select {
+				case <-ctx.Done():
+				case sig := <-sigC:
+					cfg.signalled(sig)
+					cancel(&SignalError{Signal: sig})
+				}
+			}(cfg.runCtx, cfg.stopRun)
+		})
+	}}
+}
+
+/*
+DumpGoroutinesOnSignal makes the server to write full goroutine dump (stack traces of all the
+goroutines) into w when the signal is received and then shut down gracefully, [Run] returns
+[SignalError]. This replicates the JVM style "thread dump on SIGQUIT" and helps to debug a stuck
+server - unlike the Go runtime's default handling of SIGQUIT the in-flight requests are drained
+instead of the process exiting immediately.
+
+When sig is nil SIGQUIT is used, when w is nil the dump is written to [os.Stderr]. The signal
+is handled while the server is running, it is registered right before the server starts to
+accept connections.
+*/
+func DumpGoroutinesOnSignal(sig os.Signal, w io.Writer) ServerParam {
+	if sig == nil {
+		sig = syscall.SIGQUIT
+	}
+	if w == nil {
+		w = os.Stderr
+	}
+	return serverParam{func(cfg *serverConf) {
+		cfg.onReady = append(cfg.onReady, func(ReadyInfo) {
+			sigC := make(chan os.Signal, 1)
+			signal.Notify(sigC, sig)
+			go func(ctx context.Context, cancel context.CancelCauseFunc) {
+				defer signal.Stop(sigC)
+				select {
+				case <-ctx.Done():
+				case <-sigC:
+					cfg.signalled(sig)
+					fmt.Fprintf(w, "goroutine dump on signal %s:\n\n", sig)
+					if err := pprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+						cfg.logf("httpsrv: writing goroutine dump: %v", err)
+					}
+					cancel(&SignalError{Signal: sig})
+				}
+			}(cfg.runCtx, cfg.stopRun)
+		})
+	}}
+}
+
+/*
+OnSignal sets hook which is called synchronously when the signal handled by the server (see
+[ShutdownOnSignal], [RunWithSignalPolicy], [DumpGoroutinesOnSignal] and [RestartOnSignal]) is
+received, before the shutdown begins. This is the place to log or count "why are we shutting
+down". The hook is not called when the server is stopped by cancelling the Run's context.
+*/
+func OnSignal(hook func(os.Signal)) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.onSignal = append(cfg.onSignal, hook) }}
+}
+
+/*
+SignalDeadline sets func returning the time the process has to stop after the signal (handled by
+the server, see [OnSignal]) was received, ie the grace period after which the orchestrator sends
+SIGKILL. When it returns positive duration the shutdown delay and the graceful shutdown timeout are
+cut short so that the shutdown completes before the deadline:
+
+	httpsrv.SignalDeadline(func(sig os.Signal) time.Duration {
+		if sig == syscall.SIGTERM {
+			return 28 * time.Second // terminationGracePeriodSeconds minus safety margin
+		}
+		return 0
+	})
+
+The delay is shortened first, when no time is left for the graceful shutdown the server is closed
+immediately. Time spent by hooks running after the server has stopped (ie [DrainFunc]) is not
+accounted for.
+*/
+func SignalDeadline(f func(sig os.Signal) time.Duration) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.signalDeadline = f }}
+}
+
+// signalled calls the OnSignal hooks and records the deadline set by SignalDeadline.
+func (cfg *serverConf) signalled(sig os.Signal) {
+	if cfg.signalDeadline != nil {
+		if d := cfg.signalDeadline(sig); d > 0 {
+			t := cfg.clk().Now().Add(d)
+			cfg.stopDeadline.Store(&t)
+		}
+	}
+	for _, f := range cfg.onSignal {
+		f(sig)
+	}
+}
+
+/*
+capToDeadline shortens the shutdown delay and timeout so that they fit before the deadline
+set by SignalDeadline (if any).
+*/
+func (cfg *serverConf) capToDeadline(delay, timeout time.Duration) (time.Duration, time.Duration) {
+	dl := cfg.stopDeadline.Load()
+	if dl == nil {
+		return delay, timeout
+	}
+	left := dl.Sub(cfg.clk().Now())
+	delay = max(min(delay, left), 0)
+	if timeout > 0 {
+		// no time left for graceful shutdown means the server is closed immediately
+		timeout = max(min(timeout, left-delay), 0)
+	}
+	return delay, timeout
+}
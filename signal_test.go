@@ -0,0 +1,347 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_RunWithSignalPolicy(t *testing.T) {
+	// not parallel as the test sends signals to the test process itself
+
+	policy := map[os.Signal]ShutdownPolicy{
+		os.Interrupt:    {},
+		syscall.SIGTERM: {Delay: 300 * time.Millisecond, Timeout: 2 * time.Second},
+	}
+
+	// handler which takes 200ms to complete, entered chan is signalled when request arrives
+	entered := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		}
+	})
+
+	startServer := func(t *testing.T, ctx context.Context) (string, chan error) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- RunWithSignalPolicy(ctx, &http.Server{Handler: handler}, policy, Listener(ln), ReadinessEndpoint("/readyz"))
+		}()
+		return "http://" + ln.Addr().String(), srvErr
+	}
+
+	sendSignal := func(t *testing.T, sig os.Signal) {
+		t.Helper()
+		p, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			t.Fatalf("finding own process: %v", err)
+		}
+		if err := p.Signal(sig); err != nil {
+			t.Fatalf("sending signal: %v", err)
+		}
+	}
+
+	slowRequest := func(addr string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			rsp, err := http.Get(addr + "/slow")
+			if err == nil {
+				rsp.Body.Close()
+			}
+			done <- err
+		}()
+		<-entered
+		return done
+	}
+
+	expectSignal := func(t *testing.T, err error, sig os.Signal) {
+		t.Helper()
+		var se *SignalError
+		if !errors.As(err, &se) {
+			t.Fatalf("expected SignalError, got %v", err)
+		}
+		if se.Signal != sig {
+			t.Errorf("expected signal %v, got %v", sig, se.Signal)
+		}
+	}
+
+	t.Run("immediate close", func(t *testing.T) {
+		addr, srvErr := startServer(t, context.Background())
+		reqErr := slowRequest(addr)
+
+		sendSignal(t, os.Interrupt)
+		select {
+		case <-time.After(150 * time.Millisecond):
+			t.Error("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectSignal(t, err, os.Interrupt)
+		}
+		if err := <-reqErr; err == nil {
+			t.Error("expected in-flight request to fail")
+		}
+	})
+
+	t.Run("lame-duck then graceful", func(t *testing.T) {
+		addr, srvErr := startServer(t, context.Background())
+		// make sure the server is running, ie signal handler is registered
+		rsp, err := http.Get(addr + "/readyz")
+		if err != nil {
+			t.Fatalf("readiness request failed: %v", err)
+		}
+		rsp.Body.Close()
+
+		sendSignal(t, syscall.SIGTERM)
+		for i := 0; ; i++ {
+			rsp, err := http.Get(addr + "/readyz")
+			if err != nil {
+				t.Fatalf("readiness request failed: %v", err)
+			}
+			rsp.Body.Close()
+			if rsp.StatusCode == http.StatusServiceUnavailable {
+				break
+			}
+			if i == 20 {
+				t.Fatal("readiness endpoint didn't start failing")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// requests are still served during the delay and in-flight requests complete
+		reqErr := slowRequest(addr)
+		if err := <-reqErr; err != nil {
+			t.Errorf("expected in-flight request to complete, got %v", err)
+		}
+
+		select {
+		case <-time.After(3 * time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectSignal(t, err, syscall.SIGTERM)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, srvErr := startServer(t, ctx)
+		cancel()
+		select {
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.Canceled)
+		}
+	})
+}
+
+func Test_ShutdownOnSignal(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the server process
+	if os.Getenv("HTTPSRV_TEST_SHUTDOWN_ON_SIGNAL") != "" {
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			ShutdownOnSignal(),
+			// send SIGTERM to ourselves once the server is up
+			OnReady(func(ReadyInfo) {
+				p, _ := os.FindProcess(os.Getpid())
+				p.Signal(syscall.SIGTERM)
+			}),
+		)
+		var sigErr *SignalError
+		fmt.Println("Run returned:", err, errors.As(err, &sigErr))
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_ShutdownOnSignal$")
+	cmd.Env = append(os.Environ(), "HTTPSRV_TEST_SHUTDOWN_ON_SIGNAL=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running subprocess: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Run returned: received signal terminated true") {
+		t.Errorf("expected server to be stopped by the signal, got:\n%s", out)
+	}
+}
+
+func Test_DumpGoroutinesOnSignal(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the server process
+	if os.Getenv("HTTPSRV_TEST_DUMP") != "" {
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			DumpGoroutinesOnSignal(nil, os.Stdout),
+			// send SIGQUIT to ourselves once the server is up
+			OnReady(func(ReadyInfo) {
+				p, _ := os.FindProcess(os.Getpid())
+				p.Signal(syscall.SIGQUIT)
+			}),
+		)
+		fmt.Println("\nRun returned:", err)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_DumpGoroutinesOnSignal$")
+	cmd.Env = append(os.Environ(), "HTTPSRV_TEST_DUMP=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running subprocess: %v\n%s", err, out)
+	}
+	// the dump must contain the stack of the test goroutine and shutdown must follow it
+	dump := strings.Index(string(out), "goroutine dump on signal quit")
+	frame := strings.Index(string(out), "httpsrv.Test_DumpGoroutinesOnSignal(")
+	stop := strings.Index(string(out), "Run returned: received signal quit")
+	if dump == -1 || frame < dump || stop < frame {
+		t.Errorf("expected goroutine dump followed by shutdown, got:\n%s", out)
+	}
+}
+
+func Test_OnSignal(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the server process
+	if os.Getenv("HTTPSRV_TEST_ON_SIGNAL") != "" {
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			ShutdownOnSignal(),
+			OnSignal(func(sig os.Signal) { fmt.Println("OnSignal:", sig) }),
+			// shutdown begins after the hook has returned
+			serverParam{func(cfg *serverConf) {
+				cfg.onShutdown = append(cfg.onShutdown, func(time.Duration) { fmt.Println("shutdown began") })
+			}},
+			// send SIGTERM to ourselves once the server is up
+			OnReady(func(ReadyInfo) {
+				p, _ := os.FindProcess(os.Getpid())
+				p.Signal(syscall.SIGTERM)
+			}),
+		)
+		fmt.Println("Run returned:", err)
+		return
+	}
+
+	t.Run("signal", func(t *testing.T) {
+		cmd := exec.Command(os.Args[0], "-test.run=^Test_OnSignal$")
+		cmd.Env = append(os.Environ(), "HTTPSRV_TEST_ON_SIGNAL=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("running subprocess: %v\n%s", err, out)
+		}
+		hook := strings.Index(string(out), "OnSignal: terminated\n")
+		shutdown := strings.Index(string(out), "shutdown began")
+		stop := strings.Index(string(out), "Run returned: received signal terminated")
+		if hook == -1 || shutdown < hook || stop < shutdown {
+			t.Errorf("expected hook to be called before the shutdown, got:\n%s", out)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		called := false
+		err := Run(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			ShutdownOnSignal(),
+			OnSignal(func(os.Signal) { called = true }),
+			OnReady(func(ReadyInfo) { cancel() }),
+		)
+		expectError(t, err, context.Canceled)
+		if called {
+			t.Error("expected hook not to be called when the context is cancelled")
+		}
+	})
+}
+
+func Test_SignalDeadline(t *testing.T) {
+	t.Parallel()
+
+	// when the env var is set the test binary acts as the server process
+	if os.Getenv("HTTPSRV_TEST_SIGNAL_DEADLINE") != "" {
+		entered := make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(entered)
+			<-r.Context().Done()
+		})
+		var signalled time.Time
+		err := Run(context.Background(), &http.Server{Addr: "127.0.0.1:0", Handler: handler},
+			ShutdownOnSignal(),
+			ShutdownDelay(100*time.Millisecond),
+			ShutdownTimeout(10*time.Second),
+			SignalDeadline(func(sig os.Signal) time.Duration {
+				if sig == syscall.SIGTERM {
+					return 400 * time.Millisecond
+				}
+				return 0
+			}),
+			OnSignal(func(os.Signal) { signalled = time.Now() }),
+			// start request which never completes and send SIGTERM to ourselves
+			OnReady(func(info ReadyInfo) {
+				go http.Get("http://" + info.Addr.String() + "/")
+				go func() {
+					<-entered
+					p, _ := os.FindProcess(os.Getpid())
+					p.Signal(syscall.SIGTERM)
+				}()
+			}),
+		)
+		var se *StopError
+		fmt.Println("timed out:", errors.As(err, &se) && se.Mode == ShutdownGraceful && errors.Is(err, context.DeadlineExceeded))
+		fmt.Println("within deadline:", time.Since(signalled) < 800*time.Millisecond)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_SignalDeadline$")
+	cmd.Env = append(os.Environ(), "HTTPSRV_TEST_SIGNAL_DEADLINE=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running subprocess: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "timed out: true") || !strings.Contains(string(out), "within deadline: true") {
+		t.Errorf("expected graceful shutdown to time out at the deadline, got:\n%s", out)
+	}
+}
+
+func Test_capToDeadline(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		left        time.Duration // until the deadline, zero means no deadline
+		delay, to   time.Duration
+		wantDelay   time.Duration
+		wantTimeout time.Duration
+	}{
+		{name: "no deadline", delay: time.Second, to: 10 * time.Second, wantDelay: time.Second, wantTimeout: 10 * time.Second},
+		{name: "fits", left: time.Minute, delay: time.Second, to: 10 * time.Second, wantDelay: time.Second, wantTimeout: 10 * time.Second},
+		{name: "timeout is cut", left: 5 * time.Second, delay: time.Second, to: 10 * time.Second, wantDelay: time.Second, wantTimeout: 4 * time.Second},
+		{name: "delay is cut", left: 500 * time.Millisecond, delay: time.Second, to: 10 * time.Second, wantDelay: 500 * time.Millisecond, wantTimeout: 0},
+		{name: "immediate close stays", left: 5 * time.Second, delay: time.Second, to: 0, wantDelay: time.Second, wantTimeout: 0},
+		{name: "deadline passed", left: -time.Second, delay: time.Second, to: 10 * time.Second, wantDelay: 0, wantTimeout: 0},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			clk := newFakeClock()
+			cfg := &serverConf{clock: clk}
+			if tc.left != 0 {
+				dl := clk.Now().Add(tc.left)
+				cfg.stopDeadline.Store(&dl)
+			}
+			delay, to := cfg.capToDeadline(tc.delay, tc.to)
+			if delay != tc.wantDelay || to != tc.wantTimeout {
+				t.Errorf("expected delay %s and timeout %s, got %s and %s", tc.wantDelay, tc.wantTimeout, delay, to)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package httpsrv
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ThrottleBandwidth limits the bandwidth of every accepted connection to bytesPerSec in both
+directions (ie reading and writing are limited separately), which is useful to simulate slow
+(mobile) clients in integration tests or to protect shared bandwidth. The limit is implemented
+as token bucket allowing bursts of 1/10 of the rate, with TLS the limit applies to the encrypted
+traffic. Zero or negative rate disables the throttling.
+
+Note that the throttling delays are not interrupted by the connection's deadlines.
+*/
+func ThrottleBandwidth(bytesPerSec int) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.bandwidth = bytesPerSec }}
+}
+
+type rateLimitedListener struct {
+	net.Listener
+	rate int // bytes per second
+}
+
+func (l *rateLimitedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	return &rateLimitedConn{Conn: c, r: newTokenBucket(l.rate), w: newTokenBucket(l.rate)}, nil
+}
+
+type rateLimitedConn struct {
+	net.Conn
+	r, w *tokenBucket
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	if len(b) > c.r.burst {
+		b = b[:c.r.burst]
+	}
+	n, err := c.Conn.Read(b)
+	c.r.take(n)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b[:min(len(b), c.w.burst)]
+		c.w.take(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+/*
+tokenBucket paces the traffic to the rate, the bucket holds at most burst tokens (bytes).
+*/
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  int
+	m      sync.Mutex
+	tokens float64
+	last   time.Time // when the tokens were refilled
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	burst := max(bytesPerSec/10, 1)
+	return &tokenBucket{rate: float64(bytesPerSec), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// take consumes n tokens, blocking until the bucket has recovered from the debt.
+func (b *tokenBucket) take(n int) {
+	b.m.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.burst))
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.m.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
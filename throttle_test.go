@@ -0,0 +1,65 @@
+package httpsrv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_ThrottleBandwidth(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	body := bytes.Repeat([]byte("x"), 50_000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(body) })},
+		Listener(ln),
+		ThrottleBandwidth(100_000),
+	)
+
+	start := time.Now()
+	rsp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("GET request failed: %v", err)
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if len(b) != len(body) {
+		t.Errorf("expected %d bytes, got %d", len(body), len(b))
+	}
+	// 50kB at 100kB/s with 10kB burst should take ~400ms
+	if elapsed < 350*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("expected the response to be paced to take ~400ms, took %s", elapsed)
+	}
+}
+
+func Test_tokenBucket(t *testing.T) {
+	t.Parallel()
+
+	b := newTokenBucket(1000)
+	if b.burst != 100 {
+		t.Errorf("expected burst of 100, got %d", b.burst)
+	}
+	start := time.Now()
+	// burst is available immediately, the rest is paced
+	for i := 0; i < 3; i++ {
+		b.take(100)
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected taking 300 tokens to take ~200ms, took %s", elapsed)
+	}
+}
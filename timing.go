@@ -0,0 +1,71 @@
+package httpsrv
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+EmitServerTiming makes the server to add "Server-Timing: app;dur=<ms>" header to the responses,
+the duration is the time the handler took until it wrote the response headers (which for most
+handlers is the total time of the handler). The header is visible in the browser's devtools
+which helps to debug frontend performance.
+
+Streaming responses are not affected - the header is added when the handler writes the headers
+(or flushes), the response is not buffered.
+*/
+func EmitServerTiming() ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.serverTiming = true }}
+}
+
+func serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &timingWriter{ResponseWriter: w, start: time.Now()}
+		next.ServeHTTP(tw, r)
+		tw.writeTiming()
+	})
+}
+
+/*
+timingWriter adds the Server-Timing header to the response right before the headers are written.
+*/
+type timingWriter struct {
+	http.ResponseWriter
+	start   time.Time
+	written bool // headers have been written
+}
+
+func (w *timingWriter) writeTiming() {
+	if w.written {
+		return
+	}
+	w.written = true
+	ms := float64(time.Since(w.start)) / float64(time.Millisecond)
+	w.Header().Add("Server-Timing", "app;dur="+strconv.FormatFloat(ms, 'f', 3, 64))
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.writeTiming()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.writeTiming()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	w.writeTiming()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.written = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to access the underlying ResponseWriter.
+func (w *timingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
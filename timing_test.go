@@ -0,0 +1,72 @@
+package httpsrv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_EmitServerTiming(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, h http.HandlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		cfg := &serverConf{}
+		EmitServerTiming().apply(cfg)
+		rec := httptest.NewRecorder()
+		cfg.wrapHandler(h).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	// returns the duration reported by the Server-Timing header
+	duration := func(t *testing.T, rec *httptest.ResponseRecorder) time.Duration {
+		t.Helper()
+		st := rec.Header().Values("Server-Timing")
+		if len(st) != 1 || !strings.HasPrefix(st[0], "app;dur=") {
+			t.Fatalf("unexpected Server-Timing header %q", st)
+		}
+		ms, err := strconv.ParseFloat(strings.TrimPrefix(st[0], "app;dur="), 64)
+		if err != nil {
+			t.Fatalf("invalid duration in Server-Timing header %q: %v", st[0], err)
+		}
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	t.Run("handler duration", func(t *testing.T) {
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			w.Write([]byte("hello"))
+		})
+		if d := duration(t, rec); d < 20*time.Millisecond || d > time.Second {
+			t.Errorf("implausible duration %s", d)
+		}
+	})
+
+	t.Run("handler doesn't write response", func(t *testing.T) {
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {})
+		if d := duration(t, rec); d < 0 || d > time.Second {
+			t.Errorf("implausible duration %s", d)
+		}
+	})
+
+	t.Run("streaming response", func(t *testing.T) {
+		rec := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("first"))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(" second"))
+		})
+		if !rec.Flushed {
+			t.Error("expected response to be flushed")
+		}
+		if body := rec.Body.String(); body != "first second" {
+			t.Errorf("unexpected body %q", body)
+		}
+		if d := duration(t, rec); d >= 50*time.Millisecond {
+			t.Errorf("expected duration until the headers were written, got %s", d)
+		}
+	})
+}
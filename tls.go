@@ -0,0 +1,195 @@
+package httpsrv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+/*
+OptionalTLS is like [TLS] but when neither of the files exists the server is started without
+TLS (plaintext HTTP) and warning is written to the server's error log. This allows to use the
+same code path for local development (no certificates) and production. When only one of the
+files exists or the files are not valid certificate and key [Run] fails.
+*/
+func OptionalTLS(certFile, keyFile string) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		cfg.certFile, cfg.keyFile = certFile, keyFile
+		cfg.optionalTLS = true
+	}}
+}
+
+/*
+checkOptionalTLS decides whether to use TLS when the [OptionalTLS] param was used, when
+certificate files do not exist they are cleared from the config.
+*/
+func (cfg *serverConf) checkOptionalTLS() error {
+	if !cfg.optionalTLS {
+		return nil
+	}
+	if !fileExists(cfg.certFile) && !fileExists(cfg.keyFile) {
+		cfg.logf("httpsrv: certificate %q and key %q not found, serving without TLS", cfg.certFile, cfg.keyFile)
+		cfg.certFile, cfg.keyFile = "", ""
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile); err != nil {
+		return fmt.Errorf("optional TLS: %w", err)
+	}
+	return nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+/*
+TLSSessionTicketKeys sets the keys used to encrypt and decrypt TLS session tickets, enabling
+session resumption (which reduces the handshake cost) even when [tls.Config.SessionTicketsDisabled]
+was set. The first key is used to encrypt new tickets, all keys can be used to decrypt them.
+
+By default Go generates random ticket keys per server instance, so clients can't resume
+sessions when they are load-balanced to another instance. When all the instances are started
+with the same keys sessions can be resumed on any of them. To rotate the keys start the
+instances with the new key as the first one and keep the previous key(s) in the list so that
+tickets issued before the rotation can still be decrypted. The keys must be kept secret and
+rotated regularly as anyone knowing them can decrypt the recorded sessions.
+*/
+func TLSSessionTicketKeys(keys ...[32]byte) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.ticketKeys = keys }}
+}
+
+/*
+RequireALPN makes the server to reject TLS connections which negotiate application protocol other
+than the given ones (ie "h2" for gRPC), including clients which do not support ALPN at all. Note
+that [http.Server] supports "h2" and "http/1.1" protocols out of the box, other protocols need
+[http.Server.TLSNextProto] handlers.
+
+The protocols are enforced after the negotiation, using [tls.Config.VerifyConnection] (the
+VerifyConnection assigned by user is still called). The server still advertises "h2" and
+"http/1.1" as ServeTLS adds them to the config, so the client which prefers other protocol
+negotiates it and then fails the handshake instead of falling back to the allowed protocol.
+*/
+func RequireALPN(protos ...string) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.alpn = protos }}
+}
+
+/*
+ClientCertKeyUsage makes the server to reject TLS connections when the client certificate doesn't
+have all the given extended key usages (ie [x509.ExtKeyUsageClientAuth]), even if it is otherwise
+valid. This hardens the service-to-service (mTLS) authentication as certificates issued for other
+purposes (ie server certificates signed by the same CA) can't be used by clients. Certificate with
+[x509.ExtKeyUsageAny] is accepted.
+
+The usages are enforced using [tls.Config.VerifyConnection], the VerifyConnection assigned by user
+is still called. Whether the client must present certificate is controlled by the [tls.Config.ClientAuth].
+*/
+func ClientCertKeyUsage(usages ...x509.ExtKeyUsage) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.clientKeyUsage = usages }}
+}
+
+/*
+OnClientHello registers hook which is called with the ClientHello message of every incoming TLS
+handshake, when it returns error the handshake is aborted. This allows to inspect and reject
+the clients at the TLS layer, ie to enforce the presence of SNI:
+
+	httpsrv.OnClientHello(func(hello *tls.ClientHelloInfo) error {
+		if hello.ServerName == "" {
+			return errors.New("SNI is required")
+		}
+		return nil
+	})
+
+or to block known-bad fingerprints (computed from the cipher suites, extensions etc). The hooks
+are called from [tls.Config.GetConfigForClient], the GetConfigForClient assigned by user is
+called after the hooks have accepted the handshake. Parameter can be used multiple times, the
+hooks are called in the order they were registered.
+*/
+func OnClientHello(hook func(*tls.ClientHelloInfo) error) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.clientHello = append(cfg.clientHello, hook) }}
+}
+
+/*
+setupTLS applies TLS related params to the server's TLSConfig. The TLSConfig is cloned
+before modifying it as it might be shared with other servers.
+*/
+func (cfg *serverConf) setupTLS() {
+	if len(cfg.ticketKeys) == 0 && len(cfg.alpn) == 0 && len(cfg.clientHello) == 0 && len(cfg.clientKeyUsage) == 0 && cfg.ocsp == nil {
+		return
+	}
+
+	tc := cfg.srv.TLSConfig.Clone()
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	if len(cfg.ticketKeys) != 0 {
+		tc.SessionTicketsDisabled = false
+		tc.SetSessionTicketKeys(cfg.ticketKeys)
+	}
+	if len(cfg.alpn) != 0 {
+		requireALPN(tc, cfg.alpn)
+	}
+	if len(cfg.clientKeyUsage) != 0 {
+		requireClientKeyUsage(tc, cfg.clientKeyUsage)
+	}
+	if cfg.ocsp != nil {
+		cfg.ocsp.setup(cfg, tc)
+	}
+	if len(cfg.clientHello) != 0 {
+		inspectClientHello(tc, cfg.clientHello)
+	}
+	cfg.srv.TLSConfig = tc
+}
+
+func requireALPN(tc *tls.Config, protos []string) {
+	tc.NextProtos = append([]string(nil), protos...)
+	next := tc.VerifyConnection
+	tc.VerifyConnection = func(cs tls.ConnectionState) error {
+		if !slices.Contains(protos, cs.NegotiatedProtocol) {
+			return fmt.Errorf("application protocol %q is not allowed", cs.NegotiatedProtocol)
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+func requireClientKeyUsage(tc *tls.Config, usages []x509.ExtKeyUsage) {
+	next := tc.VerifyConnection
+	tc.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) != 0 {
+			cert := cs.PeerCertificates[0]
+			if !slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageAny) {
+				for _, u := range usages {
+					if !slices.Contains(cert.ExtKeyUsage, u) {
+						return fmt.Errorf("client certificate %q lacks required extended key usage %d", cert.Subject, u)
+					}
+				}
+			}
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+func inspectClientHello(tc *tls.Config, hooks []func(*tls.ClientHelloInfo) error) {
+	next := tc.GetConfigForClient
+	tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		for _, f := range hooks {
+			if err := f(hello); err != nil {
+				return nil, err
+			}
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,376 @@
+package httpsrv
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TLSSessionTicketKeys(t *testing.T) {
+	t.Parallel()
+
+	cert := testCertificate(t)
+
+	// starts TLS server and returns it's address
+	startServer := func(t *testing.T, params ...ServerParam) string {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{
+					Handler:   http.NotFoundHandler(),
+					TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, SessionTicketsDisabled: true},
+				},
+				append(params, Listener(ln))...,
+			)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-srvErr
+		})
+		return ln.Addr().String()
+	}
+
+	// makes request to both servers using the same session cache and reports
+	// whether the session was resumed for the second request
+	resumed := func(t *testing.T, addrA, addrB string) bool {
+		t.Helper()
+		c := &http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					ServerName:         "instance.test",
+					ClientSessionCache: tls.NewLRUClientSessionCache(1),
+				},
+			},
+		}
+		for _, addr := range []string{addrA, addrB} {
+			rsp, err := c.Get("https://" + addr)
+			if err != nil {
+				t.Fatalf("GET request failed: %v", err)
+			}
+			rsp.Body.Close()
+			if addr == addrB {
+				return rsp.TLS.DidResume
+			}
+		}
+		return false
+	}
+
+	t.Run("instances with shared keys", func(t *testing.T) {
+		var key [32]byte
+		rand.Read(key[:])
+		addrA := startServer(t, TLSSessionTicketKeys(key))
+		addrB := startServer(t, TLSSessionTicketKeys(key))
+		if !resumed(t, addrA, addrB) {
+			t.Error("expected session to be resumed")
+		}
+	})
+
+	t.Run("without shared keys", func(t *testing.T) {
+		addrA := startServer(t)
+		addrB := startServer(t)
+		if resumed(t, addrA, addrB) {
+			t.Error("unexpectedly session was resumed")
+		}
+	})
+}
+
+func Test_RequireALPN(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{
+			Handler:   http.NotFoundHandler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		},
+		Listener(ln),
+		RequireALPN("h2"),
+	)
+
+	// performs TLS handshake offering given protocols
+	handshake := func(protos ...string) (string, error) {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		// TLS 1.3 client learns about rejection when reading
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return "", err
+		}
+		return c.ConnectionState().NegotiatedProtocol, nil
+	}
+
+	t.Run("allowed protocol", func(t *testing.T) {
+		proto, err := handshake("h2", "http/1.1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if proto != "h2" {
+			t.Errorf("expected h2 to be negotiated, got %q", proto)
+		}
+	})
+
+	t.Run("disallowed protocol", func(t *testing.T) {
+		if _, err := handshake("http/1.1"); err == nil {
+			t.Error("expected handshake to fail")
+		}
+	})
+
+	t.Run("no ALPN", func(t *testing.T) {
+		if _, err := handshake(); err == nil {
+			t.Error("expected handshake to fail")
+		}
+	})
+}
+
+func Test_OptionalTLS(t *testing.T) {
+	t.Parallel()
+
+	// starts server with OptionalTLS param and returns it's address and log
+	startServer := func(t *testing.T, certFile, keyFile string) (string, *strings.Builder) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		logs := &strings.Builder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.NotFoundHandler(), ErrorLog: log.New(logs, "", 0)},
+				Listener(ln), OptionalTLS(certFile, keyFile),
+			)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-srvErr
+		})
+		return ln.Addr().String(), logs
+	}
+
+	get := func(t *testing.T, url string) {
+		t.Helper()
+		c := &http.Client{
+			Timeout:   time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+		rsp, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+	}
+
+	t.Run("certificate files exist", func(t *testing.T) {
+		certFile, keyFile := writeTestCertificate(t)
+		addr, _ := startServer(t, certFile, keyFile)
+		get(t, "https://"+addr)
+	})
+
+	t.Run("certificate files do not exist", func(t *testing.T) {
+		dir := t.TempDir()
+		addr, logs := startServer(t, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+		get(t, "http://"+addr)
+		if !strings.Contains(logs.String(), "serving without TLS") {
+			t.Errorf("expected warning to be logged, got %q", logs.String())
+		}
+	})
+
+	t.Run("only certificate file exists", func(t *testing.T) {
+		certFile, _ := writeTestCertificate(t)
+		err := Run(context.Background(),
+			&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()},
+			OptionalTLS(certFile, filepath.Join(t.TempDir(), "key.pem")),
+		)
+		if err == nil || !strings.Contains(err.Error(), "optional TLS: open ") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func Test_ClientCertKeyUsage(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{
+			Handler: http.NotFoundHandler(),
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{testCertificate(t)},
+				// request certificate without verifying it so the test can use self signed ones
+				ClientAuth: tls.RequireAnyClientCert,
+			},
+			ErrorLog: log.New(io.Discard, "", 0),
+		},
+		Listener(ln),
+		ClientCertKeyUsage(x509.ExtKeyUsageClientAuth),
+	)
+
+	// performs TLS handshake presenting given client certificate
+	handshake := func(cert tls.Certificate) error {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// TLS 1.3 client learns about rejection when reading
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+		return nil
+	}
+
+	t.Run("certificate with client auth usage", func(t *testing.T) {
+		if err := handshake(testCertificateWithUsage(t, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("certificate with any usage", func(t *testing.T) {
+		if err := handshake(testCertificateWithUsage(t, x509.ExtKeyUsageAny)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("certificate without client auth usage", func(t *testing.T) {
+		if err := handshake(testCertificateWithUsage(t, x509.ExtKeyUsageServerAuth)); err == nil {
+			t.Error("expected handshake to fail")
+		}
+	})
+}
+
+// writeTestCertificate writes certificate returned by testCertificate into
+// PEM files and returns names of the certificate and key file.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	cert := testCertificate(t)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("marshaling private key: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// testCertificate returns self signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	return testCertificateWithUsage(t, x509.ExtKeyUsageServerAuth)
+}
+
+// testCertificateWithUsage returns self signed certificate for 127.0.0.1 with given extended key usages.
+func testCertificateWithUsage(t *testing.T, usages ...x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "httpsrv test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func Test_OnClientHello(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx,
+		&http.Server{
+			Handler:   http.NotFoundHandler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+			ErrorLog:  log.New(io.Discard, "", 0),
+		},
+		Listener(ln),
+		OnClientHello(func(hello *tls.ClientHelloInfo) error {
+			if hello.ServerName == "" {
+				return errors.New("SNI is required")
+			}
+			return nil
+		}),
+	)
+
+	handshake := func(serverName string) error {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+
+	t.Run("with SNI", func(t *testing.T) {
+		if err := handshake("example.com"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("without SNI", func(t *testing.T) {
+		// IP address is not sent as SNI
+		if err := handshake("127.0.0.1"); err == nil {
+			t.Error("expected handshake to fail")
+		}
+	})
+}
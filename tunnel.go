@@ -0,0 +1,120 @@
+package httpsrv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+TunnelTracker keeps track of the tunnels (ie established by the CONNECT handler) which are not
+tracked by [http.Server.Shutdown] as their connections have been hijacked. It implements the
+[Drainable] interface so it has to be registered using the [RegisterDrainable] param:
+
+	tunnels := httpsrv.NewTunnelTracker()
+	httpsrv.Run(ctx, srv, httpsrv.RegisterDrainable(tunnels), httpsrv.ShutdownTimeout(10*time.Second))
+
+When the shutdown begins the contexts of the tunnels are cancelled, tunnels which haven't been
+released by the end of the grace budget (see [ShutdownTimeout]) are torn down by closing their
+connections. When the server is closed immediately (no shutdown timeout) the tunnels are closed
+right away.
+*/
+type TunnelTracker struct {
+	m        sync.Mutex
+	tunnels  map[*tunnel]struct{}
+	draining bool
+	done     chan struct{} // closed when the last tunnel is released while draining
+}
+
+type tunnel struct {
+	conns  []io.Closer
+	cancel context.CancelFunc
+}
+
+// NewTunnelTracker creates new tunnel tracker, see [TunnelTracker].
+func NewTunnelTracker() *TunnelTracker {
+	return &TunnelTracker{tunnels: make(map[*tunnel]struct{}), done: make(chan struct{})}
+}
+
+/*
+Track registers tunnel made up of the connections conns (ie the hijacked client connection and
+the connection to the upstream). The returned ctx is cancelled when the shutdown of the server
+begins, the tunnel should be wound down then. The release func must be called when the tunnel
+has been closed. When the server is already draining the connections are closed immediately.
+
+The ctx is not derived from the request context as that is cancelled when the handler returns
+while the tunnel may outlive the handler.
+*/
+func (tt *TunnelTracker) Track(conns ...io.Closer) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &tunnel{conns: conns, cancel: cancel}
+
+	tt.m.Lock()
+	defer tt.m.Unlock()
+	if tt.draining {
+		t.close()
+		return ctx, func() {}
+	}
+	tt.tunnels[t] = struct{}{}
+	return ctx, func() { tt.release(t) }
+}
+
+func (tt *TunnelTracker) release(t *tunnel) {
+	t.cancel()
+	tt.m.Lock()
+	defer tt.m.Unlock()
+	if _, ok := tt.tunnels[t]; !ok {
+		return
+	}
+	delete(tt.tunnels, t)
+	if tt.draining && len(tt.tunnels) == 0 {
+		close(tt.done)
+	}
+}
+
+/*
+Drain cancels the contexts of the tunnels and waits until they have been released, when ctx
+is done before that (or ctx has no deadline) the remaining tunnels are closed.
+*/
+func (tt *TunnelTracker) Drain(ctx context.Context) error {
+	tt.m.Lock()
+	if !tt.draining && len(tt.tunnels) == 0 {
+		close(tt.done)
+	}
+	tt.draining = true
+	for t := range tt.tunnels {
+		t.cancel()
+	}
+	tt.m.Unlock()
+
+	if _, ok := ctx.Deadline(); ok {
+		select {
+		case <-tt.done:
+			return nil
+		case <-ctx.Done():
+		}
+	}
+
+	tt.m.Lock()
+	defer tt.m.Unlock()
+	n := len(tt.tunnels)
+	for t := range tt.tunnels {
+		t.close()
+		delete(tt.tunnels, t)
+	}
+	if n == 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("closed %d tunnels: %w", n, ctx.Err())
+	}
+	return nil
+}
+
+func (t *tunnel) close() {
+	t.cancel()
+	for _, c := range t.conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package httpsrv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_TunnelTracker(t *testing.T) {
+	t.Parallel()
+
+	// starts server with CONNECT handler which echoes the data sent through the tunnel,
+	// when cooperative is true the tunnel is closed when it's context is cancelled.
+	startServer := func(t *testing.T, cooperative bool) (net.Conn, context.CancelFunc, chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		tunnels := NewTunnelTracker()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect {
+				http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Errorf("hijacking connection: %v", err)
+				return
+			}
+			ctx, release := tunnels.Track(conn)
+			go func() {
+				defer release()
+				io.Copy(conn, conn)
+			}()
+			if cooperative {
+				go func() {
+					<-ctx.Done()
+					conn.Close()
+				}()
+			}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Handler: handler},
+				Listener(ln),
+				ShutdownTimeout(200*time.Millisecond),
+				RegisterDrainable(tunnels),
+			)
+		}()
+
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dialing server: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		fmt.Fprintf(c, "CONNECT upstream:443 HTTP/1.1\r\nHost: upstream:443\r\n\r\n")
+		rdr := bufio.NewReader(c)
+		rsp, err := http.ReadResponse(rdr, nil)
+		if err != nil {
+			t.Fatalf("reading CONNECT response: %v", err)
+		}
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected CONNECT response status %s", rsp.Status)
+		}
+		// tunnel is up
+		fmt.Fprint(c, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(rdr, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("expected echo from the tunnel, got %q, error: %v", buf, err)
+		}
+		return c, cancel, srvErr
+	}
+
+	// waits until the tunnel connection is closed by the server, returns the time it took
+	waitClosed := func(t *testing.T, c net.Conn) time.Duration {
+		start := time.Now()
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Errorf("expected tunnel to be closed, got %v", err)
+		}
+		return time.Since(start)
+	}
+
+	t.Run("tunnel is torn down at the end of grace budget", func(t *testing.T) {
+		t.Parallel()
+		c, cancel, srvErr := startServer(t, false)
+		cancel()
+		if d := waitClosed(t, c); d < 150*time.Millisecond {
+			t.Errorf("expected tunnel to be closed after the grace budget, closed after %s", d)
+		}
+		err := <-srvErr
+		expectError(t, err, context.Canceled)
+		expectError(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("tunnel winds down when shutdown begins", func(t *testing.T) {
+		t.Parallel()
+		c, cancel, srvErr := startServer(t, true)
+		cancel()
+		if d := waitClosed(t, c); d > 150*time.Millisecond {
+			t.Errorf("expected tunnel to be closed promptly, closed after %s", d)
+		}
+		err := <-srvErr
+		if errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected shutdown to complete within the grace budget: %v", err)
+		}
+		expectError(t, err, context.Canceled)
+	})
+}
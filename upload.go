@@ -0,0 +1,123 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrUploadCut is returned when reading the body of an upload which was cut off
+// because the graceful shutdown timed out, see [UploadDrainPolicy].
+var ErrUploadCut = errors.New("upload cut off by server shutdown")
+
+/*
+UploadDrainPolicy gives control over the uploads still in progress when the graceful shutdown
+times out (see [ShutdownTimeout]). Request is considered to be an upload when it has known
+content length or it declares "Expect: 100-continue". For each such request the policy is called
+once the shutdown budget has elapsed:
+
+  - when it returns true the upload is allowed to finish, [Run] doesn't return before the
+    handler has completed;
+  - when it returns false the upload is cut off: reading the request body returns [ErrUploadCut]
+    and the request context is cancelled with the same cause. The connection of HTTP/1 request
+    is closed so that the handler blocked on reading the body is released.
+
+Run still reports the graceful shutdown as timed out. The policy has no effect when the server
+is closed immediately (shutdown timeout is zero).
+*/
+func UploadDrainPolicy(policy func(*http.Request) bool) ServerParam {
+	return serverParam{func(cfg *serverConf) {
+		if policy == nil {
+			cfg.uploads = nil
+			return
+		}
+		cfg.uploads = &uploadDrain{policy: policy, active: make(map[*upload]struct{})}
+		cfg.connInCtx = true
+	}}
+}
+
+type uploadDrain struct {
+	policy func(*http.Request) bool
+	m      sync.Mutex
+	active map[*upload]struct{}
+}
+
+type upload struct {
+	r      *http.Request
+	conn   net.Conn // connection of the request, closed when HTTP/1 upload is cut off
+	cancel context.CancelCauseFunc
+	cut    chan struct{} // closed when the upload is cut off
+	done   chan struct{} // closed when the handler returns
+}
+
+func isUpload(r *http.Request) bool {
+	return r.ContentLength > 0 || r.Header.Get("Expect") == "100-continue"
+}
+
+func (ud *uploadDrain) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpload(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		u := &upload{r: r, conn: connFromContext(r.Context()), cancel: cancel, cut: make(chan struct{}), done: make(chan struct{})}
+		ud.m.Lock()
+		ud.active[u] = struct{}{}
+		ud.m.Unlock()
+		defer func() {
+			ud.m.Lock()
+			delete(ud.active, u)
+			ud.m.Unlock()
+			close(u.done)
+		}()
+
+		r = r.WithContext(ctx)
+		r.Body = &uploadBody{ReadCloser: r.Body, cut: u.cut}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+expire is called when the graceful shutdown has timed out, it cuts off the uploads rejected
+by the policy and waits for the rest of the uploads to complete.
+*/
+func (ud *uploadDrain) expire() {
+	ud.m.Lock()
+	var keep []*upload
+	for u := range ud.active {
+		if ud.policy(u.r) {
+			keep = append(keep, u)
+		} else {
+			close(u.cut)
+			u.cancel(ErrUploadCut)
+			if u.r.ProtoMajor == 1 && u.conn != nil {
+				u.conn.Close()
+			}
+		}
+	}
+	ud.m.Unlock()
+
+	for _, u := range keep {
+		<-u.done
+	}
+}
+
+type uploadBody struct {
+	io.ReadCloser
+	cut <-chan struct{}
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	select {
+	case <-b.cut:
+		return 0, ErrUploadCut
+	default:
+		return b.ReadCloser.Read(p)
+	}
+}
@@ -0,0 +1,141 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_UploadDrainPolicy(t *testing.T) {
+	t.Parallel()
+
+	type result struct {
+		n     int
+		err   error
+		cause error
+	}
+
+	// starts upload of 10 bytes of which only first 5 are sent, the rest
+	// is sent when the returned func is called.
+	upload := func(t *testing.T, keep bool) (srvErr chan error, handlerDone chan result, finish func()) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+
+		inHandler := make(chan struct{})
+		handlerDone = make(chan result, 1)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(inHandler)
+			b, err := io.ReadAll(r.Body)
+			handlerDone <- result{n: len(b), err: err, cause: context.Cause(r.Context())}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		srvErr = make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx, &http.Server{Handler: handler},
+				Listener(ln),
+				ShutdownTimeout(100*time.Millisecond),
+				UploadDrainPolicy(func(r *http.Request) bool { return keep }),
+			)
+		}()
+
+		pr, pw := io.Pipe()
+		t.Cleanup(func() { pw.Close() })
+		req, err := http.NewRequest("PUT", "http://"+ln.Addr().String(), pr)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		req.ContentLength = 10
+		go func() {
+			if rsp, err := http.DefaultClient.Do(req); err == nil {
+				rsp.Body.Close()
+			}
+		}()
+		if _, err := pw.Write([]byte("01234")); err != nil {
+			t.Fatalf("writing body: %v", err)
+		}
+		<-inHandler
+		cancel()
+		return srvErr, handlerDone, func() { pw.Write([]byte("56789")) }
+	}
+
+	t.Run("upload is allowed to finish", func(t *testing.T) {
+		t.Parallel()
+		srvErr, handlerDone, finish := upload(t, true)
+
+		// the shutdown budget elapses but Run must wait for the upload
+		select {
+		case err := <-srvErr:
+			t.Fatalf("Run returned before upload completed: %v", err)
+		case <-time.After(300 * time.Millisecond):
+		}
+		finish()
+
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("handler didn't return within timeout")
+		case res := <-handlerDone:
+			if res.err != nil || res.n != 10 {
+				t.Errorf("expected whole body to be read, got %d bytes, error: %v", res.n, res.err)
+			}
+			if res.cause != nil {
+				t.Errorf("expected request context not to be cancelled, got %v", res.cause)
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("upload is cut off", func(t *testing.T) {
+		t.Parallel()
+		srvErr, handlerDone, _ := upload(t, false)
+
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("handler didn't return within timeout")
+		case res := <-handlerDone:
+			if res.err == nil || res.n != 5 {
+				t.Errorf("expected reading the body to fail after 5 bytes, got %d bytes, error: %v", res.n, res.err)
+			}
+			if !errors.Is(res.cause, ErrUploadCut) {
+				t.Errorf("expected request context to be cancelled with ErrUploadCut, got %v", res.cause)
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+			t.Error("Run didn't return within timeout")
+		case err := <-srvErr:
+			expectError(t, err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("non-upload requests are not tracked", func(t *testing.T) {
+		cfg := &serverConf{}
+		UploadDrainPolicy(func(r *http.Request) bool { return true }).apply(cfg)
+		var tracked int
+		h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg.uploads.m.Lock()
+			tracked = len(cfg.uploads.active)
+			cfg.uploads.m.Unlock()
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		h.ServeHTTP(nil, req)
+		if tracked != 0 {
+			t.Errorf("expected GET request not to be tracked as upload")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+/*
+VersionInfo describes the build of the service, see [VersionEndpoint]. Typically the fields
+are assigned using linker flags (ie -ldflags "-X main.version=v1.2.3") or from [debug.ReadBuildInfo].
+
+[debug.ReadBuildInfo]: https://pkg.go.dev/runtime/debug#ReadBuildInfo
+*/
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"` // when empty the version of the Go runtime is reported
+}
+
+/*
+VersionEndpoint makes the server to respond to GET requests to the path with the info encoded
+as JSON object, other methods get response with status 405 (Method Not Allowed). Requests to
+the path are not passed to the server's handler. Empty path disables the endpoint.
+*/
+func VersionEndpoint(path string, info VersionInfo) ServerParam {
+	if info.GoVersion == "" {
+		info.GoVersion = runtime.Version()
+	}
+	return serverParam{func(cfg *serverConf) { cfg.versionPath, cfg.versionInfo = path, info }}
+}
+
+func (cfg *serverConf) versionEndpoint(next http.Handler) http.Handler {
+	body, _ := json.Marshal(cfg.versionInfo)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != cfg.versionPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
@@ -0,0 +1,69 @@
+package httpsrv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func Test_VersionEndpoint(t *testing.T) {
+	t.Parallel()
+
+	cfg := &serverConf{}
+	VersionEndpoint("/version", VersionInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2023-11-11T10:00:00Z"}).apply(cfg)
+	h := cfg.wrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	t.Run("GET", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/version")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected Content-Type %q", ct)
+		}
+		var got map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		exp := map[string]string{
+			"version":    "v1.2.3",
+			"commit":     "abc123",
+			"build_time": "2023-11-11T10:00:00Z",
+			"go_version": runtime.Version(),
+		}
+		if len(got) != len(exp) {
+			t.Errorf("expected %d fields, got %v", len(exp), got)
+		}
+		for k, v := range exp {
+			if got[k] != v {
+				t.Errorf("expected %s to be %q, got %q", k, v, got[k])
+			}
+		}
+	})
+
+	t.Run("POST is not allowed", func(t *testing.T) {
+		rec := serve(http.MethodPost, "/version")
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status 405, got %d", rec.Code)
+		}
+		if allow := rec.Header().Get("Allow"); allow != "GET" {
+			t.Errorf("unexpected Allow header %q", allow)
+		}
+	})
+
+	t.Run("other paths are passed to handler", func(t *testing.T) {
+		if rec := serve(http.MethodPost, "/foo"); rec.Code != http.StatusNoContent {
+			t.Errorf("expected status 204, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package httpsrv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+/*
+Warmup sets func which is called after the listener has been bound (and [PostBind] hook has
+succeeded) but before the server starts serving requests - the port is open (so orchestrator
+sees the socket) while service pre-fills it's caches. When the warmup func returns error the
+server is stopped and [Run] returns the error. The ctx passed to warmup func is cancelled
+when the server is stopped.
+
+When reject is false the connections wait (in the listen backlog) until the warmup is done,
+otherwise the server starts serving immediately and responds with status 503 (Service
+Unavailable) to all requests until the warmup has completed (except the health probes, see
+[ReadyWhen]). Unlike [ReadyWhen] the warmup func is not retried.
+*/
+func Warmup(warm func(ctx context.Context) error, reject bool) ServerParam {
+	return serverParam{func(cfg *serverConf) { cfg.warmup = &warmup{warm: warm, reject: reject} }}
+}
+
+type warmup struct {
+	warm   func(ctx context.Context) error
+	reject bool        // respond with 503 while warming up
+	done   atomic.Bool // warmup has completed successfully
+}
+
+func (wu *warmup) run(ctx context.Context) error {
+	if err := wu.warm(ctx); err != nil {
+		return fmt.Errorf("warmup: %w", err)
+	}
+	wu.done.Store(true)
+	return nil
+}
+
+/*
+serveFunc returns serve func which runs the warmup concurrently with serving when
+rejecting requests while warming up, the server is closed when the warmup fails.
+*/
+func (wu *warmup) serveFunc(ctx context.Context, srv *http.Server, serve func() error) func() error {
+	if !wu.reject {
+		return serve
+	}
+	return func() error {
+		warmErr := make(chan error, 1)
+		go func() {
+			if err := wu.run(ctx); err != nil && ctx.Err() == nil {
+				warmErr <- err
+				srv.Close()
+			}
+		}()
+		err := serve()
+		select {
+		case e := <-warmErr:
+			return e
+		default:
+			return err
+		}
+	}
+}
+
+func (wu *warmup) wrap(cfg *serverConf, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wu.done.Load() && !cfg.isProbe(r) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package httpsrv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Warmup(t *testing.T) {
+	t.Parallel()
+
+	// starts server with Warmup param, returns it's address and chan which receives Run's error
+	startServer := func(t *testing.T, warm func(ctx context.Context) error, reject bool) (string, chan error) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to create listener: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		srvErr := make(chan error, 1)
+		go func() {
+			srvErr <- Run(ctx,
+				&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})},
+				Listener(ln),
+				Warmup(warm, reject),
+			)
+		}()
+		t.Cleanup(cancel)
+		return "http://" + ln.Addr().String(), srvErr
+	}
+
+	t.Run("requests wait for warmup", func(t *testing.T) {
+		const delay = 200 * time.Millisecond
+		addr, _ := startServer(t, func(ctx context.Context) error { time.Sleep(delay); return nil }, false)
+
+		start := time.Now()
+		rsp, err := http.Get(addr)
+		if err != nil {
+			t.Fatalf("GET request failed: %v", err)
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("expected status 200, got %d", rsp.StatusCode)
+		}
+		if d := time.Since(start); d < delay/2 {
+			t.Errorf("expected request to wait for warmup, took %s", d)
+		}
+	})
+
+	t.Run("requests are rejected while warming up", func(t *testing.T) {
+		release := make(chan struct{})
+		addr, _ := startServer(t, func(ctx context.Context) error { <-release; return nil }, true)
+
+		status := func() int {
+			t.Helper()
+			rsp, err := http.Get(addr)
+			if err != nil {
+				t.Fatalf("GET request failed: %v", err)
+			}
+			rsp.Body.Close()
+			return rsp.StatusCode
+		}
+		if code := status(); code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503 while warming up, got %d", code)
+		}
+		close(release)
+		for i := 0; status() != http.StatusOK; i++ {
+			if i == 20 {
+				t.Fatal("server didn't start serving after warmup")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("warmup fails", func(t *testing.T) {
+		warmErr := errors.New("cache unavailable")
+		for _, reject := range []bool{false, true} {
+			_, srvErr := startServer(t, func(ctx context.Context) error { return warmErr }, reject)
+			select {
+			case <-time.After(time.Second):
+				t.Errorf("Run didn't return within timeout (reject=%t)", reject)
+			case err := <-srvErr:
+				expectError(t, err, warmErr)
+			}
+		}
+	})
+}